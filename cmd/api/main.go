@@ -13,6 +13,10 @@ package main
 // @license.url https://opensource.org/licenses/MIT
 // @host localhost:3000
 // @BasePath /v1
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT from /auth/login or /auth/admin/login, as "Bearer <token>"
 import (
 	_ "github.com/Abdurrochman25/multi-tenant-messaging-system/docs" // docs is generated by Swag CLI, you have to import it.
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal"
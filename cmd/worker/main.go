@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
@@ -12,8 +17,28 @@ import (
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/aarondl/sqlboiler/v4/queries/qm"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const tenantSyncInterval = 10 * time.Second
+
+// settleTimeout bounds how long a shrinking pool waits for its busy workers
+// before a worker count change is acknowledged.
+const settleTimeout = 5 * time.Second
+
+// lifecycle serializes control messages, syncs, consumer recovery after a
+// reconnect and heartbeats stopping tenants whose leases lapsed, which would
+// otherwise race to start the same tenant. Pools resized under it settle
+// side by side and within settleTimeout, so that no tenant's busy workers
+// hold it for long.
+var lifecycle sync.Mutex
+
+// configured is the per-node worker count last applied from the tenants
+// table. Syncs only resize a pool when that value changes, leaving pools
+// the autoscaler resized alone. Guarded by lifecycle.
+var configured = make(map[string]int)
+
 func main() {
 	conf := config.NewConfig()
 	s := config.NewServer(conf)
@@ -26,87 +51,402 @@ func main() {
 		log.Fatalf("Failed to initialize database; error: %v", err)
 	}
 
-	// Init RabbitMQ
-	mqUrl := conf.RabbitMQ.ConnectionString()
-	mqClient, err := mq.Dial(mqUrl)
+	// Init queue backend
+	broker, mqClient, err := internal.NewBroker(s)
 	if err != nil {
-		log.Fatalf("Failed to initialize rabbitmq; error: %v", err)
-	}
-	mqChannel, err := mqClient.Channel()
-	if err != nil {
-		log.Fatalf("Failed to initialize rabbitmq; error: %v", err)
+		log.Fatalf("Failed to initialize queue backend; error: %v", err)
 	}
+	budget := services.NewWorkerBudget(s.Config.Worker.Budget)
+	tm := services.NewTenantManager(s.DB, broker, internal.NewRegistry(s), budget, s.Config.Worker.DrainTimeout)
+	monitoring := services.NewMonitoringService()
 
-	// Declare control infra
-	ctrlCh, _ := mqClient.Channel()
-	defer ctrlCh.Close()
-	if err := ctrlCh.ExchangeDeclare(control.Exchange, "direct", true, false, false, false, nil); err != nil {
-		log.Fatalf("Failed to declare exchange: %v", err)
+	if addr := s.Config.Worker.MetricsAddr; addr != "" {
+		go serveMetrics(addr, budget, monitoring)
 	}
 
-	rabbitmqService := services.NewRabbitMQService(mqClient.Conn, mqChannel)
-	tm := services.NewTenantManager(s.DB, rabbitmqService)
+	// Register this node for tenant sharding
+	hostname, _ := os.Hostname()
+	nodeID := s.Config.Worker.ID
+	if nodeID == "" {
+		nodeID = hostname
+	}
+	shards := services.NewShardCoordinator(s.DB, nodeID, hostname, s.Config.Worker.LeaseTTL, s.Config.Worker.Replicas)
+	shards.ReportUsage(tm.Usage)
 
-	// Restore tenants from DB (id, workers)
 	ctx := context.Background()
-	tenants, err := models.Tenants(qm.Select("id", "current_workers"), qm.Where("deleted_at IS NULL")).All(ctx, s.DB)
-	if err != nil {
+	if err := shards.Join(ctx); err != nil {
+		log.Fatalf("Failed to join worker nodes: %v", err)
+	}
+
+	// Restore the tenants this node owns from DB (id, workers)
+	if err := syncTenants(ctx, s, tm, shards); err != nil {
 		log.Fatalf("Failed to restore tenants from DB: %v", err)
 	}
-	log.Printf("tenants : %v", tenants)
+	go heartbeat(ctx, tm, shards, s.Config.Worker.HeartbeatInterval)
+
+	// Control messages apply changes right away; the periodic sync catches
+	// up on anything missed while disconnected, rebalances tenants between
+	// nodes, and is the only source of changes without RabbitMQ.
+	go syncTenantsLoop(ctx, s, tm, shards)
+	if mqClient != nil {
+		mqClient.OnReconnect(func(conn *amqp.Connection) {
+			// Tenants stopped by a control message or handed to another
+			// node meanwhile stay stopped
+			lifecycle.Lock()
+			defer lifecycle.Unlock()
+			tm.RecoverConsumers()
+		})
+		go consumeControl(ctx, mqClient, s, tm, shards)
+	}
+
+	ctxAutoscale, stopAutoscale := context.WithCancel(ctx)
+	defer stopAutoscale()
+	if autoscale := s.Config.Autoscale; autoscale.Enabled {
+		autoscaler := services.NewAutoscaler(s.DB, broker, tm, monitoring, services.AutoscalerConfig{
+			Interval:             autoscale.Interval,
+			MinWorkers:           autoscale.MinWorkers,
+			ScaleUpBacklog:       autoscale.ScaleUpBacklog,
+			ScaleDownUtilization: autoscale.ScaleDownUtilization,
+			ScaleDownSamples:     autoscale.ScaleDownSamples,
+			ScaleUpCooldown:      autoscale.ScaleUpCooldown,
+			ScaleDownCooldown:    autoscale.ScaleDownCooldown,
+			Replicas:             shards.Replicas,
+		})
+		go autoscaler.Run(ctxAutoscale)
+	}
+
+	// Setup graceful shutdown
+	shutdownManager := &services.ShutdownManager{
+		TenantManager: tm,
+		// Keep syncs, control messages and the autoscaler from touching
+		// consumers while they drain; heartbeats keep our leases meanwhile
+		Quiesce: func() {
+			stopAutoscale()
+			lifecycle.Lock()
+		},
+	}
+	// Start graceful shutdown handler
+	shutdownManager.GracefulShutdown()
+
+	// Hand our tenants over without waiting for the leases to expire
+	if err := shards.Leave(context.Background()); err != nil {
+		log.Printf("Failed to release tenant assignments: %v", err)
+	}
+}
+
+// syncTenants reconciles this process with the tenants table: it rebalances
+// tenant ownership between nodes, starts consumers for owned tenants,
+// applies worker count changes and stops the consumers of tenants that were
+// deleted or moved to another node.
+func syncTenants(ctx context.Context, s *config.Server, tm *services.TenantManager, shards *services.ShardCoordinator) error {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	return reconcile(ctx, s, tm, shards)
+}
+
+func reconcile(ctx context.Context, s *config.Server, tm *services.TenantManager, shards *services.ShardCoordinator) error {
+	owned, err := shards.Rebalance(ctx)
+	if err != nil {
+		return err
+	}
+
+	tenants, err := models.Tenants(qm.Select("id", "current_workers", "deleted_at", "status"),
+		qm.Where("deleted_at IS NULL OR status = ?", services.TenantStatusDeleting)).All(ctx, s.DB)
+	if err != nil {
+		return err
+	}
+
+	configs := services.NewTenantConfigStore(s.DB, s.Config.AppSecret)
+	scheduling, err := configs.Scheduling(ctx)
+	if err != nil {
+		return err
+	}
+	prefetch, err := configs.Prefetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	resizes := make(map[string]int)
 	for _, tenant := range tenants {
-		worker := tenant.CurrentWorkers.Int
-		if worker <= 0 {
-			worker = 3
+		if tenant.DeletedAt.Valid {
+			// Deleted while no worker was listening
+			if err := tm.DeleteTenant(ctx, tenant.ID); err != nil {
+				log.Printf("delete tenant, tenant: %s, err: %s", tenant.ID, err.Error())
+			}
+			delete(configured, tenant.ID)
+			continue
+		}
+		if !owned[tenant.ID] {
+			continue
+		}
+		if tenant.Status.String == services.TenantStatusSuspended {
+			// Its queue buffers messages until it is resumed
+			if tm.Running(tenant.ID) {
+				tm.StopTenant(tenant.ID)
+			}
+			delete(configured, tenant.ID)
+			continue
+		}
+
+		worker := nodeWorkers(tenant.CurrentWorkers.Int, shards)
+
+		// Overrides apply to consumers started below and to running ones
+		schedule, ok := scheduling[tenant.ID]
+		if !ok {
+			schedule = services.SchedulingConfig{Weight: 1}
+		}
+		tm.Schedule(tenant.ID, schedule)
+		if err := tm.SetPrefetch(tenant.ID, prefetch[tenant.ID].Prefetch); err != nil {
+			log.Printf("prefetch tenant, tenant: %s, err: %s", tenant.ID, err.Error())
+		}
+
+		if tm.Running(tenant.ID) {
+			// A resume is acknowledged even if this node missed the
+			// suspension and kept consuming
+			if tenant.Status.String == services.TenantStatusProvisioning {
+				if err := tm.ReportActive(ctx, tenant.ID); err != nil {
+					log.Printf("activate tenant, tenant: %s, err: %s", tenant.ID, err.Error())
+				}
+			}
+			if configured[tenant.ID] != worker {
+				resizes[tenant.ID] = worker
+			}
+			continue
 		}
+
 		if err := tm.StartTenant(ctx, tenant.ID, worker); err != nil {
 			log.Printf("start tenant, tenant: %s, err: %s", tenant.ID, err.Error())
+			continue
 		}
+		configured[tenant.ID] = worker
 	}
 
-	// Subscribe to control exchange
-	// Each worker has its own control queue (exclusive) bound to all 3 routing keys
-	qch, _ := mqClient.Channel()
-	defer qch.Close()
-	q, err := qch.QueueDeclare("", true, true, true, false, nil)
-	if err != nil {
-		log.Fatal(err)
+	for tenantID, err := range resizeTenants(ctx, tm, resizes) {
+		if err != nil {
+			log.Printf("update tenant, tenant: %s, err: %s", tenantID, err.Error())
+			continue
+		}
+		configured[tenantID] = resizes[tenantID]
 	}
-	for _, rk := range []string{control.RKCreate, control.RKUpdate, control.RKDelete} {
-		if err := qch.QueueBind(q.Name, rk, control.Exchange, false, nil); err != nil {
-			log.Fatal(err)
+
+	for _, tenantID := range tm.Tenants() {
+		if !owned[tenantID] {
+			tm.StopTenant(tenantID)
+			delete(configured, tenantID)
 		}
 	}
 
-	delivs, err := qch.Consume(q.Name, "", true, true, false, false, nil)
-	if err != nil {
-		log.Fatal(err)
+	return nil
+}
+
+// resizeTenants applies worker counts concurrently and waits up to
+// settleTimeout for shrinking pools to settle. It returns each tenant's
+// error.
+func resizeTenants(ctx context.Context, tm *services.TenantManager, workers map[string]int) map[string]error {
+	ctxSettle, cancel := context.WithTimeout(ctx, settleTimeout)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  = make(map[string]error, len(workers))
+	)
+	for tenantID, worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := tm.UpdateConcurrency(ctxSettle, tenantID, worker)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			errs[tenantID] = err
+		}()
 	}
+	wg.Wait()
 
-	// 3) Main control loop
-	for d := range delivs {
-		msg, ok := mq.ParseJSON[control.Message](d.Body)
-		if !ok {
+	return errs
+}
+
+// nodeWorkers splits a tenant's worker count between the nodes it is
+// spread across, so the tenant stays within it across all of them.
+func nodeWorkers(workers int, shards *services.ShardCoordinator) int {
+	if workers <= 0 {
+		workers = 3
+	}
+
+	return max(workers/shards.Replicas(), 1)
+}
+
+// serveMetrics exposes the worker's Prometheus metrics, e.g. queue depth,
+// worker pool sizes and autoscaler decisions, and the per-tenant allocation
+// of the worker budget as JSON on /allocations.
+func serveMetrics(addr string, budget *services.WorkerBudget, monitoring *services.MonitoringService) {
+	metrics := promhttp.Handler()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		monitoring.UpdateWorkerBudget(budget.Report())
+		metrics.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/allocations", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(budget.Report())
+	})
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Failed to serve metrics on %s: %v", addr, err)
+	}
+}
+
+// heartbeat renews the node's leases and stops the consumers of tenants it
+// no longer owns right away, rather than at the next sync: those whose
+// leases lapsed because heartbeats failed, and those claimed by another
+// node since. A heartbeat gives up when the leases lapse, so a hanging
+// database cannot keep it from stopping them; once they lapsed, it gives up
+// after an interval.
+func heartbeat(ctx context.Context, tm *services.TenantManager, shards *services.ShardCoordinator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deadline := shards.LeaseExpiresAt()
+		if time.Until(deadline) <= 0 {
+			deadline = time.Now().Add(interval)
+		}
+		ctxLease, cancel := context.WithDeadline(ctx, deadline)
+		if err := shards.Heartbeat(ctxLease); err != nil {
+			log.Printf("Failed to heartbeat node %s: %v", shards.NodeID(), err)
+		}
+		cancel()
+
+		stopDisowned(tm, shards)
+	}
+}
+
+// stopDisowned skips a turn while consumers are being reconciled or
+// drained for shutdown, so that heartbeats never wait on them.
+func stopDisowned(tm *services.TenantManager, shards *services.ShardCoordinator) {
+	if !lifecycle.TryLock() {
+		return
+	}
+	defer lifecycle.Unlock()
+
+	for _, tenantID := range tm.Tenants() {
+		if !shards.Owns(tenantID) {
+			log.Printf("Node %s no longer owns tenant %s, stopping its consumer", shards.NodeID(), tenantID)
+			tm.StopTenant(tenantID)
+			delete(configured, tenantID)
+		}
+	}
+}
+
+func syncTenantsLoop(ctx context.Context, s *config.Server, tm *services.TenantManager, shards *services.ShardCoordinator) {
+	ticker := time.NewTicker(tenantSyncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := syncTenants(ctx, s, tm, shards); err != nil {
+			log.Printf("Failed to sync tenants from DB: %v", err)
+		}
+	}
+}
+
+// consumeControl is the main control loop, resubscribing whenever the
+// connection is replaced.
+func consumeControl(ctx context.Context, mqClient *mq.Client, s *config.Server, tm *services.TenantManager, shards *services.ShardCoordinator) {
+	for {
+		delivs, err := subscribeControl(mqClient)
+		if err != nil {
+			log.Printf("Failed to subscribe to control exchange, retrying; error: %v", err)
+			time.Sleep(time.Second)
 			continue
 		}
-		switch d.RoutingKey {
-		case control.RKCreate:
-			if _, err := tm.CreateTenant(ctx, msg.TenantID, int(msg.Workers)); err != nil {
-				log.Printf("create/start, tenant: %s, err: %s", msg.TenantID, err.Error())
-			}
-		case control.RKUpdate:
-			if msg.Workers > 0 {
-				tm.UpdateConcurrency(ctx, msg.TenantID, &models.TenantConfigRequest{Workers: int(msg.Workers)})
+
+		for d := range delivs {
+			handleControl(ctx, s, tm, shards, d)
+		}
+
+		if mqClient.State() == mq.StateClosed {
+			return
+		}
+		log.Println("control channel closed, resubscribing")
+	}
+}
+
+// handleControl applies one control message and acknowledges it. A failed
+// command is retried once; after that the periodic sync takes over.
+func handleControl(ctx context.Context, s *config.Server, tm *services.TenantManager, shards *services.ShardCoordinator, d amqp.Delivery) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	msg, ok := mq.ParseJSON[control.Message](d.Body)
+	if !ok || msg.TenantID == "" {
+		log.Printf("Dropping malformed control message %s", d.MessageId)
+		d.Nack(false, false)
+		return
+	}
+	if !msg.Supported() {
+		log.Printf("Dropping control message %s of unsupported version %d", msg.ID, msg.Version)
+		d.Nack(false, false)
+		return
+	}
+
+	var err error
+	switch d.RoutingKey {
+	case control.RKCreate:
+		// Every node hears about the tenant; rebalancing decides who owns it
+		err = reconcile(ctx, s, tm, shards)
+	case control.RKUpdate:
+		if msg.Workers > 0 && tm.Running(msg.TenantID) {
+			worker := nodeWorkers(int(msg.Workers), shards)
+			ctxSettle, cancel := context.WithTimeout(ctx, settleTimeout)
+			err = tm.UpdateConcurrency(ctxSettle, msg.TenantID, worker)
+			cancel()
+			if err == nil {
+				configured[msg.TenantID] = worker
 			}
-		case control.RKDelete:
-			_ = tm.DeleteTenant(ctx, msg.TenantID)
 		}
+	case control.RKDelete:
+		err = tm.DeleteTenant(ctx, msg.TenantID)
+		delete(configured, msg.TenantID)
+	case control.RKSuspend:
+		tm.StopTenant(msg.TenantID)
+		delete(configured, msg.TenantID)
+	case control.RKResume:
+		err = reconcile(ctx, s, tm, shards)
 	}
 
-	// Setup graceful shutdown
-	shutdownManager := &services.ShutdownManager{
-		TenantManager: tm,
+	if err != nil {
+		log.Printf("%s, tenant: %s, command: %s, err: %s", d.RoutingKey, msg.TenantID, msg.ID, err.Error())
+		d.Nack(false, !d.Redelivered)
+		return
 	}
-	// Start graceful shutdown handler
-	shutdownManager.GracefulShutdown()
+
+	d.Ack(false)
+}
+
+// subscribeControl declares the control exchange and binds an exclusive
+// queue for this worker to every tenant routing key.
+func subscribeControl(mqClient *mq.Client) (<-chan amqp.Delivery, error) {
+	ch, err := mqClient.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := control.Declare(ch); err != nil {
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare("", true, true, true, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, rk := range []string{control.RKCreate, control.RKUpdate, control.RKDelete, control.RKSuspend, control.RKResume} {
+		if err := ch.QueueBind(q.Name, rk, control.Exchange, false, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ch.Consume(q.Name, "", false, true, false, false, nil)
 }
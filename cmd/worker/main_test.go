@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// failingBroker is a MemoryBroker that cannot delete queues.
+type failingBroker struct {
+	*services.MemoryBroker
+}
+
+func (b *failingBroker) DeleteTenantQueue(tenantID string) error {
+	return errors.New("broker unavailable")
+}
+
+// settlement records how a delivery was settled.
+type settlement struct {
+	acked    bool
+	nacked   bool
+	requeued bool
+}
+
+func (s *settlement) Ack(tag uint64, multiple bool) error {
+	s.acked = true
+	return nil
+}
+
+func (s *settlement) Nack(tag uint64, multiple bool, requeue bool) error {
+	s.nacked, s.requeued = true, requeue
+	return nil
+}
+
+func (s *settlement) Reject(tag uint64, requeue bool) error {
+	return s.Nack(tag, false, requeue)
+}
+
+func TestHandleControl(t *testing.T) {
+	tests := []struct {
+		name        string
+		routingKey  string
+		version     int
+		redelivered bool
+		want        settlement
+		running     bool
+	}{
+		{
+			name:       "applies a current message",
+			routingKey: control.RKSuspend,
+			version:    control.Version,
+			want:       settlement{acked: true},
+		},
+		{
+			name:       "reads a message without version as the first",
+			routingKey: control.RKSuspend,
+			want:       settlement{acked: true},
+		},
+		{
+			name:       "skips a newer version",
+			routingKey: control.RKSuspend,
+			version:    control.Version + 1,
+			want:       settlement{nacked: true},
+			running:    true,
+		},
+		{
+			name:       "requeues a failed command",
+			routingKey: control.RKDelete,
+			version:    control.Version,
+			want:       settlement{nacked: true, requeued: true},
+		},
+		{
+			name:        "drops a command that failed again",
+			routingKey:  control.RKDelete,
+			version:     control.Version,
+			redelivered: true,
+			want:        settlement{nacked: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &failingBroker{MemoryBroker: services.NewMemoryBroker(time.Millisecond)}
+			if err := broker.CreateTenantQueue("t1"); err != nil {
+				t.Fatal(err)
+			}
+			tm := services.NewTenantManager(nil, broker, nil, services.NewWorkerBudget(0), time.Second)
+			if err := tm.StartConsumer("t1", 1); err != nil {
+				t.Fatal(err)
+			}
+			defer tm.StopAllConsumers()
+
+			msg := control.NewMessage("t1", 0)
+			msg.Version = tt.version
+			body, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got settlement
+			handleControl(context.Background(), nil, tm, nil, amqp.Delivery{
+				Acknowledger: &got,
+				RoutingKey:   tt.routingKey,
+				Redelivered:  tt.redelivered,
+				Body:         body,
+			})
+
+			if got != tt.want {
+				t.Fatalf("expected the delivery to be settled as %+v, got %+v", tt.want, got)
+			}
+			if tm.Running("t1") != tt.running {
+				t.Fatalf("expected the consumer running to be %v", tt.running)
+			}
+		})
+	}
+}
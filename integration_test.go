@@ -2,23 +2,37 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/handlers"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/router"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type TestSuite struct {
@@ -29,6 +43,8 @@ type TestSuite struct {
 	app        *fiber.App
 	dbURL      string
 	mqURL      string
+	adminToken string
+	broker     *services.RabbitMQService
 }
 
 func TestIntegration(t *testing.T) {
@@ -43,8 +59,22 @@ func TestIntegration(t *testing.T) {
 	// Run tests
 	t.Run("TenantLifecycle", suite.TestTenantLifecycle)
 	t.Run("MessagePublishing", suite.TestMessagePublishing)
+	t.Run("UnroutablePublish", suite.TestUnroutablePublish)
+	t.Run("QueuePriority", suite.TestQueuePriority)
+	t.Run("QueueMigration", suite.TestQueueMigration)
+	t.Run("RetryOnOutdatedQueue", suite.TestRetryOnOutdatedQueue)
+	t.Run("DeadLetterReplay", suite.TestDeadLetterReplay)
 	t.Run("ConcurrencyUpdate", suite.TestConcurrencyUpdate)
+	t.Run("SuspendResume", suite.TestSuspendResume)
+	t.Run("TenantReadUpdate", suite.TestTenantReadUpdate)
+	t.Run("TenantPurge", suite.TestTenantPurge)
+	t.Run("TenantExportImport", suite.TestTenantExportImport)
+	t.Run("TenantUsers", suite.TestTenantUsers)
+	t.Run("Authorization", suite.TestAuthorization)
 	t.Run("CursorPagination", suite.TestCursorPagination)
+	t.Run("Sharding", suite.TestSharding)
+	t.Run("PostgresLeaseRenewal", suite.TestPostgresLeaseRenewal)
+	t.Run("ScheduledMessages", suite.TestScheduledMessages)
 }
 
 func (s *TestSuite) Setup() error {
@@ -59,7 +89,7 @@ func (s *TestSuite) Setup() error {
 	// Start PostgreSQL container
 	s.pgResource, err = s.pool.RunWithOptions(&dockertest.RunOptions{
 		Repository: "postgres",
-		Tag:        "13",
+		Tag:        "15",
 		Env: []string{
 			"POSTGRES_PASSWORD=secret",
 			"POSTGRES_USER=user",
@@ -156,6 +186,11 @@ func (s *TestSuite) runMigrations() error {
 			scheduled_at TIMESTAMPTZ,
 			processed_at TIMESTAMPTZ,
 			created_at TIMESTAMPTZ DEFAULT NOW(),
+			headers JSONB,
+			visible_at TIMESTAMPTZ,
+			lease_token UUID,
+			delivery_count INTEGER DEFAULT 0,
+			priority SMALLINT DEFAULT 0,
 			PRIMARY KEY (id, tenant_id)
 		) PARTITION BY LIST (tenant_id);`,
 		`CREATE TABLE IF NOT EXISTS tenant_configs (
@@ -163,6 +198,8 @@ func (s *TestSuite) runMigrations() error {
 			tenant_id UUID NOT NULL REFERENCES tenants(id),
 			config_key VARCHAR(255) NOT NULL,
 			config_value JSONB,
+			version INTEGER DEFAULT 1,
+			is_active BOOLEAN DEFAULT true,
 			created_at TIMESTAMPTZ DEFAULT NOW(),
 			updated_at TIMESTAMPTZ DEFAULT NOW()
 		);`,
@@ -174,6 +211,7 @@ func (s *TestSuite) runMigrations() error {
 			status VARCHAR(50),
 			error_message TEXT,
 			processing_duration_ms INTEGER,
+			response_status INTEGER,
 			created_at TIMESTAMPTZ DEFAULT NOW()
 		);`,
 		`CREATE TABLE IF NOT EXISTS dead_letter_messages (
@@ -186,6 +224,61 @@ func (s *TestSuite) runMigrations() error {
 			last_error TEXT,
 			created_at TIMESTAMPTZ DEFAULT NOW()
 		);`,
+		`CREATE TABLE IF NOT EXISTS outbox_messages (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			message_id UUID NOT NULL,
+			tenant_id UUID NOT NULL,
+			payload JSONB NOT NULL,
+			headers JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(50) DEFAULT 'pending',
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMPTZ DEFAULT NOW(),
+			published_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);`,
+		`CREATE TABLE IF NOT EXISTS worker_nodes (
+			id VARCHAR(100) PRIMARY KEY,
+			hostname VARCHAR(255),
+			started_at TIMESTAMPTZ DEFAULT NOW(),
+			heartbeat_at TIMESTAMPTZ DEFAULT NOW()
+		);`,
+		`CREATE TABLE IF NOT EXISTS tenant_assignments (
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			slot INTEGER NOT NULL DEFAULT 0,
+			node_id VARCHAR(100) NOT NULL,
+			lease_expires_at TIMESTAMPTZ NOT NULL,
+			assigned_at TIMESTAMPTZ DEFAULT NOW(),
+			workers INTEGER,
+			busy INTEGER,
+			PRIMARY KEY (tenant_id, slot)
+		);`,
+		`CREATE TABLE IF NOT EXISTS tenant_purges (
+			tenant_id UUID PRIMARY KEY,
+			tenant_name VARCHAR(255) NOT NULL,
+			step VARCHAR(50) NOT NULL,
+			archive_path TEXT,
+			last_error TEXT,
+			deleted_at TIMESTAMPTZ NOT NULL,
+			started_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			completed_at TIMESTAMPTZ
+		);`,
+		`CREATE TABLE IF NOT EXISTS tenant_users (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			tenant_id UUID NOT NULL REFERENCES tenants(id),
+			username VARCHAR(255) NOT NULL,
+			password_hash TEXT NOT NULL,
+			roles TEXT[] NOT NULL DEFAULT '{}',
+			failed_logins INTEGER NOT NULL DEFAULT 0,
+			locked_until TIMESTAMPTZ,
+			disabled_at TIMESTAMPTZ,
+			last_login_at TIMESTAMPTZ,
+			token_version INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE (tenant_id, username)
+		);`,
 	}
 
 	for _, migration := range migrations {
@@ -202,9 +295,18 @@ func (s *TestSuite) setupApp() error {
 	pgPort, _ := strconv.Atoi(s.pgResource.GetPort("5432/tcp"))
 	mqPort, _ := strconv.Atoi(s.mqResource.GetPort("5672/tcp"))
 
+	adminPasswordHash, err := bcrypt.GenerateFromPassword([]byte("test-admin-password"), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
 	// Create test config
 	conf := config.Config{
 		AppSecret: "test-secret",
+		Auth: config.Auth{
+			AdminUsername:     "root",
+			AdminPasswordHash: string(adminPasswordHash),
+		},
 		Database: config.Database{
 			DatabaseName: "testdb",
 			Host:         "localhost",
@@ -239,22 +341,98 @@ func (s *TestSuite) setupApp() error {
 	}
 
 	// Initialize services
-	rabbitmqService := services.NewRabbitMQService(mqClient.Conn, mqChannel)
-	tenantManager := services.NewTenantManager(s.db, rabbitmqService)
-	messageServices := services.NewMessageService(s.db, rabbitmqService)
+	rabbitmqService, err := services.NewRabbitMQService(mqClient.Conn, mqChannel, time.Second)
+	if err != nil {
+		return err
+	}
+	s.broker = rabbitmqService
+	configStore := services.NewTenantConfigStore(s.db, conf.AppSecret)
+	tenantControl := services.NewTenantControl(s.db, rabbitmqService, configStore, control.NewPublisher(mqClient))
+	outboxRelay := services.NewOutboxRelay(s.db, rabbitmqService)
+	outboxRelay.Start(context.Background())
+	messageServices := services.NewMessageService(s.db, outboxRelay)
+	deadLetterServices := services.NewDeadLetterService(s.db, outboxRelay)
+	tenantBundler := services.NewTenantBundler(s.db, tenantControl, outboxRelay)
+	lockout := services.TenantUserConfig{MaxFailedLogins: 3, LockoutDuration: time.Minute}
+	tenantUsers := services.NewTenantUserService(s.db, lockout)
+	platformAdmin, err := services.NewPlatformAdmin(conf.Auth.AdminUsername, conf.Auth.AdminPasswordHash, lockout)
+	if err != nil {
+		return err
+	}
 
 	// Setup Fiber app
 	s.app = fiber.New(fiber.Config{
-		Immutable: true,
+		Immutable:         true,
+		StreamRequestBody: true,
 	})
+	s.app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, handlers.ImportPath))
 
 	server.Fiber = s.app
 	server.Router = &config.Router{
 		Routes: []fiber.Router{},
 	}
-	router.AttachAllRoutes(server, tenantManager, messageServices)
+	assignmentServices := services.NewAssignmentService(s.db, 30*time.Second)
+	tenantPurger := services.NewTenantPurger(s.db, services.PurgeConfig{GracePeriod: time.Hour, Interval: time.Minute})
+	router.AttachAllRoutes(server, mqClient, tenantControl, messageServices, deadLetterServices, configStore, assignmentServices, tenantPurger, tenantBundler, tenantUsers, platformAdmin)
 
-	return nil
+	// Tests act as the platform admin unless they log in as someone else
+	s.adminToken, err = s.login("/v1/auth/admin/login", map[string]interface{}{
+		"username": "root",
+		"password": "test-admin-password",
+	})
+
+	return err
+}
+
+// do runs req against the app, as the platform admin unless req carries a
+// token of its own.
+func (s *TestSuite) do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+s.adminToken)
+	}
+
+	return s.app.Test(req, 10*1000)
+}
+
+// login posts credentials to a login endpoint and returns the token.
+func (s *TestSuite) login(path string, credentials map[string]interface{}) (string, error) {
+	body, _ := json.Marshal(credentials)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.app.Test(req, 10*1000)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login to %s failed with status %d", path, resp.StatusCode)
+	}
+
+	var token struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+
+	return token.Token, err
+}
+
+// createTenant creates a tenant through the API, along with its messages
+// partition and queue.
+func (s *TestSuite) createTenant(t *testing.T, name string) models.Tenant {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"name": name})
+	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to create tenant %s: %v", name, err)
+	}
+
+	var tenant models.Tenant
+	json.NewDecoder(resp.Body).Decode(&tenant)
+	return tenant
 }
 
 func (s *TestSuite) Teardown() {
@@ -280,7 +458,7 @@ func (s *TestSuite) TestTenantLifecycle(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := s.app.Test(req, 10*1000)
+	resp, err := s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to create tenant: %v", err)
 	}
@@ -298,7 +476,7 @@ func (s *TestSuite) TestTenantLifecycle(t *testing.T) {
 
 	// Delete tenant
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/v1/tenants/%s", tenant.ID), nil)
-	resp, err = s.app.Test(req, 10*1000)
+	resp, err = s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to delete tenant: %v", err)
 	}
@@ -319,7 +497,7 @@ func (s *TestSuite) TestMessagePublishing(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := s.app.Test(req, 10*1000)
+	resp, err := s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to create tenant: %v", err)
 	}
@@ -341,7 +519,7 @@ func (s *TestSuite) TestMessagePublishing(t *testing.T) {
 	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/messages", tenant.ID), bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err = s.app.Test(req, 10*1000)
+	resp, err = s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to publish message: %v", err)
 	}
@@ -351,6 +529,431 @@ func (s *TestSuite) TestMessagePublishing(t *testing.T) {
 	}
 }
 
+func (s *TestSuite) TestUnroutablePublish(t *testing.T) {
+	// No queue is bound to this tenant's routing key. Publishing repeatedly
+	// gives a late return many chances to be mistaken for a delivery.
+	tenantID := "unroutable-tenant"
+	for i := range 50 {
+		err := s.broker.PublishMessageWithHeaders(context.Background(), tenantID, []byte(`{"test":"unroutable"}`), nil)
+		if !errors.Is(err, services.ErrUnroutable) {
+			t.Fatalf("Publish %d: expected %v, got %v", i, services.ErrUnroutable, err)
+		}
+	}
+}
+
+func (s *TestSuite) TestQueuePriority(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.NewString()
+	if err := s.broker.CreateTenantQueue(tenantID); err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	defer s.broker.DeleteTenantQueue(tenantID)
+
+	// The low priority messages are older, x-max-priority lets the urgent
+	// one overtake them
+	for i := range 3 {
+		body := []byte(fmt.Sprintf(`{"n":%d}`, i))
+		if err := s.broker.PublishMessageWithHeaders(ctx, tenantID, body, map[string]any{"priority": 0}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+	if err := s.broker.PublishMessageWithHeaders(ctx, tenantID, []byte(`{"n":"urgent"}`), map[string]any{"priority": services.MaxPriority}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	sub, err := s.broker.Consume(tenantID, 1)
+	if err != nil {
+		t.Fatalf("Failed to consume: %v", err)
+	}
+	defer sub.Close()
+
+	want := []string{`{"n":"urgent"}`, `{"n":0}`, `{"n":1}`, `{"n":2}`}
+	for i, body := range want {
+		select {
+		case delivery := <-sub.Deliveries():
+			if string(delivery.Body) != body {
+				t.Fatalf("Expected delivery %d to be %s, got %s", i, body, delivery.Body)
+			}
+			if err := delivery.Ack(); err != nil {
+				t.Fatalf("Failed to ack: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for delivery %d", i)
+		}
+	}
+}
+
+func (s *TestSuite) TestQueueMigration(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.NewString()
+	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
+
+	conn, err := amqp.Dial(s.mqURL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	// declare runs on a fresh channel, because a failed declare closes it
+	declare := func(name string, passive bool, args amqp.Table) error {
+		ch, err := conn.Channel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ch.Close()
+
+		if passive {
+			_, err = ch.QueueDeclarePassive(name, true, false, false, false, args)
+		} else {
+			_, err = ch.QueueDeclare(name, true, false, false, false, args)
+		}
+		return err
+	}
+
+	// A queue from before priorities and retries, holding a backlog
+	if err := declare(queueName, false, nil); err != nil {
+		t.Fatalf("Failed to declare legacy queue: %v", err)
+	}
+	if err := s.broker.CreateTenantQueue(tenantID); err != nil {
+		t.Fatalf("Expected the legacy queue to be kept, got %v", err)
+	}
+	defer s.broker.DeleteTenantQueue(tenantID)
+
+	for i := range 5 {
+		body := []byte(fmt.Sprintf(`{"n":%d}`, i))
+		if err := s.broker.PublishMessageWithHeaders(ctx, tenantID, body, map[string]any{"priority": i % 2}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	if err := s.broker.MigrateTenantQueue(tenantID); err != nil {
+		t.Fatalf("Failed to migrate queue: %v", err)
+	}
+
+	if count, err := s.broker.MessageCount(tenantID); err != nil || count != 5 {
+		t.Fatalf("Expected the backlog of 5 to be kept, got %d, %v", count, err)
+	}
+	if err := declare(queueName, false, amqp.Table{
+		"x-max-priority":         services.MaxPriority,
+		"x-dead-letter-exchange": fmt.Sprintf("tenant_%s_retry", tenantID),
+	}); err != nil {
+		t.Fatalf("Expected the queue to have the current arguments, got %v", err)
+	}
+	if err := declare(queueName+"_migration", true, nil); err == nil {
+		t.Fatal("Expected the staging queue to be deleted")
+	}
+
+	// Migrating again is a no-op
+	if err := s.broker.MigrateTenantQueue(tenantID); err != nil {
+		t.Fatalf("Failed to migrate queue again: %v", err)
+	}
+
+	sub, err := s.broker.Consume(tenantID, 5)
+	if err != nil {
+		t.Fatalf("Failed to consume: %v", err)
+	}
+	defer sub.Close()
+
+	received := make(map[string]bool)
+	for range 5 {
+		select {
+		case delivery := <-sub.Deliveries():
+			received[string(delivery.Body)] = true
+			if err := delivery.Ack(); err != nil {
+				t.Fatalf("Failed to ack: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out after %d deliveries", len(received))
+		}
+	}
+	for i := range 5 {
+		if body := fmt.Sprintf(`{"n":%d}`, i); !received[body] {
+			t.Fatalf("Expected %s to survive the migration", body)
+		}
+	}
+}
+
+func (s *TestSuite) TestRetryOnOutdatedQueue(t *testing.T) {
+	ctx := context.Background()
+	tenantID := uuid.NewString()
+
+	conn, err := amqp.Dial(s.mqURL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+
+	// A queue from before the retry topology has no dead-letter exchange,
+	// and stays that way when it is not migrated
+	if _, err := ch.QueueDeclare(fmt.Sprintf("tenant_%s_queue", tenantID), true, false, false, false, nil); err != nil {
+		t.Fatalf("Failed to declare legacy queue: %v", err)
+	}
+	if err := s.broker.CreateTenantQueue(tenantID); err != nil {
+		t.Fatalf("Expected the legacy queue to be kept, got %v", err)
+	}
+	defer s.broker.DeleteTenantQueue(tenantID)
+
+	if err := s.broker.PublishMessageWithHeaders(ctx, tenantID, []byte(`{"n":1}`), map[string]any{"priority": 1}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	sub, err := s.broker.Consume(tenantID, 1)
+	if err != nil {
+		t.Fatalf("Failed to consume: %v", err)
+	}
+	defer sub.Close()
+
+	for want := 0; want <= 2; want++ {
+		select {
+		case delivery := <-sub.Deliveries():
+			if string(delivery.Body) != `{"n":1}` || delivery.RetryCount != want {
+				t.Fatalf("Expected the message with retry count %d, got %s with %d", want, delivery.Body, delivery.RetryCount)
+			}
+			settle, what := delivery.Retry, "retry"
+			if want == 2 {
+				settle, what = delivery.Ack, "ack"
+			}
+			if err := settle(); err != nil {
+				t.Fatalf("Failed to %s: %v", what, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected retry %d to be delivered again instead of being dropped", want)
+		}
+	}
+
+	if count, err := s.broker.MessageCount(tenantID); err != nil || count != 0 {
+		t.Fatalf("Expected the queue to be empty, got %d, %v", count, err)
+	}
+}
+
+func (s *TestSuite) TestDeadLetterReplay(t *testing.T) {
+	ctx := context.Background()
+	outbox := services.NewOutboxRelay(s.db, s.broker)
+	messages := services.NewMessageService(s.db, outbox)
+	deadLetters := services.NewDeadLetterService(s.db, outbox)
+
+	// deadLetter publishes a message and fails it into the dead letters,
+	// returning the message id and the dead letter id
+	deadLetter := func(t *testing.T, tenantID string) (string, string) {
+		t.Helper()
+
+		messageID := uuid.NewString()
+		if _, err := messages.Publish(ctx, tenantID, messageID, &models.MessageRequest{
+			Type: "email",
+			Data: map[string]interface{}{"to": "test@example.com"},
+		}); err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+
+		var deadLetterID string
+		err := s.db.QueryRow(`
+			INSERT INTO dead_letter_messages (original_message_id, tenant_id, payload, failure_reason, retry_count, last_error)
+			SELECT id, tenant_id, payload, 'max retries exceeded', 3, 'smtp unavailable' FROM messages WHERE id = $1
+			RETURNING id
+		`, messageID).Scan(&deadLetterID)
+		if err != nil {
+			t.Fatalf("Failed to dead letter message: %v", err)
+		}
+		if _, err := s.db.Exec(`UPDATE messages SET status = 'failed', retry_count = 3 WHERE id = $1`, messageID); err != nil {
+			t.Fatal(err)
+		}
+		return messageID, deadLetterID
+	}
+	count := func(t *testing.T, query string, args ...any) int {
+		t.Helper()
+
+		var n int
+		if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	replays := func(t *testing.T, messageID string) int {
+		t.Helper()
+		return count(t, `SELECT COUNT(*) FROM message_processing_logs WHERE message_id = $1 AND status = 'replayed'`, messageID)
+	}
+	status := func(t *testing.T, messageID string) string {
+		t.Helper()
+
+		var status string
+		if err := s.db.QueryRow(`SELECT status FROM messages WHERE id = $1`, messageID).Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	t.Run("replays once", func(t *testing.T) {
+		tenant := s.createTenant(t, "replay-twice-tenant")
+		messageID, deadLetterID := deadLetter(t, tenant.ID)
+
+		replayed, missing, err := deadLetters.Replay(ctx, tenant.ID, []string{deadLetterID})
+		if err != nil || len(replayed) != 1 || replayed[0] != messageID || len(missing) != 0 {
+			t.Fatalf("Expected message %s to be replayed, got %v, missing %v, %v", messageID, replayed, missing, err)
+		}
+
+		// The dead letter is gone, so replaying it again does nothing and
+		// says so
+		replayed, missing, err = deadLetters.Replay(ctx, tenant.ID, []string{deadLetterID})
+		if err != nil || len(replayed) != 0 || len(missing) != 1 || missing[0] != deadLetterID {
+			t.Fatalf("Expected nothing to replay again and %s to be missing, got %v, missing %v, %v", deadLetterID, replayed, missing, err)
+		}
+		replayed, _, err = deadLetters.Replay(ctx, tenant.ID, nil)
+		if err != nil || len(replayed) != 0 {
+			t.Fatalf("Expected nothing to replay again, got %v, %v", replayed, err)
+		}
+
+		if got := status(t, messageID); got != "pending" {
+			t.Fatalf("Expected the replayed message to be pending, got %s", got)
+		}
+		if n := replays(t, messageID); n != 1 {
+			t.Fatalf("Expected a single replay to be logged, got %d", n)
+		}
+		// The original publish and the replay
+		if n := count(t, `SELECT COUNT(*) FROM outbox_messages WHERE message_id = $1`, messageID); n != 2 {
+			t.Fatalf("Expected 2 outbox entries, got %d", n)
+		}
+	})
+
+	t.Run("keeps dead letters of suspended tenants", func(t *testing.T) {
+		tenant := s.createTenant(t, "replay-suspended-tenant")
+		messageID, deadLetterID := deadLetter(t, tenant.ID)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/suspend", tenant.ID), nil)
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to suspend tenant: %v", err)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"publish": "reject"})
+		req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/suspension", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to update suspension config: %v", err)
+		}
+
+		replayed, _, err := deadLetters.Replay(ctx, tenant.ID, []string{deadLetterID})
+		if !errors.Is(err, services.ErrTenantSuspended) || len(replayed) != 0 {
+			t.Fatalf("Expected %v, got %v, %v", services.ErrTenantSuspended, replayed, err)
+		}
+		if n := count(t, `SELECT COUNT(*) FROM dead_letter_messages WHERE id = $1`, deadLetterID); n != 1 {
+			t.Fatal("Expected the dead letter to be kept")
+		}
+		if got := status(t, messageID); got != "failed" {
+			t.Fatalf("Expected the message to stay failed, got %s", got)
+		}
+		if n := replays(t, messageID); n != 0 {
+			t.Fatalf("Expected no replay to be logged, got %d", n)
+		}
+
+		// Once resumed the kept dead letter replays
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/resume", tenant.ID), nil)
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to resume tenant: %v", err)
+		}
+		replayed, _, err = deadLetters.Replay(ctx, tenant.ID, []string{deadLetterID})
+		if err != nil || len(replayed) != 1 {
+			t.Fatalf("Expected the dead letter to replay after resume, got %v, %v", replayed, err)
+		}
+	})
+
+	t.Run("replays or purges while they race", func(t *testing.T) {
+		tenant := s.createTenant(t, "replay-purge-tenant")
+
+		ids := make([]string, 50)
+		for i := range ids {
+			ids[i], _ = deadLetter(t, tenant.ID)
+		}
+
+		var (
+			wg       sync.WaitGroup
+			replayed []string
+			purged   int64
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var err error
+			if replayed, _, err = deadLetters.Replay(ctx, tenant.ID, nil); err != nil {
+				t.Errorf("Failed to replay: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var err error
+			if purged, err = deadLetters.Purge(ctx, tenant.ID, nil); err != nil {
+				t.Errorf("Failed to purge: %v", err)
+			}
+		}()
+		wg.Wait()
+
+		if n := count(t, `SELECT COUNT(*) FROM dead_letter_messages WHERE tenant_id = $1`, tenant.ID); n != 0 {
+			t.Fatalf("Expected no dead letters left, got %d", n)
+		}
+		if len(replayed)+int(purged) != len(ids) {
+			t.Fatalf("Expected each dead letter to be replayed or purged, got %d replayed and %d purged", len(replayed), purged)
+		}
+		for _, messageID := range ids {
+			switch got := status(t, messageID); got {
+			case "pending":
+				if !slices.Contains(replayed, messageID) || replays(t, messageID) != 1 {
+					t.Fatalf("Pending message %s was not replayed once", messageID)
+				}
+			case "failed":
+				if slices.Contains(replayed, messageID) || replays(t, messageID) != 0 {
+					t.Fatalf("Purged message %s was replayed", messageID)
+				}
+			default:
+				t.Fatalf("Expected message %s to be replayed or purged, got %s", messageID, got)
+			}
+		}
+	})
+
+	t.Run("reports selected dead letters a purge got first", func(t *testing.T) {
+		tenant := s.createTenant(t, "replay-selected-purge-tenant")
+
+		ids := make([]string, 50)
+		for i := range ids {
+			_, ids[i] = deadLetter(t, tenant.ID)
+		}
+
+		var (
+			wg       sync.WaitGroup
+			replayed []string
+			missing  []string
+			purged   int64
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var err error
+			if replayed, missing, err = deadLetters.Replay(ctx, tenant.ID, ids); err != nil {
+				t.Errorf("Failed to replay: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var err error
+			if purged, err = deadLetters.Purge(ctx, tenant.ID, nil); err != nil {
+				t.Errorf("Failed to purge: %v", err)
+			}
+		}()
+		wg.Wait()
+
+		// Every selected id is either replayed or reported
+		if len(replayed)+len(missing) != len(ids) || int64(len(missing)) != purged {
+			t.Fatalf("Expected each selected dead letter to be replayed or missing, got %d replayed, %d missing and %d purged",
+				len(replayed), len(missing), purged)
+		}
+		for _, id := range missing {
+			if !slices.Contains(ids, id) {
+				t.Fatalf("Expected only selected ids to be missing, got %s", id)
+			}
+		}
+	})
+}
+
 func (s *TestSuite) TestConcurrencyUpdate(t *testing.T) {
 	// Create tenant first
 	tenantData := map[string]interface{}{
@@ -362,7 +965,7 @@ func (s *TestSuite) TestConcurrencyUpdate(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := s.app.Test(req, 10*1000)
+	resp, err := s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to create tenant: %v", err)
 	}
@@ -372,14 +975,14 @@ func (s *TestSuite) TestConcurrencyUpdate(t *testing.T) {
 
 	// Update concurrency
 	configData := map[string]interface{}{
-		"workers": 7,
+		"workers": 2,
 	}
 	body, _ = json.Marshal(configData)
 
 	req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/concurrency", tenant.ID), bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err = s.app.Test(req, 10*1000)
+	resp, err = s.do(req)
 	if err != nil {
 		t.Fatalf("Failed to update concurrency: %v", err)
 	}
@@ -387,26 +990,1035 @@ func (s *TestSuite) TestConcurrencyUpdate(t *testing.T) {
 	if resp.StatusCode != http.StatusOK {
 		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
 	}
+
+	var update services.ConcurrencyUpdate
+	json.NewDecoder(resp.Body).Decode(&update)
+	if update.Workers != 2 || update.PreviousWorkers != 3 || update.Version != 1 {
+		t.Fatalf("Unexpected concurrency update %+v", update)
+	}
+
+	// Above max_workers and non-positive counts are rejected
+	for _, workers := range []int{7, 0, -1} {
+		body, _ = json.Marshal(map[string]interface{}{"workers": workers})
+		req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/concurrency", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err = s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to update concurrency: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status 400 for %d workers, got %d", workers, resp.StatusCode)
+		}
+	}
 }
 
-func (s *TestSuite) TestCursorPagination(t *testing.T) {
-	// Test pagination endpoint
-	req, _ := http.NewRequest("GET", "/v1/messages?limit=10", nil)
-	resp, err := s.app.Test(req, 10*1000)
+func (s *TestSuite) TestSuspendResume(t *testing.T) {
+	// Create tenant first
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":        "suspend-test-tenant",
+		"max_workers": 3,
+	})
+
+	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
 	if err != nil {
-		t.Fatalf("Failed to get messages: %v", err)
+		t.Fatalf("Failed to create tenant: %v", err)
+	}
+
+	var tenant models.Tenant
+	json.NewDecoder(resp.Body).Decode(&tenant)
+
+	publish := func() int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"type": "email",
+			"data": map[string]interface{}{"to": "test@example.com"},
+		})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/messages", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	// Resuming a tenant that is not suspended is a conflict
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/resume", tenant.ID), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to resume tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/suspend", tenant.ID), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to suspend tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Buffered by default
+	if status := publish(); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202 while buffering, got %d", status)
 	}
 
+	body, _ = json.Marshal(map[string]interface{}{"publish": "reject"})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/suspension", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to update suspension config: %v", err)
+	}
 	if resp.StatusCode != http.StatusOK {
 		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
 	}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("Failed to decode pagination response: %v", err)
+	if status := publish(); status != http.StatusConflict {
+		t.Fatalf("Expected status 409 while rejecting, got %d", status)
 	}
 
-	if _, exists := result["data"]; !exists {
-		t.Fatalf("Expected 'data' field in response")
+	// Replaying dead letters is publishing too
+	body, _ = json.Marshal(map[string]interface{}{"all": true})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/dead-letters/replay", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to replay dead letters: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status 409 replaying while rejecting, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/resume", tenant.ID), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to resume tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
 	}
+
+	if status := publish(); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202 after resume, got %d", status)
+	}
+}
+
+func (s *TestSuite) TestTenantReadUpdate(t *testing.T) {
+	var created []models.Tenant
+	for _, name := range []string{"list-test-tenant-1", "list-test-tenant-2", "list-test-tenant-3"} {
+		body, _ := json.Marshal(map[string]interface{}{"name": name, "max_workers": 4})
+		req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to create tenant: %v", err)
+		}
+
+		var tenant models.Tenant
+		json.NewDecoder(resp.Body).Decode(&tenant)
+		created = append(created, tenant)
+	}
+
+	// Walk every page of provisioning tenants one at a time
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("Pagination did not end")
+		}
+
+		req, _ := http.NewRequest("GET", "/v1/tenants?status=provisioning&limit=1&cursor="+cursor, nil)
+		resp, err := s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to list tenants: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var page struct {
+			Data       []models.Tenant `json:"data"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		json.NewDecoder(resp.Body).Decode(&page)
+		for _, tenant := range page.Data {
+			if seen[tenant.ID] {
+				t.Fatalf("Tenant %s listed twice", tenant.ID)
+			}
+			seen[tenant.ID] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	for _, tenant := range created {
+		if !seen[tenant.ID] {
+			t.Fatalf("Tenant %s was not listed", tenant.ID)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/tenants?status=unknown", nil)
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to list tenants: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+	}
+
+	// A cursor that names no tenant is an error, not an empty page
+	req, _ = http.NewRequest("GET", "/v1/tenants?cursor="+uuid.NewString(), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to list tenants: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown cursor, got %d", resp.StatusCode)
+	}
+
+	tenant := created[0]
+	body, _ := json.Marshal(map[string]interface{}{"name": "renamed-tenant", "max_workers": 2})
+	req, _ = http.NewRequest("PATCH", fmt.Sprintf("/v1/tenants/%s", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to update tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// The node serving the tenant reports a pool the autoscaler grew
+	_, err = s.db.Exec(`
+		INSERT INTO tenant_assignments (tenant_id, slot, node_id, lease_expires_at, workers, busy)
+		VALUES ($1, 0, 'read-test-node', NOW() + INTERVAL '1 minute', 3, 1)
+	`, tenant.ID)
+	if err != nil {
+		t.Fatalf("Failed to assign tenant: %v", err)
+	}
+	defer s.db.Exec(`DELETE FROM tenant_assignments WHERE tenant_id = $1`, tenant.ID)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/v1/tenants/%s", tenant.ID), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to get tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var details struct {
+		models.Tenant
+		Runtime services.TenantRuntime `json:"runtime"`
+	}
+	json.NewDecoder(resp.Body).Decode(&details)
+	if details.Name != "renamed-tenant" || details.MaxWorkers.Int != 2 || details.CurrentWorkers.Int != 2 {
+		t.Fatalf("Unexpected tenant %+v", details.Tenant)
+	}
+	if details.Runtime.Workers != 3 || details.Runtime.Busy != 1 || len(details.Runtime.Nodes) != 1 ||
+		details.Runtime.ConsumerRunning {
+		t.Fatalf("Unexpected runtime state %+v", details.Runtime)
+	}
+
+	// Limits must stay positive
+	body, _ = json.Marshal(map[string]interface{}{"max_workers": 0})
+	req, _ = http.NewRequest("PATCH", fmt.Sprintf("/v1/tenants/%s", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to update tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func (s *TestSuite) TestTenantPurge(t *testing.T) {
+	body, _ := json.Marshal(map[string]interface{}{"name": "purge-test-tenant", "max_workers": 1})
+	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to create tenant: %v", err)
+	}
+
+	var tenant models.Tenant
+	json.NewDecoder(resp.Body).Decode(&tenant)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"type": "email",
+		"data": map[string]interface{}{"to": "test@example.com"},
+	})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/messages", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := s.do(req); err != nil {
+		t.Fatalf("Failed to publish message: %v", err)
+	}
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/v1/tenants/%s", tenant.ID), nil)
+	if _, err := s.do(req); err != nil {
+		t.Fatalf("Failed to delete tenant: %v", err)
+	}
+
+	getPurge := func() services.TenantPurge {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/tenants/%s/purge", tenant.ID), nil)
+		resp, err := s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to get purge: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+
+		var purge services.TenantPurge
+		json.NewDecoder(resp.Body).Decode(&purge)
+		return purge
+	}
+
+	if purge := getPurge(); purge.Step != services.PurgeStepScheduled {
+		t.Fatalf("Expected a scheduled purge, got %+v", purge)
+	}
+
+	// No worker runs in this suite; stop the tenant as one would
+	if _, err := s.db.Exec(`UPDATE tenants SET status = $2 WHERE id = $1`, tenant.ID, services.TenantStatusStopped); err != nil {
+		t.Fatalf("Failed to stop tenant: %v", err)
+	}
+
+	purger := services.NewTenantPurger(s.db, services.PurgeConfig{ArchiveDir: t.TempDir()})
+	for range 2 {
+		// Running again after the purge finished changes nothing
+		if err := purger.RunOnce(context.Background()); err != nil {
+			t.Fatalf("Failed to purge tenants: %v", err)
+		}
+	}
+
+	purge := getPurge()
+	if purge.Step != services.PurgeStepDone || purge.StepsDone != purge.Steps || purge.CompletedAt == nil {
+		t.Fatalf("Expected a finished purge, got %+v", purge)
+	}
+	if _, err := os.Stat(purge.ArchivePath); err != nil {
+		t.Fatalf("Expected an archive of the tenant's messages: %v", err)
+	}
+
+	var partitionExists, tenantExists bool
+	s.db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`,
+		"messages_tenant_"+strings.ReplaceAll(tenant.ID, "-", "")).Scan(&partitionExists)
+	s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1)`, tenant.ID).Scan(&tenantExists)
+	if partitionExists || tenantExists {
+		t.Fatalf("Expected the partition and tenant to be gone, partition: %v, tenant: %v", partitionExists, tenantExists)
+	}
+}
+
+func (s *TestSuite) TestTenantExportImport(t *testing.T) {
+	body, _ := json.Marshal(map[string]interface{}{"name": "export-test-tenant", "max_workers": 2})
+	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to create tenant: %v", err)
+	}
+
+	var tenant models.Tenant
+	json.NewDecoder(resp.Body).Decode(&tenant)
+
+	for range 3 {
+		body, _ = json.Marshal(map[string]interface{}{
+			"type": "email",
+			"data": map[string]interface{}{"to": "test@example.com"},
+		})
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/messages", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if _, err := s.do(req); err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"url": "https://example.com/hook", "secret": "export-test-webhook-secret"})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/webhook", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := s.do(req); err != nil {
+		t.Fatalf("Failed to configure webhook: %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/v1/tenants/%s/export", tenant.ID), nil)
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to export tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	exported, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+
+	// Importing under the same ID conflicts with the original
+	req, _ = http.NewRequest("POST", "/v1/tenants/import?keep_id=true", bytes.NewReader(exported))
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to import tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("POST", "/v1/tenants/import?name=imported-tenant", bytes.NewReader(exported))
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to import tenant: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	var imported models.Tenant
+	json.NewDecoder(resp.Body).Decode(&imported)
+	if imported.ID == tenant.ID || imported.Name != "imported-tenant" || imported.MaxWorkers.Int != 2 {
+		t.Fatalf("Unexpected imported tenant %+v", imported)
+	}
+
+	var messages int
+	s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE tenant_id = $1`, imported.ID).Scan(&messages)
+	if messages != 3 {
+		t.Fatalf("Expected 3 imported messages, got %d", messages)
+	}
+
+	// Secrets are left out of bundles unless asked for
+	var secret string
+	s.db.QueryRow(`SELECT config_value->>'secret' FROM tenant_configs
+        WHERE tenant_id = $1 AND config_key = 'webhook' AND is_active`, imported.ID).Scan(&secret)
+	if secret != "" {
+		t.Fatalf("Expected the webhook secret to be redacted, got %q", secret)
+	}
+}
+
+func (s *TestSuite) TestTenantUsers(t *testing.T) {
+	body, _ := json.Marshal(map[string]interface{}{"name": "users-test-tenant"})
+	req, _ := http.NewRequest("POST", "/v1/tenants", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to create tenant: %v", err)
+	}
+
+	var tenant models.Tenant
+	json.NewDecoder(resp.Body).Decode(&tenant)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"username": "alice",
+		"password": "correct-horse",
+		"roles":    []string{"admin"},
+	})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/users", tenant.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	var user services.TenantUser
+	json.NewDecoder(resp.Body).Decode(&user)
+
+	login := func(password string) *http.Response {
+		body, _ := json.Marshal(map[string]interface{}{
+			"tenant_id": tenant.ID,
+			"username":  "alice",
+			"password":  password,
+		})
+		req, _ := http.NewRequest("POST", "/v1/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.app.Test(req, 10*1000)
+		if err != nil {
+			t.Fatalf("Failed to log in: %v", err)
+		}
+		return resp
+	}
+
+	resp = login("correct-horse")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&token)
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token.Token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	}); err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if claims["user_id"] != user.ID || claims["tenant_id"] != tenant.ID || fmt.Sprint(claims["roles"]) != "[admin]" {
+		t.Fatalf("Unexpected claims %v", claims)
+	}
+
+	listUsers := func(token string) int {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/tenants/%s/users", tenant.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := s.do(req)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		return resp.StatusCode
+	}
+	if status := listUsers(token.Token); status != http.StatusOK {
+		t.Fatalf("Expected status 200 with the user's token, got %d", status)
+	}
+
+	// The suite locks accounts after 3 wrong passwords
+	for range 3 {
+		if resp := login("wrong-password"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401, got %d", resp.StatusCode)
+		}
+	}
+	if resp := login("correct-horse"); resp.StatusCode != http.StatusLocked {
+		t.Fatalf("Expected status 423, got %d", resp.StatusCode)
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"password": "battery-staple"})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/users/%s/reset-password", tenant.ID, user.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+	if status := listUsers(token.Token); status != http.StatusUnauthorized {
+		t.Fatalf("Expected a token issued before the reset to be revoked, got %d", status)
+	}
+	resp = login("battery-staple")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 after reset, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&token)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/users/%s/disable", tenant.ID, user.ID), nil)
+	if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to disable user: %v", err)
+	}
+	if resp := login("battery-staple"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for a disabled user, got %d", resp.StatusCode)
+	}
+	if status := listUsers(token.Token); status != http.StatusUnauthorized {
+		t.Fatalf("Expected the disabled user's token to be revoked, got %d", status)
+	}
+}
+
+func (s *TestSuite) TestAuthorization(t *testing.T) {
+	tenant := s.createTenant(t, "authz-tenant")
+	other := s.createTenant(t, "authz-other-tenant")
+
+	tokens := map[string]string{}
+	for _, role := range []string{services.RoleAdmin, services.RoleMember} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"username": role,
+			"password": "correct-horse",
+			"roles":    []string{role},
+		})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/users", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Failed to create %s user: %v", role, err)
+		}
+
+		token, err := s.login("/v1/auth/login", map[string]interface{}{
+			"tenant_id": tenant.ID,
+			"username":  role,
+			"password":  "correct-horse",
+		})
+		if err != nil {
+			t.Fatalf("Failed to log in as %s: %v", role, err)
+		}
+		tokens[role] = "Bearer " + token
+	}
+
+	messageBody, _ := json.Marshal(map[string]interface{}{"payload": map[string]interface{}{"test": "authz"}})
+	userBody, _ := json.Marshal(map[string]interface{}{"username": "carol", "password": "correct-horse"})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   []byte
+		want   int
+	}{
+		{"anonymous lists tenants", "", "GET", "/v1/tenants", nil, http.StatusBadRequest},
+		{"forged token", "Bearer not-a-token", "GET", "/v1/tenants", nil, http.StatusUnauthorized},
+		{"member publishes", tokens[services.RoleMember], "POST", fmt.Sprintf("/v1/tenants/%s/messages", tenant.ID), messageBody, http.StatusAccepted},
+		{"member publishes to another tenant", tokens[services.RoleMember], "POST", fmt.Sprintf("/v1/tenants/%s/messages", other.ID), messageBody, http.StatusForbidden},
+		{"member reads its tenant", tokens[services.RoleMember], "GET", fmt.Sprintf("/v1/tenants/%s", tenant.ID), nil, http.StatusOK},
+		{"member reads another tenant", tokens[services.RoleMember], "GET", fmt.Sprintf("/v1/tenants/%s", other.ID), nil, http.StatusForbidden},
+		{"member creates a user", tokens[services.RoleMember], "POST", fmt.Sprintf("/v1/tenants/%s/users", tenant.ID), userBody, http.StatusForbidden},
+		{"admin creates a user", tokens[services.RoleAdmin], "POST", fmt.Sprintf("/v1/tenants/%s/users", tenant.ID), userBody, http.StatusCreated},
+		{"admin lists tenants", tokens[services.RoleAdmin], "GET", "/v1/tenants", nil, http.StatusForbidden},
+		{"admin creates a tenant", tokens[services.RoleAdmin], "POST", "/v1/tenants", []byte(`{"name":"authz-denied"}`), http.StatusForbidden},
+		{"admin deletes its tenant", tokens[services.RoleAdmin], "DELETE", fmt.Sprintf("/v1/tenants/%s", tenant.ID), nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		var err error
+		if tt.token == "" {
+			resp, err = s.app.Test(req, 10*1000)
+		} else {
+			req.Header.Set("Authorization", tt.token)
+			resp, err = s.do(req)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, resp.StatusCode)
+		}
+	}
+
+	// Tenant users only see their own tenant's messages
+	req, _ := http.NewRequest("GET", "/v1/messages?limit=100", nil)
+	req.Header.Set("Authorization", tokens[services.RoleMember])
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to list messages: %v", err)
+	}
+
+	var result struct {
+		Data []models.Message `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if len(result.Data) == 0 {
+		t.Fatal("Expected the published message to be listed")
+	}
+	for _, message := range result.Data {
+		if message.TenantID != tenant.ID {
+			t.Fatalf("Listed message %s of tenant %s", message.ID, message.TenantID)
+		}
+	}
+}
+
+func (s *TestSuite) TestCursorPagination(t *testing.T) {
+	// Test pagination endpoint
+	req, _ := http.NewRequest("GET", "/v1/messages?limit=10", nil)
+	resp, err := s.do(req)
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode pagination response: %v", err)
+	}
+
+	if _, exists := result["data"]; !exists {
+		t.Fatalf("Expected 'data' field in response")
+	}
+}
+
+func (s *TestSuite) TestSharding(t *testing.T) {
+	ctx := context.Background()
+	for i := range 3 {
+		if _, err := s.db.Exec(`INSERT INTO tenants (name, status) VALUES ($1, 'active')`, fmt.Sprintf("shard-test-tenant-%d", i)); err != nil {
+			t.Fatalf("Failed to create tenant: %v", err)
+		}
+	}
+	var tenants int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM tenants WHERE deleted_at IS NULL`).Scan(&tenants); err != nil {
+		t.Fatalf("Failed to count tenants: %v", err)
+	}
+
+	const replicas = 2
+	tests := []struct {
+		name string
+		// nodes join and rebalance before change
+		nodes  []string
+		change func(t *testing.T, nodes map[string]*services.ShardCoordinator)
+		// live are the nodes the tenants are spread across after change
+		live []string
+	}{
+		{
+			name:  "single node",
+			nodes: []string{"node-a"},
+			live:  []string{"node-a"},
+		},
+		{
+			name:  "join",
+			nodes: []string{"node-a"},
+			change: func(t *testing.T, nodes map[string]*services.ShardCoordinator) {
+				nodes["node-b"] = services.NewShardCoordinator(s.db, "node-b", "node-b", time.Minute, replicas)
+				if err := nodes["node-b"].Join(ctx); err != nil {
+					t.Fatalf("Failed to join: %v", err)
+				}
+			},
+			live: []string{"node-a", "node-b"},
+		},
+		{
+			name:  "leave",
+			nodes: []string{"node-a", "node-b", "node-c"},
+			change: func(t *testing.T, nodes map[string]*services.ShardCoordinator) {
+				if err := nodes["node-c"].Leave(ctx); err != nil {
+					t.Fatalf("Failed to leave: %v", err)
+				}
+				delete(nodes, "node-c")
+			},
+			live: []string{"node-a", "node-b"},
+		},
+		{
+			name:  "expiry",
+			nodes: []string{"node-a", "node-b", "node-c"},
+			change: func(t *testing.T, nodes map[string]*services.ShardCoordinator) {
+				// node-c stops heartbeating
+				if _, err := s.db.Exec(`UPDATE worker_nodes SET heartbeat_at = NOW() - INTERVAL '5 minutes' WHERE id = 'node-c'`); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := s.db.Exec(`UPDATE tenant_assignments SET lease_expires_at = NOW() - INTERVAL '1 second' WHERE node_id = 'node-c'`); err != nil {
+					t.Fatal(err)
+				}
+			},
+			live: []string{"node-a", "node-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.db.Exec(`DELETE FROM tenant_assignments; DELETE FROM worker_nodes`); err != nil {
+				t.Fatalf("Failed to reset assignments: %v", err)
+			}
+
+			nodes := make(map[string]*services.ShardCoordinator)
+			for _, id := range tt.nodes {
+				nodes[id] = services.NewShardCoordinator(s.db, id, id, time.Minute, replicas)
+				if err := nodes[id].Join(ctx); err != nil {
+					t.Fatalf("Failed to join %s: %v", id, err)
+				}
+			}
+			rebalance := func(ids []string) {
+				// A slot changes hands over two rebalances: its holder
+				// releases it and the next owner claims it
+				for range 5 {
+					for _, id := range ids {
+						if _, err := nodes[id].Rebalance(ctx); err != nil {
+							t.Fatalf("Failed to rebalance %s: %v", id, err)
+						}
+					}
+				}
+			}
+			rebalance(tt.nodes)
+			if tt.change != nil {
+				tt.change(t, nodes)
+			}
+			rebalance(tt.live)
+
+			slots := min(replicas, len(tt.live))
+			target := (tenants*slots + len(tt.live) - 1) / len(tt.live)
+
+			rows, err := s.db.Query(`SELECT tenant_id, node_id FROM tenant_assignments WHERE lease_expires_at > NOW()`)
+			if err != nil {
+				t.Fatalf("Failed to list assignments: %v", err)
+			}
+			defer rows.Close()
+
+			owners := make(map[string][]string)
+			held := make(map[string]int)
+			for rows.Next() {
+				var tenantID, nodeID string
+				if err := rows.Scan(&tenantID, &nodeID); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Contains(tt.live, nodeID) {
+					t.Fatalf("Tenant %s is held by %s, which is not live", tenantID, nodeID)
+				}
+				if slices.Contains(owners[tenantID], nodeID) {
+					t.Fatalf("Node %s holds two slots of tenant %s", nodeID, tenantID)
+				}
+				if !nodes[nodeID].Owns(tenantID) {
+					t.Fatalf("Node %s holds tenant %s but does not own it locally", nodeID, tenantID)
+				}
+				owners[tenantID] = append(owners[tenantID], nodeID)
+				held[nodeID]++
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(owners) != tenants {
+				t.Fatalf("Expected all %d tenants to be assigned, got %d", tenants, len(owners))
+			}
+			for tenantID, nodeIDs := range owners {
+				if len(nodeIDs) != slots {
+					t.Fatalf("Expected tenant %s on %d nodes, got %v", tenantID, slots, nodeIDs)
+				}
+			}
+			for _, id := range tt.live {
+				if held[id] > target {
+					t.Fatalf("Node %s holds %d slots, above its share of %d", id, held[id], target)
+				}
+				if nodes[id].Replicas() != slots {
+					t.Fatalf("Node %s splits tenants across %d nodes, want %d", id, nodes[id].Replicas(), slots)
+				}
+			}
+
+			// A node whose slots were taken over stops owning them at its
+			// next heartbeat
+			for id, node := range nodes {
+				if slices.Contains(tt.live, id) {
+					continue
+				}
+				if err := node.Heartbeat(ctx); err != nil {
+					t.Fatalf("Failed to heartbeat %s: %v", id, err)
+				}
+				for tenantID := range owners {
+					if node.Owns(tenantID) {
+						t.Fatalf("Expired node %s still owns tenant %s", id, tenantID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func (s *TestSuite) TestPostgresLeaseRenewal(t *testing.T) {
+	tenant := s.createTenant(t, "lease-test-tenant")
+	ctx := context.Background()
+
+	const visibilityTimeout = 200 * time.Millisecond
+	broker := services.NewPostgresBroker(s.db, visibilityTimeout, 10*time.Millisecond, time.Second)
+
+	for range 2 {
+		headers := map[string]any{"message_id": uuid.NewString()}
+		if err := broker.PublishMessageWithHeaders(ctx, tenant.ID, []byte(`{"type":"email","data":{}}`), headers); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	slow, err := broker.Consume(tenant.ID, 2)
+	if err != nil {
+		t.Fatalf("Failed to consume: %v", err)
+	}
+	defer slow.Close()
+
+	// Processing outlasts the visibility timeout while the second claimed
+	// message waits for a worker
+	first := <-slow.Deliveries()
+
+	other, err := broker.Consume(tenant.ID, 2)
+	if err != nil {
+		t.Fatalf("Failed to consume: %v", err)
+	}
+	stolen := 0
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for range other.Deliveries() {
+			stolen++
+		}
+	}()
+
+	time.Sleep(5 * visibilityTimeout)
+	if err := first.Ack(); err != nil {
+		t.Fatalf("Expected the renewed lease to be held, got %v", err)
+	}
+	second := <-slow.Deliveries()
+	if err := second.Ack(); err != nil {
+		t.Fatalf("Expected the waiting delivery's lease to be held, got %v", err)
+	}
+
+	other.Close()
+	<-drained
+	if stolen != 0 {
+		t.Fatalf("Expected each message to be delivered once, another consumer got %d", stolen)
+	}
+	if first.Redelivered || second.Redelivered {
+		t.Fatal("Expected no redelivery")
+	}
+}
+
+func (s *TestSuite) TestScheduledMessages(t *testing.T) {
+	ctx := context.Background()
+	outbox := services.NewOutboxRelay(s.db, s.broker)
+	messages := services.NewMessageService(s.db, outbox)
+	scheduler := services.NewScheduler(s.db, outbox)
+
+	// schedule stores a message scheduled an hour ahead, or already due
+	schedule := func(t *testing.T, tenantID string, due bool) string {
+		t.Helper()
+
+		messageID := uuid.NewString()
+		at := time.Now().Add(time.Hour)
+		status, err := messages.Publish(ctx, tenantID, messageID, &models.MessageRequest{
+			Type:        "email",
+			Data:        map[string]interface{}{"to": "test@example.com"},
+			ScheduledAt: &at,
+		})
+		if err != nil || status != "scheduled" {
+			t.Fatalf("Failed to schedule message: %s, %v", status, err)
+		}
+		if due {
+			if _, err := s.db.Exec(`UPDATE messages SET scheduled_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, messageID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return messageID
+	}
+	status := func(t *testing.T, messageID string) string {
+		t.Helper()
+
+		var status string
+		if err := s.db.QueryRow(`SELECT status FROM messages WHERE id = $1`, messageID).Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	entries := func(t *testing.T, messageID string) int {
+		t.Helper()
+
+		var count int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM outbox_messages WHERE message_id = $1`, messageID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	releaseAll := func(t *testing.T) int {
+		t.Helper()
+
+		total := 0
+		for {
+			n, err := scheduler.ReleaseDue(ctx)
+			if err != nil {
+				t.Fatalf("Failed to release due messages: %v", err)
+			}
+			if n == 0 {
+				return total
+			}
+			total += n
+		}
+	}
+
+	t.Run("releases due messages", func(t *testing.T) {
+		tenant := s.createTenant(t, "schedule-test-tenant")
+		suspended := s.createTenant(t, "schedule-suspended-tenant")
+
+		due := schedule(t, tenant.ID, true)
+		later := schedule(t, tenant.ID, false)
+		cancelled := schedule(t, tenant.ID, true)
+		held := schedule(t, suspended.ID, true)
+
+		if err := messages.CancelScheduled(ctx, tenant.ID, cancelled); err != nil {
+			t.Fatalf("Failed to cancel: %v", err)
+		}
+
+		// Suspended tenants rejecting publishes keep their messages scheduled
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/tenants/%s/suspend", suspended.ID), nil)
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to suspend tenant: %v", err)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"publish": "reject"})
+		req, _ = http.NewRequest("PUT", fmt.Sprintf("/v1/tenants/%s/config/suspension", suspended.ID), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if resp, err := s.do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to update suspension config: %v", err)
+		}
+
+		if released := releaseAll(t); released != 1 {
+			t.Fatalf("Expected 1 message to be released, got %d", released)
+		}
+		for messageID, want := range map[string]string{
+			due:       "pending",
+			later:     "scheduled",
+			cancelled: "cancelled",
+			held:      "scheduled",
+		} {
+			if got := status(t, messageID); got != want {
+				t.Fatalf("Expected message %s to be %s, got %s", messageID, want, got)
+			}
+		}
+		if n := entries(t, due); n != 1 {
+			t.Fatalf("Expected one outbox entry for the due message, got %d", n)
+		}
+		for _, messageID := range []string{later, cancelled, held} {
+			if n := entries(t, messageID); n != 0 {
+				t.Fatalf("Expected no outbox entry for message %s, got %d", messageID, n)
+			}
+		}
+
+		// Released messages are not released again
+		if released := releaseAll(t); released != 0 {
+			t.Fatalf("Expected nothing left to release, got %d", released)
+		}
+	})
+
+	t.Run("releases once while cancels race", func(t *testing.T) {
+		tenant := s.createTenant(t, "schedule-race-tenant")
+
+		ids := make([]string, 50)
+		for i := range ids {
+			ids[i] = schedule(t, tenant.ID, true)
+		}
+
+		// Several schedulers release while every message is cancelled
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					n, err := scheduler.ReleaseDue(ctx)
+					if err != nil {
+						t.Errorf("Failed to release due messages: %v", err)
+						return
+					}
+					if n == 0 {
+						return
+					}
+				}
+			}()
+		}
+		cancelErrs := make([]error, len(ids))
+		for i, messageID := range ids {
+			cancelErrs[i] = messages.CancelScheduled(ctx, tenant.ID, messageID)
+		}
+		wg.Wait()
+
+		for i, messageID := range ids {
+			switch got := status(t, messageID); got {
+			case "cancelled":
+				if cancelErrs[i] != nil || entries(t, messageID) != 0 {
+					t.Fatalf("Cancelled message %s: cancel returned %v, %d outbox entries", messageID, cancelErrs[i], entries(t, messageID))
+				}
+			case "pending":
+				if !errors.Is(cancelErrs[i], services.ErrNotScheduled) || entries(t, messageID) != 1 {
+					t.Fatalf("Released message %s: cancel returned %v, %d outbox entries", messageID, cancelErrs[i], entries(t, messageID))
+				}
+			default:
+				t.Fatalf("Expected message %s to be released or cancelled, got %s", messageID, got)
+			}
+		}
+	})
 }
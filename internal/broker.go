@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2/log"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// NewBroker builds the queue backend selected by queue.driver. The returned
+// mq client is nil when running on the postgres driver.
+func NewBroker(s *config.Server) (services.Broker, *mq.Client, error) {
+	if s.Config.Queue.Driver == config.QueueDriverPostgres {
+		log.Info("using postgres queue driver")
+		broker := services.NewPostgresBroker(s.DB, s.Config.Queue.VisibilityTimeout, s.Config.Queue.PollInterval, s.Config.Queue.RetryDelay)
+		return broker, nil, nil
+	}
+
+	mqClient, err := mq.Dial(s.Config.RabbitMQ.ConnectionString())
+	if err != nil {
+		return nil, nil, err
+	}
+	s.RabbitMQ = mqClient.Conn
+
+	mqChannel, err := mqClient.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rabbitmqService, err := services.NewRabbitMQService(mqClient.Conn, mqChannel, s.Config.Queue.RetryDelay)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mqClient.OnReconnect(func(conn *amqp.Connection) {
+		s.RabbitMQ = conn
+		if err := rabbitmqService.Reset(conn); err != nil {
+			log.Errorf("Failed to reset rabbitmq service; error: %v", err)
+		}
+	})
+
+	return rabbitmqService, mqClient, nil
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+type Auth struct {
+	// TokenTTL is how long a token issued at login is valid
+	TokenTTL time.Duration
+	// MaxFailedLogins is how many wrong passwords in a row lock an account
+	MaxFailedLogins int
+	// LockoutDuration is how long a locked account rejects logins
+	LockoutDuration time.Duration
+	// AdminUsername and AdminPasswordHash are the platform admin's login;
+	// without a hash nobody can log in as platform admin
+	AdminUsername     string
+	AdminPasswordHash string
+}
@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type Autoscale struct {
+	// Enabled turns queue-depth-driven scaling of tenant worker pools on
+	Enabled bool
+	// Interval is how often queue depth and utilization are sampled
+	Interval time.Duration
+	// MinWorkers is the smallest pool a tenant is scaled down to; the
+	// largest is the tenant's max_workers
+	MinWorkers int
+	// ScaleUpBacklog is the number of ready messages per worker above which
+	// a pool grows
+	ScaleUpBacklog int
+	// ScaleDownUtilization is the share of busy workers below which a pool
+	// is considered idle
+	ScaleDownUtilization float64
+	// ScaleDownSamples is how many consecutive idle samples shrink a pool
+	ScaleDownSamples int
+	// ScaleUpCooldown and ScaleDownCooldown are the minimum time between a
+	// scaling decision and the next one in that direction
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
@@ -0,0 +1,7 @@
+package config
+
+type Bundle struct {
+	// ImportMaxSize is the largest tenant bundle accepted for import, in
+	// bytes
+	ImportMaxSize int64
+}
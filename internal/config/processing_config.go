@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+type Processing struct {
+	// UnknownTypePolicy handles messages without a registered handler:
+	// reject (default), fallback or ignore
+	UnknownTypePolicy string
+	// HandlerTimeout bounds a single handler invocation
+	HandlerTimeout time.Duration
+	// WebhookTimeout bounds a webhook request unless the tenant sets its own
+	WebhookTimeout time.Duration
+	// EmailTimeout bounds an SMTP session and each attachment download
+	EmailTimeout time.Duration
+	// PrivateAllowlist lists CIDRs, IP addresses and host names that webhook
+	// receivers, SMTP servers and attachments may use although they are not
+	// public, e.g. services inside the platform network
+	PrivateAllowlist []string
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+type Purge struct {
+	// Enabled turns the purge of deleted tenants on
+	Enabled bool
+	// GracePeriod is how long a deleted tenant's data is kept before it is
+	// purged
+	GracePeriod time.Duration
+	// Interval is how often deleted tenants are checked for a purge
+	Interval time.Duration
+	// ArchiveDir is where a tenant's messages are archived before its
+	// partition is dropped; empty drops them without an archive
+	ArchiveDir string
+}
@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+const (
+	QueueDriverRabbitMQ = "rabbitmq"
+	QueueDriverPostgres = "postgres"
+)
+
+type Queue struct {
+	// Driver selects the queue backend: rabbitmq (default) or postgres
+	Driver string
+	// VisibilityTimeout is how long a postgres consumer holds a message
+	// before it becomes claimable again, unless the lease is renewed
+	VisibilityTimeout time.Duration
+	// PollInterval is how often an idle postgres consumer checks for work
+	PollInterval time.Duration
+	// RetryDelay is how long a failed message waits before it is delivered
+	// again
+	RetryDelay time.Duration
+}
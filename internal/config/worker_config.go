@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type Worker struct {
+	// ID identifies the worker node in tenant assignments; defaults to the
+	// hostname. Keep it stable across restarts to keep the node's tenants
+	ID string
+	// LeaseTTL is how long a node owns a tenant without heartbeating
+	LeaseTTL time.Duration
+	// HeartbeatInterval is how often a node renews its leases
+	HeartbeatInterval time.Duration
+	// Replicas is the number of nodes each tenant is consumed on; the
+	// tenant's worker count is split between them
+	Replicas int
+	// DrainTimeout bounds how long a stopping consumer waits for its
+	// in-flight messages, on shutdown and when its tenant is deleted
+	DrainTimeout time.Duration
+	// Budget caps the messages processed at once on the node across all
+	// tenants; zero or less leaves only the per-tenant limits
+	Budget int
+	// MetricsAddr is where the worker serves Prometheus metrics; empty
+	// disables the listener
+	MetricsAddr string
+}
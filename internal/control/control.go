@@ -1,6 +1,15 @@
 package control
 
+import amqp "github.com/rabbitmq/amqp091-go"
+
 const Exchange = "app.control"
 const RKCreate = "tenant.create"
 const RKUpdate = "tenant.update"
 const RKDelete = "tenant.delete"
+const RKSuspend = "tenant.suspend"
+const RKResume = "tenant.resume"
+
+// Declare declares the control exchange on ch.
+func Declare(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(Exchange, "direct", true, false, false, false, nil)
+}
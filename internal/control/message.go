@@ -1,6 +1,35 @@
 package control
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version is the control message schema version this build writes. Workers
+// reject messages of newer versions; messages without a version predate
+// versioning and are read as version 1.
+const Version = 1
+
 type Message struct {
-	TenantID string `json:"tenant_id"`
-	Workers  int32  `json:"workers,omitempty"`
+	Version  int       `json:"version"`
+	ID       string    `json:"id"` // unique per command, for tracing it across workers
+	TenantID string    `json:"tenant_id"`
+	Workers  int32     `json:"workers,omitempty"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+func NewMessage(tenantID string, workers int32) Message {
+	return Message{
+		Version:  Version,
+		ID:       uuid.NewString(),
+		TenantID: tenantID,
+		Workers:  workers,
+		IssuedAt: time.Now().UTC(),
+	}
+}
+
+// Supported reports whether this build understands the message's schema.
+func (m Message) Supported() bool {
+	return m.Version <= Version
 }
@@ -0,0 +1,97 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher sends control messages to every worker bound to the control
+// exchange. Publishes are confirmed by the broker.
+type Publisher struct {
+	client *mq.Client
+	ch     *amqp.Channel
+	mutex  sync.Mutex
+}
+
+func NewPublisher(client *mq.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+func (p *Publisher) Publish(ctx context.Context, routingKey string, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	ctxTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctxTimeout, Exchange, routingKey, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.IssuedAt,
+		Type:         routingKey,
+		Body:         body,
+	})
+	if err != nil {
+		p.reset()
+		return fmt.Errorf("failed to publish control message: %w", err)
+	}
+
+	acked, err := confirm.WaitContext(ctxTimeout)
+	if err != nil {
+		p.reset()
+		return fmt.Errorf("failed to confirm control message: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("control message %s was nacked by the broker", msg.ID)
+	}
+
+	return nil
+}
+
+// channel returns the publishing channel, opening a new one after the
+// previous one closed, e.g. across a reconnect.
+func (p *Publisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	ch, err := p.client.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := Declare(ch); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	p.ch = ch
+	return ch, nil
+}
+
+func (p *Publisher) reset() {
+	if p.ch != nil {
+		p.ch.Close()
+		p.ch = nil
+	}
+}
@@ -1,7 +1,7 @@
 CREATE TABLE tenants (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     name VARCHAR(255) NOT NULL,
-    status VARCHAR(50) DEFAULT 'active', -- active, inactive, suspended
+    status VARCHAR(50) DEFAULT 'active', -- provisioning, active, deleting, stopped, suspended
     max_workers INTEGER DEFAULT 3,
     current_workers INTEGER DEFAULT 3,
     queue_name VARCHAR(255) NOT NULL, -- tenant_{id}_queue
@@ -13,16 +13,23 @@ CREATE TABLE tenants (
 
 CREATE UNIQUE INDEX idx_tenants_queue_name ON tenants(queue_name);
 CREATE INDEX idx_tenants_status ON tenants(status) WHERE deleted_at IS NULL;
+CREATE INDEX idx_tenants_created_at_id ON tenants(created_at, id);
 CREATE TABLE messages (
     id UUID DEFAULT gen_random_uuid(),
     tenant_id UUID NOT NULL,
     payload JSONB NOT NULL,
-    status VARCHAR(50) DEFAULT 'pending', -- pending, processing, completed, failed
+    status VARCHAR(50) DEFAULT 'pending', -- scheduled, pending, processing, completed, failed, cancelled
     retry_count INTEGER DEFAULT 0,
     max_retries INTEGER DEFAULT 3,
     scheduled_at TIMESTAMPTZ DEFAULT NOW(),
     processed_at TIMESTAMPTZ NULL,
     created_at TIMESTAMPTZ DEFAULT NOW(),
+    -- Postgres queue driver state
+    headers JSONB NULL,
+    visible_at TIMESTAMPTZ NULL, -- NULL until published, lease expiry while processing
+    lease_token UUID NULL,
+    delivery_count INTEGER DEFAULT 0,
+    priority SMALLINT DEFAULT 0, -- 0=normal, 1=high, 2=urgent
     -- Composite primary key MUST include partition key
     PRIMARY KEY (id, tenant_id),
     CONSTRAINT fk_messages_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants(id)
@@ -31,8 +38,9 @@ CREATE TABLE messages (
 -- Indexes for each partition will be created automatically
 CREATE INDEX idx_messages_status ON messages(status);
 CREATE INDEX idx_messages_created_at ON messages(created_at DESC);
-CREATE INDEX idx_messages_scheduled_at ON messages(scheduled_at) WHERE status = 'pending';
+CREATE INDEX idx_messages_scheduled_at ON messages(scheduled_at) WHERE status = 'scheduled';
 CREATE INDEX idx_messages_tenant_id_id ON messages(tenant_id, id); -- For efficient lookups
+CREATE INDEX idx_messages_visible_at ON messages(tenant_id, priority DESC, visible_at) WHERE status IN ('pending', 'processing');
 CREATE TABLE tenant_configs (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     tenant_id UUID NOT NULL,
@@ -43,18 +51,19 @@ CREATE TABLE tenant_configs (
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
     CONSTRAINT fk_tenant_configs_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants(id),
-    CONSTRAINT unique_tenant_config_key UNIQUE (tenant_id, config_key, is_active)
+    CONSTRAINT unique_tenant_config_version UNIQUE (tenant_id, config_key, version)
 );
 
-CREATE INDEX idx_tenant_configs_lookup ON tenant_configs(tenant_id, config_key) WHERE is_active = true;
+CREATE UNIQUE INDEX idx_tenant_configs_lookup ON tenant_configs(tenant_id, config_key) WHERE is_active = true; -- One active version per key
 CREATE TABLE message_processing_logs (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     message_id UUID NOT NULL,
     tenant_id UUID NOT NULL,
     worker_id VARCHAR(100),
-    status VARCHAR(50) NOT NULL, -- started, completed, failed, retrying
+    status VARCHAR(50) NOT NULL, -- started, completed, failed, retrying, replayed, <channel>_delivered, <channel>_failed
     error_message TEXT NULL,
     processing_duration_ms INTEGER NULL,
+    response_status INTEGER NULL, -- Status code returned by a webhook or mail server
     created_at TIMESTAMPTZ DEFAULT NOW(),
     -- Foreign key must reference composite primary key
     CONSTRAINT fk_logs_message FOREIGN KEY (message_id, tenant_id) REFERENCES messages(id, tenant_id),
@@ -78,3 +87,73 @@ CREATE TABLE dead_letter_messages (
 
 CREATE INDEX idx_dlm_tenant_id ON dead_letter_messages(tenant_id);
 CREATE INDEX idx_dlm_created_at ON dead_letter_messages(created_at DESC);
+CREATE INDEX idx_dlm_tenant_created ON dead_letter_messages(tenant_id, created_at DESC, id DESC); -- For dead-letter listing
+
+CREATE TABLE outbox_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL,
+    tenant_id UUID NOT NULL,
+    payload JSONB NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(50) DEFAULT 'pending', -- pending, publishing, published, failed
+    attempts INTEGER DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMPTZ DEFAULT NOW(), -- While publishing, when the relay's lease ends
+    published_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT fk_outbox_message FOREIGN KEY (message_id, tenant_id) REFERENCES messages(id, tenant_id),
+    CONSTRAINT fk_outbox_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants(id)
+);
+
+CREATE INDEX idx_outbox_pending ON outbox_messages(next_attempt_at) WHERE status IN ('pending', 'publishing');
+
+CREATE TABLE worker_nodes (
+    id VARCHAR(100) PRIMARY KEY, -- WORKER_ID, defaults to the hostname
+    hostname VARCHAR(255),
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    heartbeat_at TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE TABLE tenant_assignments (
+    tenant_id UUID NOT NULL,
+    slot INTEGER NOT NULL DEFAULT 0, -- 0 .. WORKER_REPLICAS-1
+    node_id VARCHAR(100) NOT NULL,
+    lease_expires_at TIMESTAMPTZ NOT NULL, -- Renewed by the owner's heartbeat
+    assigned_at TIMESTAMPTZ DEFAULT NOW(),
+    workers INTEGER NULL, -- Pool size on the owner as of its last heartbeat, NULL while not consuming
+    busy INTEGER NULL, -- Workers of that pool processing a message
+    PRIMARY KEY (tenant_id, slot),
+    CONSTRAINT fk_assignments_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants(id)
+);
+
+CREATE INDEX idx_assignments_node_id ON tenant_assignments(node_id);
+
+CREATE TABLE tenant_purges (
+    tenant_id UUID PRIMARY KEY, -- No foreign key, the tenant row is purged too
+    tenant_name VARCHAR(255) NOT NULL,
+    step VARCHAR(50) NOT NULL, -- Next step to run, done once purged
+    archive_path TEXT NULL,
+    last_error TEXT NULL,
+    deleted_at TIMESTAMPTZ NOT NULL,
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    completed_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX idx_tenant_purges_pending ON tenant_purges(started_at) WHERE completed_at IS NULL;
+
+CREATE TABLE tenant_users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    password_hash TEXT NOT NULL, -- bcrypt
+    roles TEXT[] NOT NULL DEFAULT '{}', -- admin, member
+    failed_logins INTEGER NOT NULL DEFAULT 0, -- Wrong passwords since the last login
+    locked_until TIMESTAMPTZ NULL,
+    disabled_at TIMESTAMPTZ NULL,
+    last_login_at TIMESTAMPTZ NULL,
+    token_version INTEGER NOT NULL DEFAULT 0, -- Bumped to revoke the user's tokens
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT fk_tenant_users_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants(id),
+    CONSTRAINT unique_tenant_username UNIQUE (tenant_id, username)
+);
@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 type AuthHandler struct {
-	secret string
+	secret   string
+	tokenTTL time.Duration
+	users    *services.TenantUserService
+	admin    *services.PlatformAdmin
 }
 
 type LoginRequest struct {
@@ -19,21 +25,32 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type AdminLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
 type TokenResponse struct {
 	Token string `json:"token"`
 }
 
-func NewAuthHandler(s *config.Server, secret string) []fiber.Router {
-	handler := AuthHandler{secret: secret}
+func NewAuthHandler(s *config.Server, secret string, us *services.TenantUserService, admin *services.PlatformAdmin) []fiber.Router {
+	handler := AuthHandler{secret: secret, tokenTTL: s.Config.Auth.TokenTTL, users: us, admin: admin}
+	if handler.tokenTTL <= 0 {
+		handler.tokenTTL = 72 * time.Hour
+	}
+
+	public := newRouteGroups(s).Public()
 
 	return []fiber.Router{
-		s.Fiber.Post("/v1/auth/login", handler.Login),
+		public.Post("/v1/auth/login", handler.Login),
+		public.Post("/v1/auth/admin/login", handler.AdminLogin),
 	}
 }
 
 // Login generates a JWT token for authentication
 // @Summary Login and get JWT token
-// @Description Authenticate and receive a JWT token for API access
+// @Description Authenticate as a tenant user and receive a JWT token for API access. The token carries the user ID, tenant ID and roles. Accounts are locked for a while after repeated wrong passwords.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -41,6 +58,8 @@ func NewAuthHandler(s *config.Server, secret string) []fiber.Router {
 // @Success 200 {object} TokenResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 423 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	var req LoginRequest
@@ -50,21 +69,96 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Simple validation (in production, use proper authentication)
 	if req.Username == "" || req.Password == "" || req.TenantID == "" {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"error": "Username, password, and tenant_id are required",
 		})
 	}
+	if uuid.Validate(req.TenantID) != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid credentials",
+		})
+	}
+
+	user, err := h.users.Authenticate(c.Context(), req.TenantID, req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid credentials",
+			})
+		case errors.Is(err, services.ErrUserLocked):
+			return c.Status(http.StatusLocked).JSON(fiber.Map{
+				"error": "Account locked after repeated failed logins, try again later",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not verify credentials",
+		})
+	}
+
+	// Create JWT token with user and tenant information
+	return h.respondWithToken(c, jwt.MapClaims{
+		"sub":           user.ID,
+		"user_id":       user.ID,
+		"username":      user.Username,
+		"tenant_id":     user.TenantID,
+		"roles":         user.Roles,
+		"token_version": user.TokenVersion,
+	})
+}
+
+// AdminLogin generates a JWT token for the platform admin
+// @Summary Login as platform admin
+// @Description Authenticate as the platform admin configured with AUTH_ADMIN_USERNAME and AUTH_ADMIN_PASSWORD_HASH. The token is not scoped to a tenant and carries the platform_admin role, which may create, delete and size tenants and act on any tenant. A client address is refused for AUTH_LOCKOUT_DURATION after AUTH_MAX_FAILED_LOGINS failed logins.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body AdminLoginRequest true "Login credentials"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/admin/login [post]
+func (h *AuthHandler) AdminLogin(c *fiber.Ctx) error {
+	var req AdminLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Username == "" || req.Password == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username and password are required",
+		})
+	}
+
+	if err := h.admin.Authenticate(c.IP(), req.Username, req.Password); err != nil {
+		if errors.Is(err, services.ErrUserLocked) {
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many failed logins from this address, try again later",
+			})
+		}
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid credentials",
+		})
+	}
+
+	return h.respondWithToken(c, jwt.MapClaims{
+		"sub":      req.Username,
+		"username": req.Username,
+		"roles":    []string{services.RolePlatformAdmin},
+	})
+}
 
-	// Create JWT token with tenant information
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["username"] = req.Username
-	claims["tenant_id"] = req.TenantID
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
+// respondWithToken signs claims into a token valid for the token TTL.
+func (h *AuthHandler) respondWithToken(c *fiber.Ctx, claims jwt.MapClaims) error {
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(h.tokenTTL).Unix()
 
-	t, err := token.SignedString([]byte(h.secret))
+	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(h.secret))
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not generate token",
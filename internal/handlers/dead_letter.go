@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type DeadLetterHandler struct {
+	deadLetterService *services.DeadLetterService
+}
+
+func NewDeadLetterHandler(s *config.Server, dls *services.DeadLetterService) []fiber.Router {
+	handler := DeadLetterHandler{
+		deadLetterService: dls,
+	}
+
+	// Any tenant user may inspect and replay dead letters; deleting them
+	// for good takes a tenant admin
+	routes := newRouteGroups(s)
+	tenant := routes.Tenant("tenant_id")
+	tenantAdmin := routes.Tenant("tenant_id", services.RoleAdmin)
+
+	return []fiber.Router{
+		tenant.Get("/v1/tenants/:tenant_id/dead-letters", handler.ListDeadLetters),
+		tenant.Get("/v1/tenants/:tenant_id/dead-letters/:id", handler.GetDeadLetter),
+		tenant.Post("/v1/tenants/:tenant_id/dead-letters/replay", handler.ReplayDeadLetters),
+		tenantAdmin.Post("/v1/tenants/:tenant_id/dead-letters/purge", handler.PurgeDeadLetters),
+		tenant.Post("/v1/tenants/:tenant_id/dead-letters/:id/replay", handler.ReplayDeadLetter),
+		tenantAdmin.Delete("/v1/tenants/:tenant_id/dead-letters/:id", handler.DeleteDeadLetter),
+	}
+}
+
+// ListDeadLetters lists the dead letters of a tenant
+// @Summary List dead letters
+// @Description List messages that exhausted their retries, newest first, using cursor-based pagination
+// @Tags dead-letters
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param from query string false "Only entries created at or after this time (RFC 3339)"
+// @Param to query string false "Only entries created before this time (RFC 3339)"
+// @Param reason query string false "Substring of the failure reason or last error"
+// @Param type query string false "Message type"
+// @Param cursor query string false "Cursor for pagination"
+// @Param limit query int false "Number of entries to retrieve (max 100)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters [get]
+func (h *DeadLetterHandler) ListDeadLetters(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	filter := services.DeadLetterFilter{
+		Reason: c.Query("reason"),
+		Type:   c.Query("type"),
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+	}
+
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": param + " must be an RFC 3339 timestamp",
+			})
+		}
+		*target = &t
+	}
+
+	if filter.Cursor != "" && uuid.Validate(filter.Cursor) != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
+
+	entries, nextCursor, err := h.deadLetterService.List(c.Context(), middleware.TenantID(c), filter)
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to fetch dead letters"))
+	}
+
+	response := fiber.Map{
+		"data": entries,
+	}
+
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+
+	return c.JSON(response)
+}
+
+// GetDeadLetter returns a single dead letter of a tenant
+// @Summary Get a dead letter
+// @Description Get a dead letter including its payload and last error
+// @Tags dead-letters
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "Dead letter ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters/{id} [get]
+func (h *DeadLetterHandler) GetDeadLetter(c *fiber.Ctx) error {
+	if uuid.Validate(c.Params("id")) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	entry, err := h.deadLetterService.Get(c.Context(), middleware.TenantID(c), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Dead letter not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to fetch dead letter"))
+	}
+
+	return c.JSON(entry)
+}
+
+// ReplayDeadLetter replays a single dead letter
+// @Summary Replay a dead letter
+// @Description Publish a dead letter to the tenant queue again with a fresh retry budget
+// @Tags dead-letters
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "Dead letter ID"
+// @Success 202 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters/{id}/replay [post]
+func (h *DeadLetterHandler) ReplayDeadLetter(c *fiber.Ctx) error {
+	if uuid.Validate(c.Params("id")) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	replayed, _, err := h.deadLetterService.Replay(c.Context(), middleware.TenantID(c), []string{c.Params("id")})
+	if errors.Is(err, services.ErrTenantSuspended) {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Tenant is suspended and rejects new messages",
+		})
+	}
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to replay dead letter"))
+	}
+
+	if len(replayed) == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message_id": replayed[0],
+		"status":     "queued",
+	})
+}
+
+// ReplayDeadLetters replays a selection or all dead letters
+// @Summary Replay dead letters
+// @Description Publish the selected dead letters, or all of them, to the tenant queue again with a fresh retry budget. Selected ids that are no dead letters (anymore), e.g. because they were replayed or purged meanwhile, are listed in missing.
+// @Tags dead-letters
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param selection body models.DeadLetterSelection true "Entries to replay"
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters/replay [post]
+func (h *DeadLetterHandler) ReplayDeadLetters(c *fiber.Ctx) error {
+	ids, err := parseDeadLetterSelection(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	replayed, missing, err := h.deadLetterService.Replay(c.Context(), middleware.TenantID(c), ids)
+	if errors.Is(err, services.ErrTenantSuspended) {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Tenant is suspended and rejects new messages",
+		})
+	}
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to replay dead letters"))
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message_ids": replayed,
+		"replayed":    len(replayed),
+		"missing":     missing,
+	})
+}
+
+// DeleteDeadLetter purges a single dead letter
+// @Summary Purge a dead letter
+// @Description Permanently delete a dead letter
+// @Tags dead-letters
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "Dead letter ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters/{id} [delete]
+func (h *DeadLetterHandler) DeleteDeadLetter(c *fiber.Ctx) error {
+	if uuid.Validate(c.Params("id")) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	purged, err := h.deadLetterService.Purge(c.Context(), middleware.TenantID(c), []string{c.Params("id")})
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to purge dead letter"))
+	}
+
+	if purged == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"purged": purged,
+	})
+}
+
+// PurgeDeadLetters purges a selection or all dead letters
+// @Summary Purge dead letters
+// @Description Permanently delete the selected dead letters, or all of them
+// @Tags dead-letters
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param selection body models.DeadLetterSelection true "Entries to purge"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/dead-letters/purge [post]
+func (h *DeadLetterHandler) PurgeDeadLetters(c *fiber.Ctx) error {
+	ids, err := parseDeadLetterSelection(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	purged, err := h.deadLetterService.Purge(c.Context(), middleware.TenantID(c), ids)
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to purge dead letters"))
+	}
+
+	return c.JSON(fiber.Map{
+		"purged": purged,
+	})
+}
+
+// parseDeadLetterSelection returns the selected ids, or nil when every entry
+// is selected. Acting on all entries has to be requested explicitly.
+func parseDeadLetterSelection(c *fiber.Ctx) ([]string, error) {
+	selection := new(models.DeadLetterSelection)
+	if err := c.BodyParser(selection); err != nil {
+		return nil, err
+	}
+
+	if selection.All {
+		if len(selection.IDs) > 0 {
+			return nil, errors.New("ids and all are mutually exclusive")
+		}
+		return nil, nil
+	}
+
+	if len(selection.IDs) == 0 {
+		return nil, errors.New("either ids or all is required")
+	}
+
+	for _, id := range selection.IDs {
+		if uuid.Validate(id) != nil {
+			return nil, errors.New("invalid dead letter id: " + id)
+		}
+	}
+
+	return selection.IDs, nil
+}
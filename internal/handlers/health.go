@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthHandler struct {
+	server   *config.Server
+	mqClient *mq.Client
+}
+
+func NewHealthHandler(s *config.Server, mqClient *mq.Client) []fiber.Router {
+	handler := HealthHandler{server: s, mqClient: mqClient}
+
+	return []fiber.Router{
+		newRouteGroups(s).Public().Get("/health", handler.Health),
+	}
+}
+
+// Health reports the state of the database and RabbitMQ connections
+// @Summary Health check
+// @Description Report database and RabbitMQ connection state
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /health [get]
+func (h *HealthHandler) Health(c *fiber.Ctx) error {
+	status := http.StatusOK
+
+	database := "connected"
+	if h.server.DB == nil || h.server.DB.PingContext(c.Context()) != nil {
+		database = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	// No client means the postgres queue driver is in use
+	rabbitmq := "disabled"
+	if h.mqClient != nil {
+		rabbitmq = h.mqClient.State()
+		if rabbitmq != mq.StateConnected {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"database": database,
+		"rabbitmq": rabbitmq,
+	})
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/gofiber/fiber/v2"
@@ -20,9 +23,13 @@ func NewMessageHandler(s *config.Server, ms *services.MessageService) []fiber.Ro
 		messageServices: ms,
 	}
 
+	tenant := newRouteGroups(s).Tenant("tenant_id")
+
 	return []fiber.Router{
-		s.Fiber.Post("/v1/tenants/:tenant_id/messages", handler.PublishMessage),
-		s.Fiber.Get("/v1/messages", handler.GetMessages),
+		tenant.Post("/v1/tenants/:tenant_id/messages", handler.PublishMessage),
+		tenant.Get("/v1/tenants/:tenant_id/messages/:id", handler.GetMessage),
+		tenant.Post("/v1/tenants/:tenant_id/messages/:id/cancel", handler.CancelMessage),
+		tenant.Get("/v1/messages", handler.GetMessages),
 	}
 }
 
@@ -36,40 +43,130 @@ func NewMessageHandler(s *config.Server, ms *services.MessageService) []fiber.Ro
 // @Param message body models.MessageRequest true "Message data"
 // @Success 202 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /tenants/{tenant_id}/messages [post]
 func (h *MessageHandler) PublishMessage(c *fiber.Ctx) error {
-	tenantID := c.Params("tenant_id")
+	tenantID := middleware.TenantID(c)
 
 	messageReq := new(models.MessageRequest)
 	if err := c.BodyParser(messageReq); err != nil {
 		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
 	}
 
+	if messageReq.Priority < 0 || messageReq.Priority > services.MaxPriority {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "priority must be 0 (normal), 1 (high) or 2 (urgent)",
+		})
+	}
+
 	messageID := uuid.NewString()
 
 	// Publish to RabbitMQ
-	err := h.messageServices.Publish(c.Context(), tenantID, messageID, messageReq)
+	status, err := h.messageServices.Publish(c.Context(), tenantID, messageID, messageReq)
 	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTenantSuspended):
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "Tenant is suspended and rejects new messages",
+			})
+		case errors.Is(err, services.ErrUnroutable):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error":      "Tenant queue not found",
+				"message_id": messageID,
+			})
+		case errors.Is(err, services.ErrNotConfirmed):
+			// Stored in the outbox; the relay keeps retrying
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+				"error":      "Message broker did not confirm the message",
+				"message_id": messageID,
+				"status":     "pending",
+				"tenant_id":  tenantID,
+			})
+		}
 		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to publish message"))
 	}
 
 	return c.Status(http.StatusAccepted).JSON(fiber.Map{
 		"message_id": messageID,
-		"status":     "queued",
+		"status":     status,
+		"tenant_id":  tenantID,
+	})
+}
+
+// GetMessage returns a single message of a tenant
+// @Summary Get a message
+// @Description Get a message and its current status (scheduled, pending, processing, completed, failed, cancelled)
+// @Tags messages
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "Message ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/messages/{id} [get]
+func (h *MessageHandler) GetMessage(c *fiber.Ctx) error {
+	message, err := h.messageServices.GetMessage(c.Context(), middleware.TenantID(c), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Message not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to fetch message"))
+	}
+
+	return c.JSON(message)
+}
+
+// CancelMessage cancels a scheduled message
+// @Summary Cancel a scheduled message
+// @Description Cancel a message that is still waiting for its scheduled time
+// @Tags messages
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "Message ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/messages/{id}/cancel [post]
+func (h *MessageHandler) CancelMessage(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+	messageID := c.Params("id")
+
+	err := h.messageServices.CancelScheduled(c.Context(), tenantID, messageID)
+	if err != nil {
+		if errors.Is(err, services.ErrNotScheduled) {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to cancel message"))
+	}
+
+	return c.JSON(fiber.Map{
+		"message_id": messageID,
+		"status":     "cancelled",
 		"tenant_id":  tenantID,
 	})
 }
 
 // GetMessages retrieves messages with cursor pagination
 // @Summary Get messages with pagination
-// @Description Retrieve messages using cursor-based pagination
+// @Description Retrieve the caller's tenant's messages using cursor-based pagination. The platform admin sees every tenant's messages, or those of tenant_id.
 // @Tags messages
 // @Produce json
+// @Param tenant_id query string false "Tenant ID, for the platform admin"
 // @Param cursor query string false "Cursor for pagination"
 // @Param limit query int false "Number of messages to retrieve (max 100)"
 // @Success 200 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
+// @Security BearerAuth
 // @Router /messages [get]
 func (h *MessageHandler) GetMessages(c *fiber.Ctx) error {
 	cursor := c.Query("cursor", "0")
@@ -85,7 +182,17 @@ func (h *MessageHandler) GetMessages(c *fiber.Ctx) error {
 		cursorID = 0
 	}
 
-	messages, nextCursor, err := h.messageServices.GetMessagesPaginated(c.Context(), cursorID, limit)
+	tenantID := middleware.TenantID(c)
+	if middleware.IsPlatformAdmin(c) {
+		tenantID = c.Query("tenant_id")
+		if tenantID != "" && uuid.Validate(tenantID) != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid tenant_id",
+			})
+		}
+	}
+
+	messages, nextCursor, err := h.messageServices.GetMessagesPaginated(c.Context(), tenantID, cursorID, limit)
 	if err != nil {
 		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to fetch messages"))
 	}
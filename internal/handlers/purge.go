@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type PurgeHandler struct {
+	tenantPurger *services.TenantPurger
+}
+
+func NewPurgeHandler(s *config.Server, tp *services.TenantPurger) []fiber.Router {
+	handler := PurgeHandler{
+		tenantPurger: tp,
+	}
+
+	platform := newRouteGroups(s).Platform()
+
+	return []fiber.Router{
+		platform.Get("/v1/purges", handler.ListPurges),
+		platform.Get("/v1/tenants/:id/purge", handler.GetPurge),
+	}
+}
+
+// ListPurges lists the purges of deleted tenants
+// @Summary List tenant purges
+// @Description List the purges of deleted tenants, running ones first, with the step each one is at and its last error.
+// @Tags purges
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /purges [get]
+func (h *PurgeHandler) ListPurges(c *fiber.Ctx) error {
+	purges, err := h.tenantPurger.List(c.Context())
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"data": purges,
+	})
+}
+
+// GetPurge returns the purge progress of a deleted tenant
+// @Summary Get a tenant purge
+// @Description Get the purge progress of a deleted tenant. Tenants within their grace period are reported as "scheduled" with the time their purge may start.
+// @Tags purges
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} services.TenantPurge
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/purge [get]
+func (h *PurgeHandler) GetPurge(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+	if uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Purge not found",
+		})
+	}
+
+	purge, err := h.tenantPurger.Get(c.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrPurgeNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Purge not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(purge)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RouteGroup registers routes behind the middleware of its group. Unlike
+// fiber.Group it guards only its own routes, not a whole path prefix:
+// public, tenant and platform admin routes all live under /v1.
+type RouteGroup struct {
+	router   fiber.Router
+	handlers []fiber.Handler
+}
+
+func (g RouteGroup) Get(path string, handler fiber.Handler) fiber.Router {
+	return g.router.Get(path, g.chain(handler)...)
+}
+
+func (g RouteGroup) Post(path string, handler fiber.Handler) fiber.Router {
+	return g.router.Post(path, g.chain(handler)...)
+}
+
+func (g RouteGroup) Put(path string, handler fiber.Handler) fiber.Router {
+	return g.router.Put(path, g.chain(handler)...)
+}
+
+func (g RouteGroup) Patch(path string, handler fiber.Handler) fiber.Router {
+	return g.router.Patch(path, g.chain(handler)...)
+}
+
+func (g RouteGroup) Delete(path string, handler fiber.Handler) fiber.Router {
+	return g.router.Delete(path, g.chain(handler)...)
+}
+
+func (g RouteGroup) chain(handler fiber.Handler) []fiber.Handler {
+	return slices.Concat(g.handlers, []fiber.Handler{handler})
+}
+
+// routeGroups builds the groups every route belongs to.
+type routeGroups struct {
+	router fiber.Router
+	jwt    fiber.Handler
+}
+
+func newRouteGroups(s *config.Server) routeGroups {
+	users := services.NewTenantUserService(s.DB, services.TenantUserConfig{})
+	return routeGroups{router: s.Fiber, jwt: middleware.JWTProtected(s.Config.AppSecret, users)}
+}
+
+// Public routes need no token.
+func (g routeGroups) Public() RouteGroup {
+	return RouteGroup{router: g.router}
+}
+
+// Tenant routes act on the tenant in the URL parameter param, or on the
+// caller's own tenant when there is none. They need a token of that tenant
+// holding any of roles, or none when roles is empty. The platform admin
+// passes every check.
+func (g routeGroups) Tenant(param string, roles ...string) RouteGroup {
+	handlers := []fiber.Handler{g.jwt, middleware.TenantSpecific(param)}
+	if len(roles) > 0 {
+		handlers = append(handlers, middleware.RequireRole(roles...))
+	}
+
+	return RouteGroup{router: g.router, handlers: handlers}
+}
+
+// Platform routes manage tenants and nodes; only the platform admin may
+// use them.
+func (g routeGroups) Platform() RouteGroup {
+	return RouteGroup{router: g.router, handlers: []fiber.Handler{g.jwt, middleware.PlatformAdmin()}}
+}
@@ -1,32 +1,65 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/aarondl/null/v8"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
 )
 
 type TenantHandler struct {
-	tenantManager *services.TenantManager
+	tenantControl *services.TenantControl
+	configStore   *services.TenantConfigStore
+	addresses     *processor.AddressPolicy
 }
 
-func NewTenantHandler(s *config.Server, tm *services.TenantManager) []fiber.Router {
-	handler := TenantHandler{tenantManager: tm}
+func NewTenantHandler(s *config.Server, tc *services.TenantControl, configs *services.TenantConfigStore) []fiber.Router {
+	// Blocking private addresses stays the default when the allowlist is invalid
+	addresses, err := processor.NewAddressPolicy(s.Config.Processing.PrivateAllowlist)
+	if err != nil {
+		log.Warnf("ignoring processing.private_allowlist: %v", err)
+	}
+	handler := TenantHandler{tenantControl: tc, configStore: configs, addresses: addresses}
+
+	// Tenant users may read their tenant and their admins configure how it
+	// delivers; creating, sizing and stopping tenants is the platform's
+	routes := newRouteGroups(s)
+	platform := routes.Platform()
+	tenant := routes.Tenant("id")
+	tenantAdmin := routes.Tenant("id", services.RoleAdmin)
 
 	return []fiber.Router{
-		s.Fiber.Post("/v1/tenants", handler.CreateTenant),
-		s.Fiber.Delete("/v1/tenants/:id", handler.DeleteTenant),
-		s.Fiber.Put("/v1/tenants/:id/config/concurrency", handler.UpdateTenantConfig),
+		platform.Post("/v1/tenants", handler.CreateTenant),
+		platform.Get("/v1/tenants", handler.ListTenants),
+		tenant.Get("/v1/tenants/:id", handler.GetTenant),
+		platform.Patch("/v1/tenants/:id", handler.UpdateTenant),
+		platform.Delete("/v1/tenants/:id", handler.DeleteTenant),
+		platform.Put("/v1/tenants/:id/config/concurrency", handler.UpdateTenantConfig),
+		tenantAdmin.Put("/v1/tenants/:id/config/webhook", handler.UpdateWebhookConfig),
+		tenantAdmin.Put("/v1/tenants/:id/config/smtp", handler.UpdateSMTPConfig),
+		platform.Put("/v1/tenants/:id/config/scheduling", handler.UpdateSchedulingConfig),
+		platform.Put("/v1/tenants/:id/config/prefetch", handler.UpdatePrefetchConfig),
+		tenantAdmin.Put("/v1/tenants/:id/config/suspension", handler.UpdateSuspensionConfig),
+		platform.Post("/v1/tenants/:id/suspend", handler.SuspendTenant),
+		platform.Post("/v1/tenants/:id/resume", handler.ResumeTenant),
 	}
 }
 
 // CreateTenant creates a new tenant
 // @Summary Create a new tenant
-// @Description Create a new tenant with specified configuration
+// @Description Create a new tenant with specified configuration. The tenant starts as "provisioning" and turns "active" once a worker consumes its queue.
 // @Tags tenants
 // @Accept json
 // @Produce json
@@ -34,6 +67,7 @@ func NewTenantHandler(s *config.Server, tm *services.TenantManager) []fiber.Rout
 // @Success 201 {object} models.Tenant
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security BearerAuth
 // @Router /tenants [post]
 func (h *TenantHandler) CreateTenant(c *fiber.Ctx) error {
 	tenantRequest := new(models.Tenant)
@@ -45,7 +79,7 @@ func (h *TenantHandler) CreateTenant(c *fiber.Ctx) error {
 		tenantRequest.MaxWorkers = null.IntFrom(3)
 	}
 
-	tenant, err := h.tenantManager.CreateTenant(c.Context(), tenantRequest.Name, tenantRequest.MaxWorkers.Int)
+	tenant, err := h.tenantControl.CreateTenant(c.Context(), tenantRequest.Name, tenantRequest.MaxWorkers.Int)
 	if err != nil {
 		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
 	}
@@ -53,19 +87,177 @@ func (h *TenantHandler) CreateTenant(c *fiber.Ctx) error {
 	return c.Status(http.StatusCreated).JSON(tenant)
 }
 
+// ListTenants lists tenants
+// @Summary List tenants
+// @Description List tenants, oldest first, using cursor-based pagination. Deleted tenants are only listed when filtered for the deleting or stopped status.
+// @Tags tenants
+// @Produce json
+// @Param status query string false "Comma-separated statuses: provisioning, active, suspended, deleting, stopped"
+// @Param cursor query string false "Cursor for pagination"
+// @Param limit query int false "Number of tenants to retrieve (max 100)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants [get]
+func (h *TenantHandler) ListTenants(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	filter := services.TenantFilter{
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+	}
+
+	if status := c.Query("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			switch s = strings.TrimSpace(s); s {
+			case services.TenantStatusProvisioning, services.TenantStatusActive, services.TenantStatusSuspended,
+				services.TenantStatusDeleting, services.TenantStatusStopped:
+				filter.Statuses = append(filter.Statuses, s)
+			default:
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"error": "Unknown status " + s,
+				})
+			}
+		}
+	}
+
+	if filter.Cursor != "" && uuid.Validate(filter.Cursor) != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
+
+	tenants, nextCursor, err := h.tenantControl.ListTenants(c.Context(), filter)
+	if errors.Is(err, services.ErrUnknownCursor) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, "Failed to fetch tenants"))
+	}
+
+	response := fiber.Map{
+		"data": tenants,
+	}
+
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+
+	return c.JSON(response)
+}
+
+// GetTenant returns a tenant with its runtime state
+// @Summary Get a tenant
+// @Description Get a tenant with its runtime state as its worker nodes last reported it: whether a consumer is running, the size of its worker pools, how many workers are busy, its queue depth and the worker nodes serving it.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id} [get]
+func (h *TenantHandler) GetTenant(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+	if uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Tenant not found",
+		})
+	}
+
+	tenant, err := h.tenantControl.GetTenant(c.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(tenant)
+}
+
+// UpdateTenant updates a tenant's name and limits
+// @Summary Update a tenant
+// @Description Rename a tenant or change its max_workers. Omitted fields are kept. Lowering max_workers below the current worker count shrinks the tenant's pools.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param tenant body models.UpdateTenantRequest true "Tenant changes"
+// @Success 200 {object} models.Tenant
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id} [patch]
+func (h *TenantHandler) UpdateTenant(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+	if uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Tenant not found",
+		})
+	}
+
+	req := new(models.UpdateTenantRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	if req.Name == nil && req.MaxWorkers == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "name or max_workers is required",
+		})
+	}
+
+	tenant, err := h.tenantControl.UpdateTenant(c.Context(), tenantID, services.TenantUpdate{
+		Name:       req.Name,
+		MaxWorkers: req.MaxWorkers,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTenantNotFound):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		case errors.Is(err, services.ErrInvalidTenant):
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(tenant)
+}
+
 // DeleteTenant deletes a tenant
 // @Summary Delete a tenant
-// @Description Delete a tenant and stop its consumer
+// @Description Delete a tenant. Workers stop its consumers and delete its queue, then mark it "stopped".
 // @Tags tenants
 // @Param id path string true "Tenant ID"
 // @Success 200 {object} string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security BearerAuth
 // @Router /tenants/{id} [delete]
 func (h *TenantHandler) DeleteTenant(c *fiber.Ctx) error {
 	tenantID := c.Params("id")
 
-	err := h.tenantManager.DeleteTenant(c.Context(), tenantID)
+	err := h.tenantControl.DeleteTenant(c.Context(), tenantID)
 	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
 		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
 	}
 
@@ -74,15 +266,17 @@ func (h *TenantHandler) DeleteTenant(c *fiber.Ctx) error {
 
 // UpdateTenantConfig updates tenant configuration
 // @Summary Update tenant concurrency configuration
-// @Description Update the worker count for a tenant
+// @Description Update the worker count for a tenant, between 1 and its max_workers. The change is recorded in the tenant's config history; workers shrinking their pools finish in-flight messages first.
 // @Tags tenants
 // @Accept json
 // @Produce json
 // @Param id path string true "Tenant ID"
 // @Param config body models.TenantConfigRequest true "Configuration"
-// @Success 200 {object} string
+// @Success 200 {object} services.ConcurrencyUpdate
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security BearerAuth
 // @Router /tenants/{id}/config/concurrency [put]
 func (h *TenantHandler) UpdateTenantConfig(c *fiber.Ctx) error {
 	tenantID := c.Params("id")
@@ -92,10 +286,387 @@ func (h *TenantHandler) UpdateTenantConfig(c *fiber.Ctx) error {
 		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
 	}
 
-	err := h.tenantManager.UpdateConcurrency(c.Context(), tenantID, config)
+	update, err := h.tenantControl.UpdateConcurrency(c.Context(), tenantID, config.Workers)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		if errors.Is(err, services.ErrInvalidConcurrency) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.Status(http.StatusOK).JSON(update)
+}
+
+// UpdateWebhookConfig sets the tenant's webhook endpoint
+// @Summary Update tenant webhook configuration
+// @Description Set the URL webhook messages are delivered to and the secret their HMAC signature is computed with. The URL must resolve to public addresses only. The secret must be at least 16 characters; it is stored encrypted and never returned.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body models.WebhookConfigRequest true "Webhook configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/config/webhook [put]
+func (h *TenantHandler) UpdateWebhookConfig(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+
+	req := new(models.WebhookConfigRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	endpoint, err := url.Parse(req.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "url must be an absolute http or https URL",
+		})
+	}
+	// Workers check again when they connect, in case the name is pointed
+	// elsewhere later
+	if err := h.addresses.CheckURL(c.Context(), req.URL); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "url must resolve to public addresses only",
+		})
+	}
+
+	if req.TimeoutMS < 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "timeout_ms must not be negative",
+		})
+	}
+
+	// The secret is never returned, so the caller has to choose it
+	if len(req.Secret) < 16 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "secret must be at least 16 characters",
+		})
+	}
+
+	webhook := processor.WebhookConfig{
+		URL:       req.URL,
+		Secret:    req.Secret,
+		TimeoutMS: req.TimeoutMS,
+	}
+
+	version, err := h.configStore.Save(c.Context(), tenantID, processor.WebhookConfigKey, webhook)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"url":        webhook.URL,
+		"timeout_ms": webhook.TimeoutMS,
+		"version":    version,
+	})
+}
+
+// UpdateSMTPConfig sets the SMTP server the tenant's email messages are sent through
+// @Summary Update tenant SMTP configuration
+// @Description Set the SMTP server, credentials and sender address used for email messages. The host must resolve to public addresses only. The password is stored but never returned.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body models.SMTPConfigRequest true "SMTP configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/config/smtp [put]
+func (h *TenantHandler) UpdateSMTPConfig(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+
+	req := new(models.SMTPConfigRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	if req.Host == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "host is required",
+		})
+	}
+	// Workers check again when they connect, in case the name is pointed
+	// elsewhere later
+	if err := h.addresses.CheckHost(c.Context(), req.Host); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "host must resolve to public addresses only",
+		})
+	}
+
+	// 0 picks the default port of the security mode
+	if req.Port < 0 || req.Port > 65535 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "port must be between 1 and 65535, or 0 for the default",
+		})
+	}
+
+	if _, err := mail.ParseAddress(req.From); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "from must be a valid email address",
+		})
+	}
+
+	switch req.Security {
+	case "", processor.SMTPSecurityStartTLS, processor.SMTPSecurityTLS, processor.SMTPSecurityNone:
+	default:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "security must be starttls, tls or none",
+		})
+	}
+
+	smtpConfig := processor.SMTPConfig{
+		Host:     req.Host,
+		Port:     req.Port,
+		Username: req.Username,
+		Password: req.Password,
+		From:     req.From,
+		Security: req.Security,
+	}
+
+	version, err := h.configStore.Save(c.Context(), tenantID, processor.SMTPConfigKey, smtpConfig)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"host":     smtpConfig.Host,
+		"port":     smtpConfig.Port,
+		"username": smtpConfig.Username,
+		"from":     smtpConfig.From,
+		"security": smtpConfig.Security,
+		"version":  version,
+	})
+}
+
+// UpdateSchedulingConfig sets the tenant's share of the workers' budget
+// @Summary Update tenant scheduling configuration
+// @Description Set the tenant's weight in the node-wide worker budget and the workers guaranteed to it on every node it runs on. Where the guarantees of a node's tenants exceed its budget they are scaled down in proportion. Workers apply it on their next sync.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body models.SchedulingConfigRequest true "Scheduling configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/config/scheduling [put]
+func (h *TenantHandler) UpdateSchedulingConfig(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+
+	req := new(models.SchedulingConfigRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	if req.Weight < 0 || req.MinWorkers < 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "weight and min_workers must not be negative",
+		})
+	}
+	if req.Weight == 0 {
+		req.Weight = 1
+	}
+
+	scheduling := services.SchedulingConfig{
+		Weight:     req.Weight,
+		MinWorkers: req.MinWorkers,
+	}
+
+	version, err := h.configStore.Save(c.Context(), tenantID, services.SchedulingConfigKey, scheduling)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"weight":      scheduling.Weight,
+		"min_workers": scheduling.MinWorkers,
+		"version":     version,
+	})
+}
+
+// UpdatePrefetchConfig overrides how many unacked messages the tenant's consumers hold
+// @Summary Update tenant prefetch configuration
+// @Description Set how many unacked messages each of the tenant's consumers holds. 0 removes the override; prefetch then follows the worker count. Workers apply it on their next sync.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body models.PrefetchConfigRequest true "Prefetch configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/config/prefetch [put]
+func (h *TenantHandler) UpdatePrefetchConfig(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+
+	req := new(models.PrefetchConfigRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	// RabbitMQ's prefetch count is 16 bits
+	if req.Prefetch < 0 || req.Prefetch > 65535 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "prefetch must be between 0 and 65535",
+		})
+	}
+
+	prefetch := services.PrefetchConfig{Prefetch: req.Prefetch}
+
+	version, err := h.configStore.Save(c.Context(), tenantID, services.PrefetchConfigKey, prefetch)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"prefetch": prefetch.Prefetch,
+		"version":  version,
+	})
+}
+
+// SuspendTenant stops processing a tenant's messages
+// @Summary Suspend a tenant
+// @Description Stop the tenant's consumers on every worker without deleting its queue. Messages published meanwhile are buffered or rejected according to the tenant's suspension config.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/suspend [post]
+func (h *TenantHandler) SuspendTenant(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+
+	if err := h.tenantControl.Suspend(c.Context(), tenantID); err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"id":     tenantID,
+		"status": services.TenantStatusSuspended,
+	})
+}
+
+// ResumeTenant resumes processing a suspended tenant's messages
+// @Summary Resume a tenant
+// @Description Hand a suspended tenant back to the workers. It is "provisioning" until a worker consumes its queue again, including messages buffered while suspended.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/resume [post]
+func (h *TenantHandler) ResumeTenant(c *fiber.Ctx) error {
+	tenantID := c.Params("id")
+
+	if err := h.tenantControl.Resume(c.Context(), tenantID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrTenantNotFound):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		case errors.Is(err, services.ErrTenantNotSuspended):
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "Tenant is not suspended",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"id":     tenantID,
+		"status": services.TenantStatusProvisioning,
+	})
+}
+
+// UpdateSuspensionConfig sets what happens to messages published while the tenant is suspended
+// @Summary Update tenant suspension configuration
+// @Description Choose whether messages published while the tenant is suspended are buffered in its queue (default) or rejected with 409.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param config body models.SuspensionConfigRequest true "Suspension configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/config/suspension [put]
+func (h *TenantHandler) UpdateSuspensionConfig(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+
+	req := new(models.SuspensionConfigRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	if req.Publish != services.SuspendedPublishBuffer && req.Publish != services.SuspendedPublishReject {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "publish must be buffer or reject",
+		})
+	}
+
+	suspension := services.SuspensionConfig{Publish: req.Publish}
+
+	version, err := h.configStore.Save(c.Context(), tenantID, services.SuspensionConfigKey, suspension)
 	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		}
 		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
 	}
 
-	return c.Status(http.StatusOK).JSON("tenant config updated")
+	return c.JSON(fiber.Map{
+		"publish": suspension.Publish,
+		"version": version,
+	})
 }
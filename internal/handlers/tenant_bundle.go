@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ImportPath is the route of tenant imports, whose bodies are streamed
+// rather than read whole.
+const ImportPath = "/v1/tenants/import"
+
+type TenantBundleHandler struct {
+	tenantBundler *services.TenantBundler
+	importMaxSize int64
+}
+
+func NewTenantBundleHandler(s *config.Server, tb *services.TenantBundler) []fiber.Router {
+	handler := TenantBundleHandler{
+		tenantBundler: tb,
+		importMaxSize: s.Config.Bundle.ImportMaxSize,
+	}
+	if handler.importMaxSize <= 0 {
+		handler.importMaxSize = 1 << 30
+	}
+
+	routes := newRouteGroups(s)
+
+	return []fiber.Router{
+		routes.Tenant("id", services.RoleAdmin).Get("/v1/tenants/:id/export", handler.ExportTenant),
+		routes.Platform().Post(ImportPath, handler.ImportTenant),
+	}
+}
+
+// ExportTenant streams a tenant's data as a bundle
+// @Summary Export a tenant
+// @Description Download a tar.gz bundle with the tenant row, its configs, messages, processing logs and dead letters as NDJSON, read from one consistent snapshot. Deleted tenants can be exported until they are purged. The webhook secret and SMTP password are left out unless include_secrets is set.
+// @Tags tenants
+// @Produce application/gzip
+// @Param id path string true "Tenant ID"
+// @Param include_secrets query bool false "Include the webhook secret and SMTP password"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{id}/export [get]
+func (h *TenantBundleHandler) ExportTenant(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+	if uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Tenant not found",
+		})
+	}
+
+	exists, err := h.tenantBundler.Exists(c.Context(), tenantID)
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+	if !exists {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Tenant not found",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Set(fiber.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="tenant_%s_v%d.tar.gz"`, tenantID, services.BundleVersion))
+
+	opts := services.ExportOptions{IncludeSecrets: c.QueryBool("include_secrets")}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The request context is recycled once the handler returns, so the
+		// export gets its own, canceled once the client stops reading
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := h.tenantBundler.Export(ctx, tenantID, &cancelingWriter{w: w, cancel: cancel}, opts); err != nil {
+			log.Printf("Failed to export tenant %s: %v", tenantID, err)
+			return
+		}
+		w.Flush()
+	})
+
+	return nil
+}
+
+// cancelingWriter cancels the export it is written by once a write fails.
+type cancelingWriter struct {
+	w      *bufio.Writer
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.cancel()
+	}
+	return n, err
+}
+
+// ImportTenant recreates a tenant from a bundle
+// @Summary Import a tenant
+// @Description Recreate a tenant from a bundle made by the export endpoint, under a new ID unless keep_id is set. Its messages partition and queue are created; messages that were not processed yet are published again. The body is streamed and may be up to BUNDLE_IMPORT_MAX_SIZE bytes.
+// @Tags tenants
+// @Accept application/gzip
+// @Produce json
+// @Param keep_id query bool false "Keep the tenant ID from the bundle"
+// @Param name query string false "Name for the imported tenant"
+// @Success 201 {object} models.Tenant
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/import [post]
+func (h *TenantBundleHandler) ImportTenant(c *fiber.Ctx) error {
+	opts := services.ImportOptions{
+		KeepID: c.QueryBool("keep_id"),
+		Name:   c.Query("name"),
+	}
+
+	if int64(c.Request().Header.ContentLength()) > h.importMaxSize {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Bundle too large",
+		})
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	limited := &limitedReader{r: body, remaining: h.importMaxSize}
+
+	tenant, err := h.tenantBundler.Import(c.Context(), limited, opts)
+	if err != nil {
+		switch {
+		case limited.exceeded:
+			return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Bundle too large",
+			})
+		case errors.Is(err, services.ErrInvalidBundle), errors.Is(err, services.ErrUnsupportedBundle):
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrTenantExists):
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "Tenant already exists",
+			})
+		}
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.Status(http.StatusCreated).JSON(tenant)
+}
+
+// limitedReader reads up to remaining bytes and fails, noting that it was
+// exceeded, if there is more.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only more data, not the end of it, exceeds the limit
+		n, err := l.r.Read(make([]byte, 1))
+		if n > 0 {
+			l.exceeded = true
+			return 0, errors.New("bundle exceeds the import size limit")
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
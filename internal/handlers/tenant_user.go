@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type TenantUserHandler struct {
+	userService *services.TenantUserService
+}
+
+func NewTenantUserHandler(s *config.Server, us *services.TenantUserService) []fiber.Router {
+	handler := TenantUserHandler{
+		userService: us,
+	}
+
+	// The platform admin creates a tenant's first admin, who manages the rest
+	tenantAdmin := newRouteGroups(s).Tenant("tenant_id", services.RoleAdmin)
+
+	return []fiber.Router{
+		tenantAdmin.Get("/v1/tenants/:tenant_id/users", handler.ListUsers),
+		tenantAdmin.Post("/v1/tenants/:tenant_id/users", handler.CreateUser),
+		tenantAdmin.Post("/v1/tenants/:tenant_id/users/:id/disable", handler.DisableUser),
+		tenantAdmin.Post("/v1/tenants/:tenant_id/users/:id/enable", handler.EnableUser),
+		tenantAdmin.Post("/v1/tenants/:tenant_id/users/:id/reset-password", handler.ResetPassword),
+	}
+}
+
+// ListUsers lists the users of a tenant
+// @Summary List tenant users
+// @Description List the users of a tenant with their roles, lockout and disabled state
+// @Tags users
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/users [get]
+func (h *TenantUserHandler) ListUsers(c *fiber.Ctx) error {
+	if uuid.Validate(middleware.TenantID(c)) != nil {
+		return c.JSON(fiber.Map{"data": []services.TenantUser{}})
+	}
+
+	users, err := h.userService.List(c.Context(), middleware.TenantID(c))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list users",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": users,
+	})
+}
+
+// CreateUser adds a user to a tenant
+// @Summary Create a tenant user
+// @Description Create a user that can log in to the tenant. Passwords must be 8 to 72 bytes and are stored as bcrypt hashes. Roles are admin and member; users without roles are members.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param user body models.CreateUserRequest true "User"
+// @Success 201 {object} services.TenantUser
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/users [post]
+func (h *TenantUserHandler) CreateUser(c *fiber.Ctx) error {
+	tenantID := middleware.TenantID(c)
+	if uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Tenant not found",
+		})
+	}
+
+	req := new(models.CreateUserRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	user, err := h.userService.Create(c.Context(), tenantID, req.Username, req.Password, req.Roles)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTenantNotFound):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Tenant not found",
+			})
+		case errors.Is(err, services.ErrInvalidUser):
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrUserExists):
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "Username already taken",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create user",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(user)
+}
+
+// DisableUser stops a user from logging in
+// @Summary Disable a tenant user
+// @Description Disable a user so it can no longer log in. Tokens issued before are revoked.
+// @Tags users
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "User ID"
+// @Success 200 {object} services.TenantUser
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/users/{id}/disable [post]
+func (h *TenantUserHandler) DisableUser(c *fiber.Ctx) error {
+	return h.setDisabled(c, true)
+}
+
+// EnableUser lets a disabled user log in again
+// @Summary Enable a tenant user
+// @Description Enable a disabled user again
+// @Tags users
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "User ID"
+// @Success 200 {object} services.TenantUser
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/users/{id}/enable [post]
+func (h *TenantUserHandler) EnableUser(c *fiber.Ctx) error {
+	return h.setDisabled(c, false)
+}
+
+func (h *TenantUserHandler) setDisabled(c *fiber.Ctx, disabled bool) error {
+	if uuid.Validate(middleware.TenantID(c)) != nil || uuid.Validate(c.Params("id")) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	user, err := h.userService.SetDisabled(c.Context(), middleware.TenantID(c), c.Params("id"), disabled)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update user",
+		})
+	}
+
+	return c.JSON(user)
+}
+
+// ResetPassword sets a new password for a user
+// @Summary Reset a tenant user's password
+// @Description Set a new password for a user and lift a lockout from repeated failed logins
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param id path string true "User ID"
+// @Param password body models.ResetPasswordRequest true "New password"
+// @Success 200 {object} services.TenantUser
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /tenants/{tenant_id}/users/{id}/reset-password [post]
+func (h *TenantUserHandler) ResetPassword(c *fiber.Ctx) error {
+	if uuid.Validate(middleware.TenantID(c)) != nil || uuid.Validate(c.Params("id")) != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	req := new(models.ResetPasswordRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.JSON(fiber.NewError(http.StatusBadRequest, err.Error()))
+	}
+
+	user, err := h.userService.ResetPassword(c.Context(), middleware.TenantID(c), c.Params("id"), req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidUser):
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrUserNotFound):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.JSON(user)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type WorkerHandler struct {
+	assignmentService *services.AssignmentService
+}
+
+func NewWorkerHandler(s *config.Server, as *services.AssignmentService) []fiber.Router {
+	handler := WorkerHandler{
+		assignmentService: as,
+	}
+
+	platform := newRouteGroups(s).Platform()
+
+	return []fiber.Router{
+		platform.Get("/v1/workers", handler.ListWorkers),
+		platform.Get("/v1/assignments", handler.ListAssignments),
+	}
+}
+
+// ListWorkers lists the worker nodes
+// @Summary List worker nodes
+// @Description List worker nodes with their last heartbeat and the number of tenant slots they own. Nodes that missed their lease TTL are reported as not alive.
+// @Tags workers
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /workers [get]
+func (h *WorkerHandler) ListWorkers(c *fiber.Ctx) error {
+	nodes, err := h.assignmentService.Nodes(c.Context())
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"data": nodes,
+	})
+}
+
+// ListAssignments lists which worker node serves which tenant
+// @Summary List tenant assignments
+// @Description List the worker node owning each tenant slot and when its lease expires. Expired slots are taken over by other nodes on their next rebalance.
+// @Tags workers
+// @Produce json
+// @Param tenant_id query string false "Only assignments of this tenant"
+// @Param node_id query string false "Only assignments of this worker node"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /assignments [get]
+func (h *WorkerHandler) ListAssignments(c *fiber.Ctx) error {
+	tenantID := c.Query("tenant_id")
+	if tenantID != "" && uuid.Validate(tenantID) != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "tenant_id must be a UUID",
+		})
+	}
+
+	assignments, err := h.assignmentService.List(c.Context(), tenantID, c.Query("node_id"))
+	if err != nil {
+		return c.JSON(fiber.NewError(http.StatusInternalServerError, err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"data": assignments,
+	})
+}
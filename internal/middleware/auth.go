@@ -1,15 +1,55 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/gofiber/fiber/v2"
 	jwtware "github.com/gofiber/jwt/v3"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func JWTProtected(secret string) fiber.Handler {
+// tenantKey is the Locals key TenantSpecific stores the request's tenant
+// under.
+const tenantKey = "tenant_id"
+
+// TokenChecker confirms that the tenant user a token was issued to may
+// still use it.
+type TokenChecker interface {
+	CheckToken(ctx context.Context, tenantID, userID string, tokenVersion int) error
+}
+
+// JWTProtected verifies the request's token. Tokens of tenant users are
+// also checked with users, so that disabling a user or resetting their
+// password revokes the tokens issued before.
+func JWTProtected(secret string, users TokenChecker) fiber.Handler {
 	return jwtware.New(jwtware.Config{
 		SigningKey:   []byte(secret),
 		ErrorHandler: jwtError,
+		SuccessHandler: func(c *fiber.Ctx) error {
+			userID, _ := claims(c)["user_id"].(string)
+			if userID == "" {
+				// The platform admin's token
+				return c.Next()
+			}
+
+			version, _ := claims(c)["token_version"].(float64)
+			err := users.CheckToken(c.UserContext(), ExtractTenantFromJWT(c), userID, int(version))
+			if errors.Is(err, services.ErrTokenRevoked) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Token has been revoked",
+				})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Could not verify token",
+				})
+			}
+
+			return c.Next()
+		},
 	})
 }
 
@@ -24,34 +64,107 @@ func jwtError(c *fiber.Ctx, err error) error {
 	})
 }
 
+// claims returns the claims of the token JWTProtected verified, or nil when
+// the request carries none.
+func claims(c *fiber.Ctx) jwt.MapClaims {
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims
+}
+
 func ExtractTenantFromJWT(c *fiber.Ctx) string {
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	
-	if tenantID, ok := claims["tenant_id"].(string); ok {
+	if tenantID, ok := claims(c)["tenant_id"].(string); ok {
 		return tenantID
 	}
-	
+
 	return ""
 }
 
-func TenantSpecific() fiber.Handler {
+// ExtractRolesFromJWT returns the roles the token grants.
+func ExtractRolesFromJWT(c *fiber.Ctx) []string {
+	values, _ := claims(c)["roles"].([]interface{})
+
+	roles := make([]string, 0, len(values))
+	for _, value := range values {
+		if role, ok := value.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// IsPlatformAdmin reports whether the token is the platform admin's.
+func IsPlatformAdmin(c *fiber.Ctx) bool {
+	return slices.Contains(ExtractRolesFromJWT(c), services.RolePlatformAdmin)
+}
+
+// PlatformAdmin only lets the platform admin through.
+func PlatformAdmin() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Extract tenant from JWT
+		if !IsPlatformAdmin(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied: platform admin only",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireRole lets through tokens holding any of roles. The platform admin
+// holds every role.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		granted := ExtractRolesFromJWT(c)
+		if !slices.Contains(granted, services.RolePlatformAdmin) &&
+			!slices.ContainsFunc(roles, func(role string) bool { return slices.Contains(granted, role) }) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied: insufficient role",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// TenantSpecific scopes a request to one tenant. Tenant users are scoped
+// to the tenant of their token, and a tenant in the URL parameter param
+// must be that tenant. The platform admin may act on any tenant and is
+// scoped to the one in the URL, if any. Handlers read the tenant with
+// TenantID.
+func TenantSpecific(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantIDFromURL := c.Params(param)
+
+		if IsPlatformAdmin(c) {
+			c.Locals(tenantKey, tenantIDFromURL)
+			return c.Next()
+		}
+
 		tenantIDFromJWT := ExtractTenantFromJWT(c)
-		
-		// Extract tenant from URL
-		tenantIDFromURL := c.Params("tenant_id")
-		
-		// If both exist, they must match
-		if tenantIDFromJWT != "" && tenantIDFromURL != "" {
-			if tenantIDFromJWT != tenantIDFromURL {
-				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-					"error": "Access denied: tenant mismatch",
-				})
-			}
+		if tenantIDFromJWT == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied: token is not scoped to a tenant",
+			})
 		}
-		
+		if tenantIDFromURL != "" && tenantIDFromURL != tenantIDFromJWT {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied: tenant mismatch",
+			})
+		}
+
+		c.Locals(tenantKey, tenantIDFromJWT)
 		return c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// TenantID returns the tenant TenantSpecific scoped the request to. It is
+// empty only for the platform admin on routes without a tenant in the URL.
+func TenantID(c *fiber.Ctx) string {
+	tenantID, _ := c.Locals(tenantKey).(string)
+	return tenantID
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"io"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit rejects request bodies larger than limit bytes with 413, except
+// on the paths in streamed. With StreamRequestBody on, fiber streams bodies
+// past its BodyLimit rather than rejecting them, and reading such a body
+// through c.Body() has no bound; this restores the bound for every route
+// that reads its body whole. Streamed routes enforce their own limit.
+func BodyLimit(limit int, streamed ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stream := c.Context().RequestBodyStream()
+		if stream == nil || slices.Contains(streamed, c.Path()) {
+			return c.Next()
+		}
+
+		if c.Request().Header.ContentLength() > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+			})
+		}
+
+		// Chunked bodies have no length up front
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read request body",
+			})
+		}
+		if len(body) > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+			})
+		}
+		c.Request().SetBodyRaw(body)
+
+		return c.Next()
+	}
+}
@@ -1337,3 +1337,8 @@ func DeadLetterMessageExists(ctx context.Context, exec boil.ContextExecutor, iD
 func (o *DeadLetterMessage) Exists(ctx context.Context, exec boil.ContextExecutor) (bool, error) {
 	return DeadLetterMessageExists(ctx, exec, o.ID)
 }
+
+type DeadLetterSelection struct {
+	IDs []string `json:"ids,omitempty"`
+	All bool     `json:"all,omitempty"`
+}
@@ -1220,3 +1220,46 @@ func (o *TenantConfig) Exists(ctx context.Context, exec boil.ContextExecutor) (b
 type TenantConfigRequest struct {
 	Workers int `json:"workers" binding:"required,min=1,max=50"`
 }
+
+type WebhookConfigRequest struct {
+	URL       string `json:"url" binding:"required"`
+	Secret    string `json:"secret" binding:"required"` // at least 16 characters
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+}
+
+type SMTPConfigRequest struct {
+	Host     string `json:"host" binding:"required"`
+	Port     int    `json:"port,omitempty"` // 587, or 465 with tls, when 0
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from" binding:"required"`
+	Security string `json:"security,omitempty"` // starttls (default), tls or none
+}
+
+type SchedulingConfigRequest struct {
+	Weight     int `json:"weight,omitempty"` // 1 when empty
+	MinWorkers int `json:"min_workers,omitempty"`
+}
+
+type PrefetchConfigRequest struct {
+	Prefetch int `json:"prefetch"` // 0 sizes prefetch from the worker count
+}
+
+type SuspensionConfigRequest struct {
+	Publish string `json:"publish" binding:"required"` // buffer or reject
+}
+
+type UpdateTenantRequest struct {
+	Name       *string `json:"name"`
+	MaxWorkers *int    `json:"max_workers"`
+}
+
+type CreateUserRequest struct {
+	Username string   `json:"username" binding:"required"`
+	Password string   `json:"password" binding:"required"`
+	Roles    []string `json:"roles"` // admin, member
+}
+
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
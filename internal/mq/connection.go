@@ -1,14 +1,36 @@
 package mq
 
 import (
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/gofiber/fiber/v2/log"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("rabbitmq connection is not available")
+
 type Client struct {
 	Conn *amqp.Connection
+
+	url         string
+	state       string
+	mutex       sync.RWMutex
+	onReconnect []func(conn *amqp.Connection)
 }
 
+// Dial connects to RabbitMQ and starts a supervisor that reconnects with
+// backoff whenever the connection drops. A deliberate Close stops it.
 func Dial(url string) (*Client, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
@@ -16,14 +38,112 @@ func Dial(url string) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{Conn: conn}, nil
+	c := &Client{Conn: conn, url: url, state: StateConnected}
+	go c.supervise(conn)
+
+	return c, nil
+}
+
+// OnReconnect registers a callback that runs after every successful
+// reconnection, in registration order.
+func (c *Client) OnReconnect(fn func(conn *amqp.Connection)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+func (c *Client) Connection() *amqp.Connection {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.Conn
+}
+
+func (c *Client) State() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.state
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	c.state = StateClosed
+	conn := c.Conn
+	c.mutex.Unlock()
+
+	return conn.Close()
 }
 
 func (c *Client) Channel() (*amqp.Channel, error) {
-	ch, err := c.Conn.Channel()
+	conn := c.Connection()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
 	if err != nil {
 		log.Errorf("Failed to initialize rabbitmq channel; error: %v", err)
 		return nil, err
 	}
 	return ch, nil
 }
+
+func (c *Client) supervise(conn *amqp.Connection) {
+	for {
+		closeErr, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+		if !ok || closeErr == nil || c.State() == StateClosed {
+			// Graceful shutdown, nothing to recover
+			c.mutex.Lock()
+			c.state = StateClosed
+			c.mutex.Unlock()
+			return
+		}
+
+		log.Warnf("rabbitmq connection lost; error: %v", closeErr)
+
+		conn = c.reconnect()
+		if conn == nil {
+			return
+		}
+
+		c.mutex.RLock()
+		callbacks := append([]func(conn *amqp.Connection){}, c.onReconnect...)
+		c.mutex.RUnlock()
+
+		for _, fn := range callbacks {
+			fn(conn)
+		}
+	}
+}
+
+func (c *Client) reconnect() *amqp.Connection {
+	c.mutex.Lock()
+	c.state = StateReconnecting
+	c.mutex.Unlock()
+
+	delay := minReconnectDelay
+	for {
+		time.Sleep(delay)
+
+		if c.State() == StateClosed {
+			return nil
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err != nil {
+			log.Errorf("rabbitmq reconnect failed, retrying in %s; error: %v", delay, err)
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+
+		c.mutex.Lock()
+		c.Conn = conn
+		c.state = StateConnected
+		c.mutex.Unlock()
+
+		log.Info("rabbitmq successfully reconnected")
+		return conn
+	}
+}
@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrPrivateAddress is returned for URLs of tenants and message data that
+// point into the network the workers run in.
+var ErrPrivateAddress = errors.New("address is not public")
+
+// isPublic reports whether addr may be reached on behalf of a tenant.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		// Shared address space of carrier-grade NAT, e.g. some clouds' VPCs
+		!netip.MustParsePrefix("100.64.0.0/10").Contains(addr)
+}
+
+// AddressPolicy decides which addresses may be reached on behalf of a
+// tenant: public addresses, plus the networks and hosts the platform
+// allowlists, e.g. webhook receivers inside its own network. A nil policy
+// allows public addresses only.
+type AddressPolicy struct {
+	prefixes []netip.Prefix
+	hosts    map[string]bool
+}
+
+// NewAddressPolicy builds a policy from an allowlist of CIDRs, IP addresses
+// and host names. A host name is trusted whatever it resolves to.
+func NewAddressPolicy(allowlist []string) (*AddressPolicy, error) {
+	p := &AddressPolicy{hosts: make(map[string]bool)}
+	for _, entry := range allowlist {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowlist entry %q: %w", entry, err)
+			}
+			p.prefixes = append(p.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			p.prefixes = append(p.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			p.hosts[normalizeHost(entry)] = true
+		}
+	}
+
+	return p, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// allowsAddr reports whether addr is public or allowlisted.
+func (p *AddressPolicy) allowsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if isPublic(addr) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost reports whether host is allowlisted by name.
+func (p *AddressPolicy) allowsHost(host string) bool {
+	return p != nil && p.hosts[normalizeHost(host)]
+}
+
+// control is a net.Dialer Control function refusing connections to
+// addresses the policy does not allow. It runs after the host is resolved,
+// so a name that resolves to a private address by the time of the request
+// is refused as well.
+func (p *AddressPolicy) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !p.allowsAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+
+	return nil
+}
+
+// dialContext connects to address unless the policy refuses it.
+func (p *AddressPolicy) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Control: p.control}
+	if p.allowsHost(host) {
+		dialer.Control = nil
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// newClient returns an HTTP client that only connects to addresses the
+// policy allows and ignores proxies, which would connect on its behalf.
+func (p *AddressPolicy) newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = p.dialContext
+
+	return &http.Client{Transport: transport}
+}
+
+// CheckURL fails with ErrPrivateAddress unless rawURL is an http or https
+// URL whose host the policy allows.
+func (p *AddressPolicy) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	return p.CheckHost(ctx, u.Hostname())
+}
+
+// CheckHost fails with ErrPrivateAddress unless host is allowlisted or
+// resolves to addresses the policy allows only.
+func (p *AddressPolicy) CheckHost(ctx context.Context, host string) error {
+	if p.allowsHost(host) {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !p.allowsAddr(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrPrivateAddress, host, addr)
+		}
+	}
+
+	return nil
+}
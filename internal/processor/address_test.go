@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:10.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublic(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestAddressPolicy(t *testing.T) {
+	policy, err := NewAddressPolicy([]string{"10.1.0.0/16", " 192.168.1.10 ", "Hooks.Internal.", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"10.2.0.1", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := policy.allowsAddr(netip.MustParseAddr(tt.addr)); got != tt.allowed {
+			t.Errorf("allowsAddr(%s) = %v, want %v", tt.addr, got, tt.allowed)
+		}
+	}
+
+	if !policy.allowsHost("hooks.internal") || policy.allowsHost("other.internal") {
+		t.Error("expected only the allowlisted host name to be allowed")
+	}
+	if err := policy.CheckHost(context.Background(), "hooks.internal"); err != nil {
+		t.Errorf("expected an allowlisted host to pass without resolving, got %v", err)
+	}
+	if err := policy.CheckURL(context.Background(), "https://10.1.2.3/hook"); err != nil {
+		t.Errorf("expected an allowlisted network to pass, got %v", err)
+	}
+	if err := policy.CheckURL(context.Background(), "https://10.2.0.1/hook"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("expected other private networks to be refused, got %v", err)
+	}
+
+	// Blocking stays the default
+	var none *AddressPolicy
+	if none.allowsAddr(netip.MustParseAddr("10.1.2.3")) || none.allowsHost("hooks.internal") {
+		t.Error("expected a nil policy to allow public addresses only")
+	}
+
+	if _, err := NewAddressPolicy([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid CIDR to be rejected")
+	}
+}
@@ -0,0 +1,399 @@
+package processor
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strconv"
+	"time"
+)
+
+// SMTPConfigKey is the tenant_configs key holding a tenant's SMTPConfig.
+const SMTPConfigKey = "smtp"
+
+const (
+	// SMTPSecurityStartTLS upgrades the connection with STARTTLS and fails
+	// when the server does not offer it. It is the default.
+	SMTPSecurityStartTLS = "starttls"
+	// SMTPSecurityTLS connects with TLS right away, usually on port 465
+	SMTPSecurityTLS = "tls"
+	// SMTPSecurityNone never encrypts, e.g. for a local MailHog
+	SMTPSecurityNone = "none"
+)
+
+// maxAttachmentSize bounds a single attachment fetched by reference.
+const maxAttachmentSize = 10 << 20
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// From is the sender address, optionally with a display name
+	From     string `json:"from"`
+	Security string `json:"security,omitempty"`
+}
+
+// Email is the data of an email message. Either body or html is required.
+type Email struct {
+	To          Recipients   `json:"to"`
+	Cc          Recipients   `json:"cc"`
+	Bcc         Recipients   `json:"bcc"`
+	ReplyTo     string       `json:"reply_to"`
+	Subject     string       `json:"subject"`
+	Body        string       `json:"body"`
+	HTML        string       `json:"html"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Attachment references a file the handler downloads when sending.
+type Attachment struct {
+	URL         string `json:"url"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+// Recipients accepts a single address or a list of addresses.
+type Recipients []string
+
+func (r *Recipients) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*r = Recipients{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("recipients must be an address or a list of addresses")
+	}
+	*r = list
+	return nil
+}
+
+// EmailHandler sends email messages through the tenant's SMTP server. SMTP
+// 4xx replies and connection problems are retried; 5xx replies and invalid
+// messages go to the dead letters.
+type EmailHandler struct {
+	configs  ConfigLoader
+	recorder AttemptRecorder
+	client   *http.Client
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	timeout  time.Duration
+}
+
+// NewEmailHandler creates a handler that gives up on an SMTP session, and on
+// each attachment download, after timeout. SMTP servers are only connected
+// to, and attachments only downloaded from, addresses the policy allows.
+// recorder and addresses may be nil.
+func NewEmailHandler(configs ConfigLoader, recorder AttemptRecorder, timeout time.Duration, addresses *AddressPolicy) *EmailHandler {
+	return &EmailHandler{
+		configs:  configs,
+		recorder: recorder,
+		client:   addresses.newClient(),
+		dial:     addresses.dialContext,
+		timeout:  timeout,
+	}
+}
+
+func (h *EmailHandler) Handle(ctx context.Context, msg *Message) error {
+	var config SMTPConfig
+	if err := h.configs.Load(ctx, msg.TenantID, SMTPConfigKey, &config); err != nil {
+		if errors.Is(err, ErrConfigNotFound) {
+			return Permanent(fmt.Errorf("smtp is not configured for tenant %s", msg.TenantID))
+		}
+		return fmt.Errorf("failed to load smtp config: %w", err)
+	}
+	if config.Host == "" || config.From == "" {
+		return Permanent(fmt.Errorf("smtp config of tenant %s needs a host and a from address", msg.TenantID))
+	}
+
+	email, err := decodeEmail(msg.Data)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid from address %q: %w", config.From, err))
+	}
+
+	recipients, err := email.recipients()
+	if err != nil {
+		return Permanent(err)
+	}
+
+	attachments := make([]attachmentContent, 0, len(email.Attachments))
+	for _, attachment := range email.Attachments {
+		content, err := h.fetchAttachment(ctx, attachment)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, content)
+	}
+
+	data, err := buildEmail(msg.ID, from, email, attachments, time.Now())
+	if err != nil {
+		return Permanent(err)
+	}
+
+	start := time.Now()
+	err = h.send(ctx, config, from.Address, recipients, data)
+	h.record(ctx, msg, smtpCode(err), time.Since(start), err)
+
+	return err
+}
+
+func decodeEmail(data map[string]any) (*Email, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	email := new(Email)
+	if err := json.Unmarshal(raw, email); err != nil {
+		return nil, fmt.Errorf("invalid email data: %w", err)
+	}
+	if len(email.To)+len(email.Cc)+len(email.Bcc) == 0 {
+		return nil, errors.New("email has no recipients")
+	}
+	if email.Body == "" && email.HTML == "" {
+		return nil, errors.New("email needs a body or html")
+	}
+
+	return email, nil
+}
+
+// recipients returns the envelope addresses of all To, Cc and Bcc entries.
+func (e *Email) recipients() ([]string, error) {
+	var addresses []string
+	for _, list := range []Recipients{e.To, e.Cc, e.Bcc} {
+		parsed, err := parseAddresses(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range parsed {
+			addresses = append(addresses, address.Address)
+		}
+	}
+
+	return addresses, nil
+}
+
+func parseAddresses(list Recipients) ([]*mail.Address, error) {
+	addresses := make([]*mail.Address, 0, len(list))
+	for _, entry := range list {
+		address, err := mail.ParseAddress(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, nil
+}
+
+type attachmentContent struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+func (h *EmailHandler) fetchAttachment(ctx context.Context, attachment Attachment) (attachmentContent, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return attachmentContent{}, Permanent(fmt.Errorf("invalid attachment url %q: %w", attachment.URL, err))
+	}
+
+	resp, err := h.client.Do(req)
+	if errors.Is(err, ErrPrivateAddress) {
+		return attachmentContent{}, Permanent(fmt.Errorf("refusing to download attachment %s: %w", attachment.URL, err))
+	}
+	if err != nil {
+		return attachmentContent{}, fmt.Errorf("failed to download attachment %s: %w", attachment.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("attachment %s responded with status %d", attachment.URL, resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return attachmentContent{}, err
+		}
+		return attachmentContent{}, Permanent(err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return attachmentContent{}, fmt.Errorf("failed to download attachment %s: %w", attachment.URL, err)
+	}
+	if len(data) > maxAttachmentSize {
+		return attachmentContent{}, Permanent(fmt.Errorf("attachment %s exceeds %d bytes", attachment.URL, maxAttachmentSize))
+	}
+
+	content := attachmentContent{
+		filename:    attachment.Filename,
+		contentType: attachment.ContentType,
+		data:        data,
+	}
+	if content.filename == "" {
+		content.filename = path.Base(req.URL.Path)
+	}
+	if content.contentType == "" {
+		content.contentType = resp.Header.Get("Content-Type")
+	}
+	if content.contentType == "" {
+		content.contentType = mime.TypeByExtension(path.Ext(content.filename))
+	}
+	if content.contentType == "" {
+		content.contentType = "application/octet-stream"
+	}
+
+	return content, nil
+}
+
+func (h *EmailHandler) send(ctx context.Context, config SMTPConfig, from string, recipients []string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	port := config.Port
+	if port == 0 {
+		port = 587
+		if config.Security == SMTPSecurityTLS {
+			port = 465
+		}
+	}
+
+	conn, err := h.dial(ctx, "tcp", net.JoinHostPort(config.Host, strconv.Itoa(port)))
+	if errors.Is(err, ErrPrivateAddress) {
+		return Permanent(fmt.Errorf("refusing to connect to smtp server %s: %w", config.Host, err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+	// net/smtp knows no contexts; closing the connection aborts the session
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if config.Security == SMTPSecurityTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: config.Host})
+	}
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return smtpError("failed to start smtp session", err)
+	}
+	defer client.Close()
+
+	if config.Security == "" || config.Security == SMTPSecurityStartTLS {
+		// Only security none may send in plain text
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return Permanent(errors.New("smtp server does not offer STARTTLS; set security to none to send unencrypted"))
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+			return smtpError("failed to start tls", err)
+		}
+	}
+
+	if config.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return Permanent(errors.New("smtp server does not support authentication"))
+		}
+		// PlainAuth refuses to send credentials over an unencrypted
+		// connection to anything but localhost, which retrying won't change
+		if _, encrypted := client.TLSConnectionState(); !encrypted && !isLocalhost(config.Host) {
+			return Permanent(errors.New("refusing to send smtp credentials over an unencrypted connection"))
+		}
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return smtpError("smtp authentication failed", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return smtpError("smtp server rejected sender", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return smtpError("smtp server rejected recipient "+recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("smtp server refused data", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return smtpError("failed to write message", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("smtp server rejected message", err)
+	}
+
+	// The message is accepted at this point; a failed QUIT must not resend it
+	client.Quit()
+
+	return nil
+}
+
+// isLocalhost reports whether smtp.PlainAuth accepts host without TLS.
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// smtpError retries 4xx replies and connection errors and dead-letters
+// messages rejected with a 5xx reply.
+func smtpError(action string, err error) error {
+	err = fmt.Errorf("%s: %w", action, err)
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return Permanent(err)
+	}
+	return err
+}
+
+// smtpCode returns the reply code of a failed session, 250 after success
+// and 0 when the server never replied.
+func smtpCode(err error) int {
+	if err == nil {
+		return 250
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
+
+func (h *EmailHandler) record(ctx context.Context, msg *Message, code int, latency time.Duration, err error) {
+	if h.recorder == nil {
+		return
+	}
+
+	attempt := Attempt{
+		Channel:        "email",
+		Status:         "delivered",
+		ResponseStatus: code,
+		Latency:        latency,
+	}
+	if err != nil {
+		attempt.Status = "failed"
+		attempt.Error = err.Error()
+	}
+
+	h.recorder.RecordAttempt(context.WithoutCancel(ctx), msg, attempt)
+}
@@ -0,0 +1,316 @@
+package processor
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal SMTP server answering RCPT with rcptReply.
+type fakeSMTP struct {
+	listener  net.Listener
+	rcptReply string
+
+	mutex      sync.Mutex
+	recipients []string
+	data       []byte
+}
+
+func newFakeSMTP(t *testing.T, rcptReply string) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSMTP{listener: listener, rcptReply: rcptReply}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	defer tc.Close()
+
+	tc.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250 fake")
+		case "MAIL":
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			if strings.HasPrefix(s.rcptReply, "250") {
+				s.mutex.Lock()
+				s.recipients = append(s.recipients, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+				s.mutex.Unlock()
+			}
+			tc.PrintfLine("%s", s.rcptReply)
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			data, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.data = data
+			s.mutex.Unlock()
+			tc.PrintfLine("250 queued")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) config() SMTPConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "Acme <noreply@acme.test>", Security: SMTPSecurityNone}
+}
+
+func TestEmailSendsMIMEMessage(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-invoice"))
+	}))
+	defer files.Close()
+
+	server := newFakeSMTP(t, "250 OK")
+	recorder := &attempts{}
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, recorder, time.Second, nil)
+	// The file and SMTP servers are on loopback, which the defaults refuse
+	handler.client = files.Client()
+	handler.dial = (&net.Dialer{}).DialContext
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to":          "user@example.com",
+		"bcc":         []any{"audit@example.com"},
+		"subject":     "Grüße",
+		"body":        "Hello World",
+		"html":        "<p>Hello World</p>",
+		"attachments": []any{map[string]any{"url": files.URL + "/files/invoice.pdf"}},
+	}})
+	if err != nil {
+		t.Fatalf("expected email to be sent, got %v", err)
+	}
+
+	if len(server.recipients) != 2 || server.recipients[1] != "audit@example.com" {
+		t.Fatalf("unexpected envelope recipients %v", server.recipients)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(string(server.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Header.Get("Bcc") != "" {
+		t.Fatal("expected bcc recipients to be left out of the headers")
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if subject != "Grüße" || message.Header.Get("Message-Id") != "<m1@acme.test>" {
+		t.Fatalf("unexpected headers %v", message.Header)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed, got %s", mediaType)
+	}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+
+	body, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(body.Header.Get("Content-Type")); mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative body, got %s", mediaType)
+	}
+
+	attachment, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := io.ReadAll(attachment)
+	decoded, _ := base64.StdEncoding.DecodeString(string(encoded))
+	if attachment.FileName() != "invoice.pdf" || attachment.Header.Get("Content-Type") != "application/pdf" || string(decoded) != "%PDF-invoice" {
+		t.Fatalf("unexpected attachment %v: %q", attachment.Header, decoded)
+	}
+
+	if len(recorder.list) != 1 || recorder.list[0].Status != "delivered" || recorder.list[0].ResponseStatus != 250 {
+		t.Fatalf("unexpected recorded attempts %+v", recorder.list)
+	}
+}
+
+func TestEmailSanitizesAttachmentContentType(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+	defer files.Close()
+
+	server := newFakeSMTP(t, "250 OK")
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, nil, time.Second, nil)
+	handler.client = files.Client()
+	handler.dial = (&net.Dialer{}).DialContext
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to":   "user@example.com",
+		"body": "Hello",
+		"attachments": []any{map[string]any{
+			"url":          files.URL + "/report",
+			"content_type": "text/plain\r\nBcc: victim@example.com\r\n\r\n--injected",
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("expected email to be sent, got %v", err)
+	}
+
+	if strings.Contains(string(server.data), "victim@example.com") || strings.Contains(string(server.data), "--injected") {
+		t.Fatalf("expected the content type not to inject headers, got\n%s", server.data)
+	}
+	if !strings.Contains(string(server.data), "Content-Type: application/octet-stream") {
+		t.Fatalf("expected an unparseable content type to fall back to application/octet-stream, got\n%s", server.data)
+	}
+}
+
+func TestEmailSMTPFailures(t *testing.T) {
+	tests := []struct {
+		reply     string
+		code      int
+		permanent bool
+	}{
+		{"450 mailbox busy", 450, false},
+		{"550 no such user", 550, true},
+	}
+
+	for _, tt := range tests {
+		server := newFakeSMTP(t, tt.reply)
+		recorder := &attempts{}
+		handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, recorder, time.Second, nil)
+		handler.dial = (&net.Dialer{}).DialContext
+
+		err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+			"to": "user@example.com", "subject": "Hi", "body": "Hello",
+		}})
+		if err == nil || IsPermanent(err) != tt.permanent {
+			t.Fatalf("%s: expected permanent=%v, got %v", tt.reply, tt.permanent, err)
+		}
+		if len(recorder.list) != 1 || recorder.list[0].Status != "failed" || recorder.list[0].ResponseStatus != tt.code {
+			t.Fatalf("%s: unexpected recorded attempts %+v", tt.reply, recorder.list)
+		}
+	}
+}
+
+func TestEmailInvalidMessagesArePermanent(t *testing.T) {
+	server := newFakeSMTP(t, "250 OK")
+	configs := staticConfigs{"t1/" + SMTPConfigKey: server.config()}
+	handler := NewEmailHandler(configs, nil, time.Second, nil)
+
+	messages := []*Message{
+		{ID: "m1", TenantID: "t2", Data: map[string]any{"to": "user@example.com", "body": "Hello"}},
+		{ID: "m2", TenantID: "t1", Data: map[string]any{"subject": "Hi", "body": "Hello"}},
+		{ID: "m3", TenantID: "t1", Data: map[string]any{"to": "not an address", "body": "Hello"}},
+		{ID: "m4", TenantID: "t1", Data: map[string]any{"to": "user@example.com"}},
+	}
+	for _, msg := range messages {
+		if err := handler.Handle(context.Background(), msg); !IsPermanent(err) {
+			t.Fatalf("message %s: expected permanent error, got %v", msg.ID, err)
+		}
+	}
+}
+
+func TestEmailRefusesPrivateAttachments(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer files.Close()
+
+	server := newFakeSMTP(t, "250 OK")
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, nil, time.Second, nil)
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to": "user@example.com", "body": "Hello",
+		"attachments": []any{map[string]any{"url": files.URL + "/secret"}},
+	}})
+	if !IsPermanent(err) || !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("expected a permanent private address error, got %v", err)
+	}
+}
+
+func TestEmailRefusesPrivateSMTPServers(t *testing.T) {
+	server := newFakeSMTP(t, "250 OK")
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, nil, time.Second, nil)
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to": "user@example.com", "body": "Hello",
+	}})
+	if !IsPermanent(err) || !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("expected a permanent private address error, got %v", err)
+	}
+	if len(server.recipients) != 0 {
+		t.Fatal("expected no session with a loopback smtp server")
+	}
+}
+
+func TestEmailReachesAllowlistedSMTPServers(t *testing.T) {
+	server := newFakeSMTP(t, "250 OK")
+	addresses, err := NewAddressPolicy([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: server.config()}, nil, time.Second, addresses)
+
+	err = handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to": "user@example.com", "body": "Hello",
+	}})
+	if err != nil {
+		t.Fatalf("expected the allowlisted smtp server to be used, got %v", err)
+	}
+	if len(server.recipients) != 1 {
+		t.Fatalf("expected one recipient, got %v", server.recipients)
+	}
+}
+
+func TestEmailRequiresOfferedSTARTTLS(t *testing.T) {
+	server := newFakeSMTP(t, "250 OK")
+	config := server.config()
+	config.Security = ""
+	handler := NewEmailHandler(staticConfigs{"t1/" + SMTPConfigKey: config}, nil, time.Second, nil)
+	handler.dial = (&net.Dialer{}).DialContext
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Data: map[string]any{
+		"to": "user@example.com", "body": "Hello",
+	}})
+	if !IsPermanent(err) {
+		t.Fatalf("expected a permanent error without STARTTLS, got %v", err)
+	}
+	if len(server.data) != 0 {
+		t.Fatal("expected nothing to be sent in plain text")
+	}
+}
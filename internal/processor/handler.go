@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnknownType = errors.New("no handler registered for message type")
+	ErrTimeout     = errors.New("handler timed out")
+)
+
+// Message is a decoded tenant message as handed to a Handler.
+type Message struct {
+	ID         string
+	TenantID   string
+	Type       string
+	Data       map[string]any
+	Payload    []byte
+	Headers    map[string]any
+	Priority   int
+	RetryCount int
+}
+
+// Handler processes messages of one type. A returned error makes the worker
+// retry the message, unless it is wrapped with Permanent.
+type Handler interface {
+	Handle(ctx context.Context, msg *Message) error
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Noop acknowledges messages without doing anything.
+var Noop = HandlerFunc(func(ctx context.Context, msg *Message) error {
+	return nil
+})
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying; the message goes to the dead
+// letters right away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
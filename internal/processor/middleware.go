@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a Handler with cross-cutting behaviour.
+type Middleware func(next Handler) Handler
+
+// Timeout cancels the handler context after d and fails the message with
+// ErrTimeout, which is retried. Handlers should honour ctx; one that does
+// not keeps running in the background after the worker moved on.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- next.Handle(ctx, msg)
+			}()
+
+			select {
+			case err := <-done:
+				// A handler that gave up because of the deadline timed out too
+				if err != nil && ctx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf("%w after %s: %v", ErrTimeout, d, err)
+				}
+				return err
+			case <-ctx.Done():
+				return fmt.Errorf("%w after %s", ErrTimeout, d)
+			}
+		})
+	}
+}
+
+// Recovery turns a panicking handler into a failed message. It has to sit
+// inside Timeout, which runs the handler on its own goroutine.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Handler for %q panicked on message %s: %v\n%s", msg.Type, msg.ID, r, debug.Stack())
+					err = fmt.Errorf("handler panicked: %v", r)
+				}
+			}()
+
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// Logging logs the outcome and duration of every handled message.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next.Handle(ctx, msg)
+
+			if err != nil {
+				log.Printf("Handled %q message %s of tenant %s in %s, err: %s",
+					msg.Type, msg.ID, msg.TenantID, time.Since(start), err.Error())
+			} else {
+				log.Printf("Handled %q message %s of tenant %s in %s",
+					msg.Type, msg.ID, msg.TenantID, time.Since(start))
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entity is a MIME part: its headers and a function writing its body.
+type entity struct {
+	header textproto.MIMEHeader
+	body   func(w io.Writer) error
+}
+
+// buildEmail renders email as an RFC 5322 message. Text and HTML bodies
+// become a multipart/alternative, which is wrapped in a multipart/mixed when
+// there are attachments. Bcc recipients are left out of the headers.
+func buildEmail(messageID string, from *mail.Address, email *Email, attachments []attachmentContent, now time.Time) ([]byte, error) {
+	to, err := parseAddresses(email.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := parseAddresses(email.Cc)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from.String())
+	if len(to) > 0 {
+		writeHeader(&buf, "To", joinAddresses(to))
+	}
+	if len(cc) > 0 {
+		writeHeader(&buf, "Cc", joinAddresses(cc))
+	}
+	if email.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(email.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply_to address %q: %w", email.ReplyTo, err)
+		}
+		writeHeader(&buf, "Reply-To", replyTo.String())
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", fmt.Sprintf("<%s@%s>", messageID, addressDomain(from.Address)))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	content := emailContent(email, attachments)
+	writeMIMEHeader(&buf, content.header)
+	buf.WriteString("\r\n")
+	if err := content.body(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func emailContent(email *Email, attachments []attachmentContent) entity {
+	var content entity
+	switch {
+	case email.Body != "" && email.HTML != "":
+		content = multipartEntity("alternative",
+			textEntity("text/plain", email.Body),
+			textEntity("text/html", email.HTML))
+	case email.HTML != "":
+		content = textEntity("text/html", email.HTML)
+	default:
+		content = textEntity("text/plain", email.Body)
+	}
+
+	if len(attachments) == 0 {
+		return content
+	}
+
+	parts := []entity{content}
+	for _, attachment := range attachments {
+		parts = append(parts, attachmentEntity(attachment))
+	}
+	return multipartEntity("mixed", parts...)
+}
+
+func textEntity(contentType, text string) entity {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "utf-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	return entity{
+		header: header,
+		body: func(w io.Writer) error {
+			qp := quotedprintable.NewWriter(w)
+			if _, err := io.WriteString(qp, text); err != nil {
+				return err
+			}
+			return qp.Close()
+		},
+	}
+}
+
+func attachmentEntity(attachment attachmentContent) entity {
+	// The type comes from the message or the file server; formatted from
+	// its parsed form it cannot add headers or boundaries
+	contentType := "application/octet-stream"
+	if mediaType, params, err := mime.ParseMediaType(attachment.contentType); err == nil {
+		if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+			contentType = formatted
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.filename}))
+
+	return entity{
+		header: header,
+		body: func(w io.Writer) error {
+			encoded := base64.StdEncoding.EncodeToString(attachment.data)
+			// RFC 2045 limits encoded lines to 76 characters
+			for len(encoded) > 76 {
+				if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+					return err
+				}
+				encoded = encoded[76:]
+			}
+			_, err := io.WriteString(w, encoded)
+			return err
+		},
+	}
+}
+
+func multipartEntity(subtype string, parts ...entity) entity {
+	boundary := randomBoundary()
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary}))
+
+	return entity{
+		header: header,
+		body: func(w io.Writer) error {
+			mw := multipart.NewWriter(w)
+			if err := mw.SetBoundary(boundary); err != nil {
+				return err
+			}
+			for _, part := range parts {
+				pw, err := mw.CreatePart(part.header)
+				if err != nil {
+					return err
+				}
+				if err := part.body(pw); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		},
+	}
+}
+
+func randomBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+func writeMIMEHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range header[key] {
+			writeHeader(buf, key, value)
+		}
+	}
+}
+
+func joinAddresses(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func addressDomain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
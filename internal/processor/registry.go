@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// UnknownTypePolicy decides what happens to messages without a handler.
+type UnknownTypePolicy string
+
+const (
+	// UnknownReject dead-letters the message without retrying
+	UnknownReject UnknownTypePolicy = "reject"
+	// UnknownFallback routes the message to the FallbackType handler and
+	// rejects it when there is none
+	UnknownFallback UnknownTypePolicy = "fallback"
+	// UnknownIgnore acknowledges the message without processing it
+	UnknownIgnore UnknownTypePolicy = "ignore"
+)
+
+// FallbackType is the message type to register a catch-all handler under.
+const FallbackType = "*"
+
+// Registry maps message types to handlers. Tenant overrides take precedence
+// over the global handler of the same type.
+type Registry struct {
+	handlers       map[string]Handler
+	tenantHandlers map[string]map[string]Handler
+	middleware     []Middleware
+	unknownPolicy  UnknownTypePolicy
+	mutex          sync.RWMutex
+}
+
+func NewRegistry(unknownPolicy UnknownTypePolicy) *Registry {
+	return &Registry{
+		handlers:       make(map[string]Handler),
+		tenantHandlers: make(map[string]map[string]Handler),
+		unknownPolicy:  unknownPolicy,
+	}
+}
+
+// Register sets the handler for msgType, replacing any previous one.
+func (r *Registry) Register(msgType string, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.handlers[msgType] = handler
+}
+
+// RegisterForTenant overrides the handler for msgType for one tenant only.
+func (r *Registry) RegisterForTenant(tenantID, msgType string, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.tenantHandlers[tenantID] == nil {
+		r.tenantHandlers[tenantID] = make(map[string]Handler)
+	}
+	r.tenantHandlers[tenantID][msgType] = handler
+}
+
+// RemoveTenant drops every override of the tenant.
+func (r *Registry) RemoveTenant(tenantID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.tenantHandlers, tenantID)
+}
+
+// Use appends middleware wrapped around every handler. The first middleware
+// added is the outermost.
+func (r *Registry) Use(middleware ...Middleware) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.middleware = append(r.middleware, middleware...)
+}
+
+func (r *Registry) lookup(tenantID, msgType string) (Handler, bool) {
+	if handler, ok := r.tenantHandlers[tenantID][msgType]; ok {
+		return handler, true
+	}
+
+	handler, ok := r.handlers[msgType]
+	return handler, ok
+}
+
+// Resolve returns the handler for a tenant's message type with all
+// middleware applied, following the unknown type policy when none is
+// registered. The returned error is permanent.
+func (r *Registry) Resolve(tenantID, msgType string) (Handler, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handler, ok := r.lookup(tenantID, msgType)
+	if !ok {
+		switch r.unknownPolicy {
+		case UnknownFallback:
+			handler, ok = r.lookup(tenantID, FallbackType)
+		case UnknownIgnore:
+			handler, ok = HandlerFunc(func(ctx context.Context, msg *Message) error {
+				log.Printf("Ignoring message %s of tenant %s with unknown type %q", msg.ID, msg.TenantID, msg.Type)
+				return nil
+			}), true
+		}
+	}
+
+	if !ok {
+		return nil, Permanent(fmt.Errorf("%w: %q", ErrUnknownType, msgType))
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+
+	return handler, nil
+}
+
+// Dispatch processes msg with the handler resolved for its tenant and type.
+func (r *Registry) Dispatch(ctx context.Context, msg *Message) error {
+	handler, err := r.Resolve(msg.TenantID, msg.Type)
+	if err != nil {
+		return err
+	}
+
+	return handler.Handle(ctx, msg)
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func named(name string, calls *[]string) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *Message) error {
+		*calls = append(*calls, name)
+		return nil
+	})
+}
+
+func TestRegistryTenantOverride(t *testing.T) {
+	var calls []string
+	registry := NewRegistry(UnknownReject)
+	registry.Register("email", named("global", &calls))
+	registry.RegisterForTenant("t1", "email", named("t1", &calls))
+
+	registry.Dispatch(context.Background(), &Message{TenantID: "t1", Type: "email"})
+	registry.Dispatch(context.Background(), &Message{TenantID: "t2", Type: "email"})
+
+	registry.RemoveTenant("t1")
+	registry.Dispatch(context.Background(), &Message{TenantID: "t1", Type: "email"})
+
+	want := []string{"t1", "global", "global"}
+	if len(calls) != len(want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("expected calls %v, got %v", want, calls)
+		}
+	}
+}
+
+func TestRegistryUnknownTypePolicies(t *testing.T) {
+	msg := &Message{TenantID: "t1", Type: "fax"}
+
+	err := NewRegistry(UnknownReject).Dispatch(context.Background(), msg)
+	if !errors.Is(err, ErrUnknownType) || !IsPermanent(err) {
+		t.Fatalf("reject: expected permanent ErrUnknownType, got %v", err)
+	}
+
+	var calls []string
+	fallback := NewRegistry(UnknownFallback)
+	if err := fallback.Dispatch(context.Background(), msg); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("fallback without handler: expected ErrUnknownType, got %v", err)
+	}
+	fallback.Register(FallbackType, named("fallback", &calls))
+	if err := fallback.Dispatch(context.Background(), msg); err != nil || len(calls) != 1 {
+		t.Fatalf("fallback: expected fallback handler to run, got err %v, calls %v", err, calls)
+	}
+
+	if err := NewRegistry(UnknownIgnore).Dispatch(context.Background(), msg); err != nil {
+		t.Fatalf("ignore: expected no error, got %v", err)
+	}
+}
+
+func TestRegistryMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, msg *Message) error {
+				calls = append(calls, name)
+				return next.Handle(ctx, msg)
+			})
+		}
+	}
+
+	registry := NewRegistry(UnknownReject)
+	registry.Use(trace("outer"), trace("inner"))
+	registry.Register("email", named("handler", &calls))
+
+	registry.Dispatch(context.Background(), &Message{Type: "email"})
+
+	if len(calls) != 3 || calls[0] != "outer" || calls[1] != "inner" || calls[2] != "handler" {
+		t.Fatalf("unexpected call order %v", calls)
+	}
+}
+
+func TestTimeoutAndRecovery(t *testing.T) {
+	registry := NewRegistry(UnknownReject)
+	registry.Use(Timeout(20*time.Millisecond), Recovery())
+	registry.Register("slow", HandlerFunc(func(ctx context.Context, msg *Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	registry.Register("panic", HandlerFunc(func(ctx context.Context, msg *Message) error {
+		panic("boom")
+	}))
+
+	err := registry.Dispatch(context.Background(), &Message{Type: "slow"})
+	if !errors.Is(err, ErrTimeout) || IsPermanent(err) {
+		t.Fatalf("expected retryable ErrTimeout, got %v", err)
+	}
+
+	err = registry.Dispatch(context.Background(), &Message{Type: "panic"})
+	if err == nil || IsPermanent(err) {
+		t.Fatalf("expected retryable error from panicking handler, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrConfigNotFound = errors.New("tenant config not found")
+
+// ConfigLoader reads the active tenant_configs value stored under key into v.
+// It returns ErrConfigNotFound when the tenant has no such entry.
+type ConfigLoader interface {
+	Load(ctx context.Context, tenantID, key string, v any) error
+}
+
+// Attempt is the outcome of one delivery attempt to an external system.
+type Attempt struct {
+	Channel        string // e.g. webhook
+	Status         string // delivered or failed
+	ResponseStatus int    // protocol status code, 0 when no response arrived
+	Latency        time.Duration
+	Error          string
+}
+
+// AttemptRecorder stores delivery attempts next to the message's processing
+// log.
+type AttemptRecorder interface {
+	RecordAttempt(ctx context.Context, msg *Message, attempt Attempt)
+}
@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookConfigKey is the tenant_configs key holding a tenant's WebhookConfig.
+const WebhookConfigKey = "webhook"
+
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	webhookSignaturePrefix = "v1="
+)
+
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// TimeoutMS overrides the handler's default request timeout
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+}
+
+// WebhookHandler POSTs the message payload to the tenant's webhook URL. Every
+// request is signed with the tenant secret over the timestamp and body, so a
+// receiver can reject forged requests and, by checking the timestamp and the
+// delivery id, replayed ones.
+type WebhookHandler struct {
+	configs  ConfigLoader
+	recorder AttemptRecorder
+	client   *http.Client
+	timeout  time.Duration
+}
+
+// NewWebhookHandler creates a handler that gives up on a request after
+// timeout unless the tenant configures its own. Requests only go to
+// addresses the policy allows. recorder and addresses may be nil.
+func NewWebhookHandler(configs ConfigLoader, recorder AttemptRecorder, timeout time.Duration, addresses *AddressPolicy) *WebhookHandler {
+	client := addresses.newClient()
+	// A redirect means the endpoint is misconfigured; following it would
+	// turn the POST into a GET
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &WebhookHandler{
+		configs:  configs,
+		recorder: recorder,
+		timeout:  timeout,
+		client:   client,
+	}
+}
+
+func (h *WebhookHandler) Handle(ctx context.Context, msg *Message) error {
+	var config WebhookConfig
+	if err := h.configs.Load(ctx, msg.TenantID, WebhookConfigKey, &config); err != nil {
+		if errors.Is(err, ErrConfigNotFound) {
+			return Permanent(fmt.Errorf("webhook is not configured for tenant %s", msg.TenantID))
+		}
+		return fmt.Errorf("failed to load webhook config: %w", err)
+	}
+	if config.URL == "" || config.Secret == "" {
+		return Permanent(fmt.Errorf("webhook config of tenant %s needs a url and a secret", msg.TenantID))
+	}
+
+	timeout := h.timeout
+	if config.TimeoutMS > 0 {
+		timeout = time.Duration(config.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid webhook url: %w", err))
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, msg.ID)
+	req.Header.Set(WebhookDeliveryHeader, uuid.NewString())
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(config.Secret, timestamp, msg.Payload))
+
+	start := time.Now()
+	resp, err := h.client.Do(req)
+	latency := time.Since(start)
+
+	if err != nil {
+		err = fmt.Errorf("webhook request failed: %w", err)
+		if errors.Is(err, ErrPrivateAddress) {
+			err = Permanent(err)
+		}
+		h.record(ctx, msg, 0, latency, err)
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	err = webhookStatusError(resp.StatusCode)
+	h.record(ctx, msg, resp.StatusCode, latency, err)
+	return err
+}
+
+// webhookStatusError maps the response code to the message outcome: success
+// for 2xx, a retry for timeouts, throttling and server errors, and the dead
+// letters for anything else, which retrying will not fix.
+func webhookStatusError(status int) error {
+	switch {
+	case status >= 200 && status < 300:
+		return nil
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests, status >= 500:
+		return fmt.Errorf("webhook responded with status %d", status)
+	default:
+		return Permanent(fmt.Errorf("webhook rejected the message with status %d", status))
+	}
+}
+
+func (h *WebhookHandler) record(ctx context.Context, msg *Message, status int, latency time.Duration, err error) {
+	if h.recorder == nil {
+		return
+	}
+
+	attempt := Attempt{
+		Channel:        "webhook",
+		Status:         "delivered",
+		ResponseStatus: status,
+		Latency:        latency,
+	}
+	if err != nil {
+		attempt.Status = "failed"
+		attempt.Error = err.Error()
+	}
+
+	// The request context may have expired already
+	h.recorder.RecordAttempt(context.WithoutCancel(ctx), msg, attempt)
+}
+
+// SignWebhook returns the signature header value for body sent at timestamp.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a received webhook's signature and rejects timestamps
+// further than tolerance from now. Receivers should also remember delivery
+// ids seen within the tolerance window to drop exact replays.
+func VerifyWebhook(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid webhook timestamp")
+	}
+
+	if age := now.Sub(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return errors.New("webhook timestamp outside tolerance")
+	}
+
+	if !hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("invalid webhook signature")
+	}
+
+	return nil
+}
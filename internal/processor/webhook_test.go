@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type staticConfigs map[string]any
+
+func (c staticConfigs) Load(ctx context.Context, tenantID, key string, v any) error {
+	value, ok := c[tenantID+"/"+key]
+	if !ok {
+		return ErrConfigNotFound
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+type attempts struct {
+	mutex sync.Mutex
+	list  []Attempt
+}
+
+func (a *attempts) RecordAttempt(ctx context.Context, msg *Message, attempt Attempt) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.list = append(a.list, attempt)
+}
+
+func TestWebhookSignsRequest(t *testing.T) {
+	payload := []byte(`{"type":"webhook","data":{"order":1}}`)
+
+	var received http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	recorder := &attempts{}
+	handler := NewWebhookHandler(staticConfigs{
+		"t1/" + WebhookConfigKey: WebhookConfig{URL: server.URL, Secret: "s3cret"},
+	}, recorder, time.Second, nil)
+	// The server is on loopback, which the default transport refuses
+	handler.client.Transport = server.Client().Transport
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1", Payload: payload})
+	if err != nil {
+		t.Fatalf("expected delivery, got %v", err)
+	}
+
+	if received.Get(WebhookIDHeader) != "m1" || received.Get(WebhookDeliveryHeader) == "" {
+		t.Fatalf("missing webhook headers: %v", received)
+	}
+	err = VerifyWebhook("s3cret", received.Get(WebhookTimestampHeader), received.Get(WebhookSignatureHeader),
+		body, time.Minute, time.Now())
+	if err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if VerifyWebhook("other", received.Get(WebhookTimestampHeader), received.Get(WebhookSignatureHeader),
+		body, time.Minute, time.Now()) == nil {
+		t.Fatal("expected signature with wrong secret to be rejected")
+	}
+	if VerifyWebhook("s3cret", received.Get(WebhookTimestampHeader), received.Get(WebhookSignatureHeader),
+		body, time.Minute, time.Now().Add(time.Hour)) == nil {
+		t.Fatal("expected stale timestamp to be rejected")
+	}
+
+	if len(recorder.list) != 1 || recorder.list[0].Status != "delivered" || recorder.list[0].ResponseStatus != http.StatusNoContent {
+		t.Fatalf("unexpected recorded attempts %+v", recorder.list)
+	}
+}
+
+func TestWebhookStatusMapping(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusBadRequest, true},
+		{http.StatusGone, true},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+
+		recorder := &attempts{}
+		handler := NewWebhookHandler(staticConfigs{
+			"t1/" + WebhookConfigKey: WebhookConfig{URL: server.URL, Secret: "s3cret"},
+		}, recorder, time.Second, nil)
+		handler.client.Transport = server.Client().Transport
+
+		err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1"})
+		server.Close()
+
+		if err == nil || IsPermanent(err) != tt.permanent {
+			t.Fatalf("status %d: expected permanent=%v, got %v", tt.status, tt.permanent, err)
+		}
+		if len(recorder.list) != 1 || recorder.list[0].Status != "failed" || recorder.list[0].ResponseStatus != tt.status {
+			t.Fatalf("status %d: unexpected recorded attempts %+v", tt.status, recorder.list)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	handler := NewWebhookHandler(staticConfigs{
+		"t1/" + WebhookConfigKey: WebhookConfig{URL: server.URL, Secret: "s3cret"},
+	}, nil, time.Second, nil)
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1"})
+	if !IsPermanent(err) || !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("expected a permanent private address error, got %v", err)
+	}
+	if called {
+		t.Fatal("expected the loopback server not to be called")
+	}
+}
+
+func TestWebhookReachesAllowlistedPrivateAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	addresses, err := NewAddressPolicy([]string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewWebhookHandler(staticConfigs{
+		"t1/" + WebhookConfigKey: WebhookConfig{URL: server.URL, Secret: "s3cret"},
+	}, nil, time.Second, addresses)
+
+	if err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1"}); err != nil {
+		t.Fatalf("expected delivery to the allowlisted receiver, got %v", err)
+	}
+	if !called {
+		t.Fatal("expected the allowlisted server to be called")
+	}
+}
+
+func TestAddressPolicyCheckURL(t *testing.T) {
+	// The policy of an empty allowlist, the default
+	addresses, err := NewAddressPolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rawURL := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "https://10.0.0.5/hook", "ftp://example.com"} {
+		if err := addresses.CheckURL(context.Background(), rawURL); err == nil {
+			t.Errorf("expected %s to be refused", rawURL)
+		}
+	}
+	if err := addresses.CheckURL(context.Background(), "https://93.184.216.34/hook"); err != nil {
+		t.Errorf("expected a public address to pass, got %v", err)
+	}
+}
+
+func TestWebhookMissingConfigIsPermanent(t *testing.T) {
+	handler := NewWebhookHandler(staticConfigs{}, nil, time.Second, nil)
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1"})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestWebhookTenantTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	recorder := &attempts{}
+	handler := NewWebhookHandler(staticConfigs{
+		"t1/" + WebhookConfigKey: WebhookConfig{URL: server.URL, Secret: "s3cret", TimeoutMS: 20},
+	}, recorder, time.Minute, nil)
+	handler.client.Transport = server.Client().Transport
+
+	err := handler.Handle(context.Background(), &Message{ID: "m1", TenantID: "t1"})
+	if err == nil || IsPermanent(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected retryable timeout, got %v", err)
+	}
+	if len(recorder.list) != 1 || recorder.list[0].ResponseStatus != 0 || recorder.list[0].Latency <= 0 {
+		t.Fatalf("unexpected recorded attempts %+v", recorder.list)
+	}
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// NewRegistry builds the message handler registry used by tenant workers.
+func NewRegistry(s *config.Server) *processor.Registry {
+	policy := processor.UnknownTypePolicy(s.Config.Processing.UnknownTypePolicy)
+	switch policy {
+	case processor.UnknownReject, processor.UnknownFallback, processor.UnknownIgnore:
+	default:
+		log.Warnf("unknown processing.unknown_type_policy %q, using %q", policy, processor.UnknownReject)
+		policy = processor.UnknownReject
+	}
+
+	registry := processor.NewRegistry(policy)
+	registry.Use(
+		processor.Logging(),
+		processor.Timeout(s.Config.Processing.HandlerTimeout),
+		processor.Recovery(),
+	)
+
+	// Blocking private addresses stays the default when the allowlist is invalid
+	addresses, err := processor.NewAddressPolicy(s.Config.Processing.PrivateAllowlist)
+	if err != nil {
+		log.Warnf("ignoring processing.private_allowlist: %v", err)
+	}
+
+	configs := services.NewTenantConfigStore(s.DB, s.Config.AppSecret)
+	processingLog := services.NewProcessingLog(s.DB)
+
+	registry.Register("webhook", processor.NewWebhookHandler(configs, processingLog, s.Config.Processing.WebhookTimeout, addresses))
+	registry.Register("email", processor.NewEmailHandler(configs, processingLog, s.Config.Processing.EmailTimeout, addresses))
+
+	// Placeholder until notifications get real delivery
+	registry.Register("notification", processor.Noop)
+
+	return registry
+}
@@ -5,14 +5,24 @@ import (
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/handlers"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 )
 
-func AttachAllRoutes(s *config.Server, tm *services.TenantManager, ms *services.MessageService) {
+// AttachAllRoutes registers every API route. Each handler puts its routes
+// in one of three groups: public ones, tenant ones scoped to the tenant of
+// the caller's token, and platform ones reserved for the platform admin.
+func AttachAllRoutes(s *config.Server, mqClient *mq.Client, tc *services.TenantControl, ms *services.MessageService, dls *services.DeadLetterService, configs *services.TenantConfigStore, as *services.AssignmentService, tp *services.TenantPurger, tb *services.TenantBundler, us *services.TenantUserService, pa *services.PlatformAdmin) {
 	s.Router.Routes = slices.Concat(
 		s.Router.Routes,
-		handlers.NewAuthHandler(s, s.Config.AppSecret),
-		handlers.NewTenantHandler(s, tm),
+		handlers.NewHealthHandler(s, mqClient),
+		handlers.NewAuthHandler(s, s.Config.AppSecret, us, pa),
+		handlers.NewTenantHandler(s, tc, configs),
 		handlers.NewMessageHandler(s, ms),
+		handlers.NewDeadLetterHandler(s, dls),
+		handlers.NewWorkerHandler(s, as),
+		handlers.NewPurgeHandler(s, tp),
+		handlers.NewTenantBundleHandler(s, tb),
+		handlers.NewTenantUserHandler(s, us),
 	)
 }
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/config"
-	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/mq"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/handlers"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/middleware"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/router"
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/services"
 	"github.com/gofiber/adaptor/v2"
@@ -29,27 +31,61 @@ func Init() {
 		log.Fatalf("Failed to initialize database; error: %v", err)
 	}
 
-	// Init RabbitMQ
-	mqUrl := conf.RabbitMQ.ConnectionString()
-	mqClient, err := mq.Dial(mqUrl)
+	// Init queue backend
+	broker, mqClient, err := NewBroker(s)
 	if err != nil {
-		log.Fatalf("Failed to initialize rabbitmq; error: %v", err)
+		log.Fatalf("Failed to initialize queue backend; error: %v", err)
 	}
-	s.RabbitMQ = mqClient.Conn
-	mqChannel, err := mqClient.Channel()
+
+	// Workers run tenant consumers; the API only announces changes to them
+	var publisher services.ControlPublisher
+	if mqClient != nil {
+		publisher = control.NewPublisher(mqClient)
+	}
+	configStore := services.NewTenantConfigStore(s.DB, s.Config.AppSecret)
+	tenantControl := services.NewTenantControl(s.DB, broker, configStore, publisher)
+
+	outboxRelay := services.NewOutboxRelay(s.DB, broker)
+	messageServices := services.NewMessageService(s.DB, outboxRelay)
+	deadLetterServices := services.NewDeadLetterService(s.DB, outboxRelay)
+	tenantBundler := services.NewTenantBundler(s.DB, tenantControl, outboxRelay)
+	lockout := services.TenantUserConfig{
+		MaxFailedLogins: s.Config.Auth.MaxFailedLogins,
+		LockoutDuration: s.Config.Auth.LockoutDuration,
+	}
+	tenantUsers := services.NewTenantUserService(s.DB, lockout)
+	platformAdmin, err := services.NewPlatformAdmin(s.Config.Auth.AdminUsername, s.Config.Auth.AdminPasswordHash, lockout)
 	if err != nil {
-		log.Fatalf("Failed to initialize rabbitmq; error: %v", err)
+		log.Fatalf("Failed to initialize platform admin; error: %v", err)
+	}
+	if s.Config.Auth.AdminPasswordHash == "" {
+		log.Warn("AUTH_ADMIN_PASSWORD_HASH is not set; nobody can log in as platform admin")
 	}
 
-	rabbitmqService := services.NewRabbitMQService(mqClient.Conn, mqChannel)
-	tenantManager := services.NewTenantManager(s.DB, rabbitmqService)
-	messageServices := services.NewMessageService(s.DB, rabbitmqService)
+	scheduler := services.NewScheduler(s.DB, outboxRelay)
+	tenantPurger := services.NewTenantPurger(s.DB, services.PurgeConfig{
+		GracePeriod: s.Config.Purge.GracePeriod,
+		Interval:    s.Config.Purge.Interval,
+		ArchiveDir:  s.Config.Purge.ArchiveDir,
+	})
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	outboxRelay.Start(relayCtx)
+	scheduler.Start(relayCtx)
+	if s.Config.Purge.Enabled {
+		tenantPurger.Start(relayCtx)
+	}
 
+	// Bodies are streamed so tenant imports are not held to BodyLimit;
+	// every other route still is
 	s.Fiber = fiber.New(fiber.Config{
-		Immutable: true,
+		Immutable:         true,
+		StreamRequestBody: true,
 	})
 
 	s.Fiber.Use(logger.New())
+	s.Fiber.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, handlers.ImportPath))
 
 	s.Router = &config.Router{
 		Routes: []fiber.Router{
@@ -59,7 +95,9 @@ func Init() {
 		},
 	}
 
-	router.AttachAllRoutes(s, tenantManager, messageServices)
+	assignmentServices := services.NewAssignmentService(s.DB, s.Config.Worker.LeaseTTL)
+
+	router.AttachAllRoutes(s, mqClient, tenantControl, messageServices, deadLetterServices, configStore, assignmentServices, tenantPurger, tenantBundler, tenantUsers, platformAdmin)
 
 	// Swagger documentation
 	s.Fiber.Get("/swagger/*", fiberSwagger.WrapHandler)
@@ -84,8 +122,7 @@ func Init() {
 
 	// Setup graceful shutdown
 	shutdownManager := &services.ShutdownManager{
-		TenantManager: tenantManager,
-		Server:        s.Fiber,
+		Server: s.Fiber,
 	}
 	// Start graceful shutdown handler
 	shutdownManager.GracefulShutdown()
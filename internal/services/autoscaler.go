@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AutoscalerConfig tunes the Autoscaler. Replicas returns the number of
+// nodes a tenant is currently spread across; each node scales its share of
+// max_workers.
+type AutoscalerConfig struct {
+	Interval             time.Duration
+	MinWorkers           int
+	ScaleUpBacklog       int
+	ScaleDownUtilization float64
+	ScaleDownSamples     int
+	ScaleUpCooldown      time.Duration
+	ScaleDownCooldown    time.Duration
+	Replicas             func() int
+}
+
+// scaleState is what the autoscaler remembers about a tenant between
+// samples.
+type scaleState struct {
+	lastScaled  time.Time
+	idleSamples int
+}
+
+// scaleSample is one observation of a tenant's pool in this process.
+type scaleSample struct {
+	depth      int
+	busy       int
+	workers    int
+	maxWorkers int
+}
+
+// Autoscaler grows a tenant's worker pool while ready messages pile up and
+// shrinks it after the pool stayed mostly idle for several samples. The
+// gap between the two thresholds and the cooldowns keep it from flapping.
+type Autoscaler struct {
+	db         *sql.DB
+	broker     Broker
+	tm         *TenantManager
+	monitoring *MonitoringService
+	config     AutoscalerConfig
+	states     map[string]*scaleState
+}
+
+func NewAutoscaler(db *sql.DB, broker Broker, tm *TenantManager, monitoring *MonitoringService, config AutoscalerConfig) *Autoscaler {
+	config.MinWorkers = max(config.MinWorkers, 1)
+	config.ScaleUpBacklog = max(config.ScaleUpBacklog, 1)
+	if config.Replicas == nil {
+		config.Replicas = func() int { return 1 }
+	}
+
+	return &Autoscaler{
+		db:         db,
+		broker:     broker,
+		tm:         tm,
+		monitoring: monitoring,
+		config:     config,
+		states:     make(map[string]*scaleState),
+	}
+}
+
+// Run samples every tenant consumed in this process each interval until
+// ctx is done.
+func (a *Autoscaler) Run(ctx context.Context) {
+	inspector, ok := a.broker.(QueueInspector)
+	if !ok {
+		log.Println("Autoscaler disabled: the queue backend cannot report queue depth")
+		return
+	}
+
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.tick(ctx, inspector, time.Now()); err != nil {
+				log.Printf("Autoscaler failed to sample tenants: %v", err)
+			}
+		}
+	}
+}
+
+func (a *Autoscaler) tick(ctx context.Context, inspector QueueInspector, now time.Time) error {
+	tenantIDs := a.tm.Tenants()
+
+	// Forget tenants that stopped or moved to another node
+	running := make(map[string]bool, len(tenantIDs))
+	for _, tenantID := range tenantIDs {
+		running[tenantID] = true
+	}
+	for tenantID := range a.states {
+		if !running[tenantID] {
+			delete(a.states, tenantID)
+			a.monitoring.ForgetTenant(tenantID)
+		}
+	}
+
+	if len(tenantIDs) == 0 {
+		return nil
+	}
+
+	limits, err := a.maxWorkers(ctx, tenantIDs)
+	if err != nil {
+		return err
+	}
+
+	// Tenants settle side by side, so that one with slow messages holds up
+	// no other tenant's scaling
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, tenantID := range tenantIDs {
+		maxWorkers, ok := limits[tenantID]
+		if !ok {
+			continue
+		}
+
+		depth, err := inspector.MessageCount(tenantID)
+		if err != nil {
+			log.Printf("Autoscaler failed to read queue depth of tenant %s: %v", tenantID, err)
+			continue
+		}
+		busy, workers, ok := a.tm.Utilization(tenantID)
+		if !ok {
+			continue
+		}
+
+		a.monitoring.UpdateQueueDepth(tenantID, float64(depth))
+		a.monitoring.UpdateWorkerActivity(tenantID, float64(busy))
+		a.monitoring.UpdateWorkerPoolSize(tenantID, float64(workers))
+
+		state, ok := a.states[tenantID]
+		if !ok {
+			state = &scaleState{}
+			a.states[tenantID] = state
+		}
+
+		sample := scaleSample{depth: depth, busy: busy, workers: workers, maxWorkers: maxWorkers}
+		target, reason := a.decide(state, sample, now)
+		if target == workers {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.resize(ctx, tenantID, state, workers, target, reason, now)
+		}()
+	}
+
+	return nil
+}
+
+// resize applies a scaling decision. Shrinking waits for busy workers, but
+// not past the next sample.
+func (a *Autoscaler) resize(ctx context.Context, tenantID string, state *scaleState, workers, target int, reason string, now time.Time) {
+	ctxTimeout, cancel := context.WithTimeout(ctx, a.config.Interval)
+	err := a.tm.UpdateConcurrency(ctxTimeout, tenantID, target)
+	cancel()
+	if err != nil {
+		log.Printf("Autoscaler failed to resize tenant %s: %v", tenantID, err)
+		return
+	}
+	state.lastScaled = now
+	state.idleSamples = 0
+
+	direction := "up"
+	if target < workers {
+		direction = "down"
+	}
+	a.monitoring.RecordScalingDecision(tenantID, direction)
+	a.monitoring.UpdateWorkerPoolSize(tenantID, float64(target))
+	log.Printf("Autoscaler scaled tenant %s %s from %d to %d workers: %s",
+		tenantID, direction, workers, target, reason)
+}
+
+// decide returns the worker count the tenant should run with and why. It
+// returns the current count when nothing should change.
+func (a *Autoscaler) decide(state *scaleState, sample scaleSample, now time.Time) (int, string) {
+	minWorkers := min(a.config.MinWorkers, sample.maxWorkers)
+	workers := sample.workers
+
+	switch {
+	case workers > sample.maxWorkers:
+		return sample.maxWorkers, "above max_workers"
+	case workers < minWorkers:
+		return minWorkers, "below the minimum"
+	}
+
+	backlogged := sample.depth > workers*a.config.ScaleUpBacklog
+	saturated := sample.busy >= workers && sample.depth > 0
+	if backlogged || saturated {
+		state.idleSamples = 0
+		if workers >= sample.maxWorkers || now.Sub(state.lastScaled) < a.config.ScaleUpCooldown {
+			return workers, ""
+		}
+
+		wanted := (sample.depth + a.config.ScaleUpBacklog - 1) / a.config.ScaleUpBacklog
+		target := min(sample.maxWorkers, max(workers+1, wanted))
+		return target, fmt.Sprintf("%d ready messages, %d of %d workers busy", sample.depth, sample.busy, workers)
+	}
+
+	utilization := float64(sample.busy) / float64(workers)
+	if utilization > a.config.ScaleDownUtilization || sample.depth >= workers {
+		state.idleSamples = 0
+		return workers, ""
+	}
+
+	state.idleSamples++
+	if state.idleSamples < a.config.ScaleDownSamples || workers <= minWorkers ||
+		now.Sub(state.lastScaled) < a.config.ScaleDownCooldown {
+		return workers, ""
+	}
+
+	return workers - 1, fmt.Sprintf("utilization at or below %.0f%% for %d samples",
+		a.config.ScaleDownUtilization*100, state.idleSamples)
+}
+
+// maxWorkers returns this node's share of max_workers for each tenant.
+func (a *Autoscaler) maxWorkers(ctx context.Context, tenantIDs []string) (map[string]int, error) {
+	rows, err := a.db.QueryContext(ctx, `
+        SELECT id, COALESCE(max_workers, 3) FROM tenants
+        WHERE id = ANY($1) AND deleted_at IS NULL
+    `, pq.Array(tenantIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := make(map[string]int, len(tenantIDs))
+	for rows.Next() {
+		var tenantID string
+		var maxWorkers int
+		if err := rows.Scan(&tenantID, &maxWorkers); err != nil {
+			return nil, err
+		}
+		limits[tenantID] = max(maxWorkers/a.config.Replicas(), 1)
+	}
+
+	return limits, rows.Err()
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoscalerDecide(t *testing.T) {
+	a := NewAutoscaler(nil, nil, nil, nil, AutoscalerConfig{
+		MinWorkers:           1,
+		ScaleUpBacklog:       10,
+		ScaleDownUtilization: 0.25,
+		ScaleDownSamples:     3,
+		ScaleUpCooldown:      time.Minute,
+		ScaleDownCooldown:    5 * time.Minute,
+	})
+	state := &scaleState{}
+	now := time.Now()
+
+	// A backlog grows the pool towards one worker per 10 messages
+	target, _ := a.decide(state, scaleSample{depth: 45, busy: 2, workers: 2, maxWorkers: 8}, now)
+	if target != 5 {
+		t.Fatalf("expected scale up to 5 workers, got %d", target)
+	}
+	state.lastScaled = now
+
+	// Within the cooldown nothing changes
+	target, _ = a.decide(state, scaleSample{depth: 200, busy: 5, workers: 5, maxWorkers: 8}, now.Add(30*time.Second))
+	if target != 5 {
+		t.Fatalf("expected no change during cooldown, got %d", target)
+	}
+
+	// Never above max_workers
+	target, _ = a.decide(state, scaleSample{depth: 200, busy: 5, workers: 5, maxWorkers: 8}, now.Add(2*time.Minute))
+	if target != 8 {
+		t.Fatalf("expected scale up capped at 8 workers, got %d", target)
+	}
+	state.lastScaled = now.Add(2 * time.Minute)
+
+	// Between the thresholds the pool is left alone
+	target, _ = a.decide(state, scaleSample{depth: 20, busy: 4, workers: 8, maxWorkers: 8}, now.Add(10*time.Minute))
+	if target != 8 || state.idleSamples != 0 {
+		t.Fatalf("expected no change between thresholds, got %d", target)
+	}
+
+	// Shrinking needs consecutive idle samples
+	idle := scaleSample{depth: 0, busy: 1, workers: 8, maxWorkers: 8}
+	for i := range 2 {
+		if target, _ = a.decide(state, idle, now.Add(10*time.Minute)); target != 8 {
+			t.Fatalf("sample %d: expected no change before enough idle samples, got %d", i, target)
+		}
+	}
+	if target, _ = a.decide(state, idle, now.Add(10*time.Minute)); target != 7 {
+		t.Fatalf("expected scale down to 7 workers, got %d", target)
+	}
+
+	// Never below the minimum
+	state = &scaleState{}
+	for range 5 {
+		target, _ = a.decide(state, scaleSample{workers: 1, maxWorkers: 8}, now)
+	}
+	if target != 1 {
+		t.Fatalf("expected pool to stay at the minimum, got %d", target)
+	}
+}
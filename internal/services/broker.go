@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+)
+
+const (
+	// MaxPriority is the highest MessageRequest.Priority (2=urgent). Tenant
+	// queues deliver higher priorities first.
+	MaxPriority = 2
+
+	// PrefetchPerWorker sizes a consumer's prefetch from its worker count
+	// unless the tenant overrides it. Prefetch bounds the unacked deliveries
+	// a consumer holds. Without it the whole backlog is pushed to the worker
+	// up front, held in memory and redelivered after a crash, and urgent
+	// messages published later have to wait behind it.
+	PrefetchPerWorker = 2
+)
+
+// Broker is the queueing backend used by TenantManager, MessageService and
+// the outbox relay. Every tenant owns exactly one queue.
+type Broker interface {
+	CreateTenantQueue(tenantID string) error
+	DeleteTenantQueue(tenantID string) error
+	PublishMessageWithHeaders(ctx context.Context, tenantID string, payload []byte, headers map[string]any) error
+	// Consume subscribes to the tenant queue holding at most prefetch
+	// unacked deliveries.
+	Consume(tenantID string, prefetch int) (Subscription, error)
+}
+
+// Subscription is an active consumer on a tenant queue. Deliveries is closed
+// when the subscription ends, either through Close or a broker failure.
+type Subscription interface {
+	Deliveries() <-chan Delivery
+	// SetPrefetch changes how many unacked deliveries the consumer holds
+	// without interrupting the ones being processed.
+	SetPrefetch(prefetch int) error
+	Close() error
+}
+
+// QueueMigrator is implemented by brokers whose existing queues have to be
+// rebuilt before they honour message priorities.
+type QueueMigrator interface {
+	MigrateTenantQueue(tenantID string) error
+}
+
+// QueueInspector is implemented by brokers that can report how many
+// messages wait in a tenant queue.
+type QueueInspector interface {
+	MessageCount(tenantID string) (int, error)
+}
+
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+	// Retry hands the message back to the broker, which redelivers it after
+	// the retry delay with RetryCount incremented.
+	Retry() error
+}
+
+// Delivery is a broker-neutral message handed to tenant workers. It must be
+// settled exactly once with Ack, Nack or Retry.
+type Delivery struct {
+	Body        []byte
+	Headers     map[string]any
+	ContentType string
+	Redelivered bool
+	Priority    int
+	// RetryCount is the number of times the message went through Retry,
+	// tracked by the broker rather than by the message headers, except on
+	// RabbitMQ queues not yet migrated to the retry topology
+	RetryCount int
+
+	acknowledger Acknowledger
+}
+
+func (d Delivery) Ack() error {
+	return d.acknowledger.Ack()
+}
+
+func (d Delivery) Nack(requeue bool) error {
+	return d.acknowledger.Nack(requeue)
+}
+
+func (d Delivery) Retry() error {
+	return d.acknowledger.Retry()
+}
+
+// messagePriority reads the priority header, clamped to 0..MaxPriority.
+func messagePriority(headers map[string]any) int {
+	return min(max(headerInt(headers, "priority"), 0), MaxPriority)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/aarondl/sqlboiler/v4/queries/qm"
+	"github.com/lib/pq"
+)
+
+const (
+	deadLetterReplayBatch = 100
+	deadLetterReplayer    = "dead-letter-replay"
+)
+
+// DeadLetterFilter narrows a dead-letter listing. Zero values are ignored.
+type DeadLetterFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Reason string // substring of failure_reason or last_error
+	Type   string // message type from the payload
+	Cursor string // id of the last entry of the previous page
+	Limit  int
+}
+
+type DeadLetterService struct {
+	db     *sql.DB
+	outbox *OutboxRelay
+}
+
+func NewDeadLetterService(db *sql.DB, outbox *OutboxRelay) *DeadLetterService {
+	return &DeadLetterService{db: db, outbox: outbox}
+}
+
+// List returns a tenant's dead letters, newest first, and the cursor of the
+// next page, which is empty on the last page.
+func (s *DeadLetterService) List(ctx context.Context, tenantID string, filter DeadLetterFilter) ([]*models.DeadLetterMessage, string, error) {
+	queryMods := []qm.QueryMod{
+		qm.Where("tenant_id = ?", tenantID),
+	}
+
+	if filter.From != nil {
+		queryMods = append(queryMods, qm.Where("created_at >= ?", *filter.From))
+	}
+	if filter.To != nil {
+		queryMods = append(queryMods, qm.Where("created_at < ?", *filter.To))
+	}
+	if filter.Reason != "" {
+		pattern := "%" + filter.Reason + "%"
+		queryMods = append(queryMods, qm.Where("(failure_reason ILIKE ? OR last_error ILIKE ?)", pattern, pattern))
+	}
+	if filter.Type != "" {
+		queryMods = append(queryMods, qm.Where("payload->>'type' = ?", filter.Type))
+	}
+	if filter.Cursor != "" {
+		queryMods = append(queryMods, qm.Where(
+			"(created_at, id) < (SELECT created_at, id FROM dead_letter_messages WHERE id = ? AND tenant_id = ?)",
+			filter.Cursor, tenantID))
+	}
+
+	queryMods = append(queryMods,
+		qm.OrderBy("created_at DESC, id DESC"),
+		qm.Limit(filter.Limit+1), // Get one extra to check if there's a next page
+	)
+
+	entries, err := models.DeadLetterMessages(queryMods...).All(ctx, s.db)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+		nextCursor = entries[len(entries)-1].ID
+	}
+
+	return entries, nextCursor, nil
+}
+
+// Get returns a single dead letter of a tenant.
+func (s *DeadLetterService) Get(ctx context.Context, tenantID, id string) (*models.DeadLetterMessage, error) {
+	return models.DeadLetterMessages(
+		qm.Where("id = ? AND tenant_id = ?", id, tenantID),
+	).One(ctx, s.db)
+}
+
+// Replay moves dead letters back into the tenant queue with a fresh retry
+// budget, either the given ids or, when ids is nil, every entry of the
+// tenant. Each replayed entry is removed from the dead letters and recorded
+// in message_processing_logs against the original message. It returns the
+// ids of the replayed messages and, of the given ids, those that were not
+// replayed because they are no dead letters of the tenant (anymore), e.g.
+// as a concurrent replay or purge got them first. Like publishes, replays
+// fail with ErrTenantSuspended while the tenant is suspended and rejects
+// publishes.
+func (s *DeadLetterService) Replay(ctx context.Context, tenantID string, ids []string) ([]string, []string, error) {
+	var replayed []string
+	done := make(map[string]bool)
+
+	for {
+		messageIDs, deadLetterIDs, err := s.replayBatch(ctx, tenantID, ids)
+		if err != nil {
+			return replayed, nil, err
+		}
+		replayed = append(replayed, messageIDs...)
+		for _, id := range deadLetterIDs {
+			done[id] = true
+		}
+
+		if len(messageIDs) < deadLetterReplayBatch {
+			break
+		}
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if !done[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	return replayed, missing, nil
+}
+
+// replayBatch replays up to deadLetterReplayBatch entries in one
+// transaction and returns the message and dead letter ids it replayed. The
+// messages go through the outbox, so the relay publishes them even if the
+// broker is unavailable right now. Replaying everything skips entries a
+// concurrent replay holds, while given ids wait for whoever holds them.
+func (s *DeadLetterService) replayBatch(ctx context.Context, tenantID string, ids []string) ([]string, []string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkSuspended(ctx, tx, tenantID); err != nil {
+		return nil, nil, err
+	}
+
+	lock := "FOR UPDATE OF d SKIP LOCKED"
+	if ids != nil {
+		lock = "FOR UPDATE OF d"
+	}
+
+	// The headers of the original publish carry the priority
+	query := `
+        SELECT d.id, d.original_message_id, d.payload, COALESCE(o.headers, '{}')
+        FROM dead_letter_messages d
+        LEFT JOIN LATERAL (
+            SELECT headers FROM outbox_messages
+            WHERE message_id = d.original_message_id AND tenant_id = d.tenant_id
+            ORDER BY created_at DESC
+            LIMIT 1
+        ) o ON true
+        WHERE d.tenant_id = $1 AND ($2::uuid[] IS NULL OR d.id = ANY($2))
+        ORDER BY d.created_at ASC
+        LIMIT $3
+        ` + lock
+	rows, err := tx.QueryContext(ctx, query, tenantID, pq.Array(ids), deadLetterReplayBatch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type replay struct {
+		deadLetterID string
+		messageID    string
+		payload      []byte
+		headers      map[string]any
+	}
+
+	var replays []replay
+	for rows.Next() {
+		var (
+			r           replay
+			headersJSON []byte
+		)
+		if err := rows.Scan(&r.deadLetterID, &r.messageID, &r.payload, &headersJSON); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(headersJSON, &r.headers); err != nil || r.headers == nil {
+			r.headers = make(map[string]any)
+		}
+		replays = append(replays, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	messageIDs := make([]string, 0, len(replays))
+	deadLetterIDs := make([]string, 0, len(replays))
+	for _, r := range replays {
+		// Drop the retry state of the failed run
+		delete(r.headers, "retry_count")
+		delete(r.headers, "retry_timestamp")
+		delete(r.headers, "x-death")
+		// Set on publishes by earlier releases
+		delete(r.headers, "publish_id")
+		r.headers["message_id"] = r.messageID
+		r.headers["tenant_id"] = tenantID
+		r.headers["replayed_from"] = r.deadLetterID
+
+		// Reset the row as if it had never been published, which also lets
+		// the postgres driver reopen it
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO messages (id, tenant_id, payload, status, retry_count)
+            VALUES ($1, $2, $3, 'pending', 0)
+            ON CONFLICT (id, tenant_id) DO UPDATE
+            SET status = 'pending', retry_count = 0, visible_at = NULL, lease_token = NULL, processed_at = NULL
+        `, r.messageID, tenantID, r.payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to reset message %s: %w", r.messageID, err)
+		}
+
+		if _, err := s.outbox.EnqueueNow(ctx, tx, r.messageID, tenantID, r.payload, r.headers); err != nil {
+			return nil, nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO message_processing_logs (message_id, tenant_id, worker_id, status, error_message)
+            VALUES ($1, $2, $3, 'replayed', $4)
+        `, r.messageID, tenantID, deadLetterReplayer, fmt.Sprintf("replayed from dead letter %s", r.deadLetterID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM dead_letter_messages WHERE id = $1`, r.deadLetterID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		messageIDs = append(messageIDs, r.messageID)
+		deadLetterIDs = append(deadLetterIDs, r.deadLetterID)
+	}
+
+	return messageIDs, deadLetterIDs, tx.Commit()
+}
+
+// Purge deletes the given dead letters, or every entry of the tenant when
+// ids is nil, and returns how many were removed.
+func (s *DeadLetterService) Purge(ctx context.Context, tenantID string, ids []string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM dead_letter_messages
+        WHERE tenant_id = $1 AND ($2::uuid[] IS NULL OR id = ANY($2))
+    `, tenantID, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
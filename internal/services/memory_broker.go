@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is an in-process Broker built on Go channels. It keeps the
+// RabbitMQ semantics the tenant manager relies on: publishes to a missing
+// queue are unroutable, unacked messages are redelivered when their
+// subscription closes, nack with requeue puts a message back in front, and
+// higher priorities are delivered first.
+type MemoryBroker struct {
+	queues     map[string]*memoryQueue
+	mutex      sync.Mutex
+	retryDelay time.Duration
+}
+
+type memoryMessage struct {
+	body        []byte
+	headers     map[string]any
+	priority    int
+	retries     int
+	redelivered bool
+}
+
+type memoryQueue struct {
+	mutex         sync.Mutex
+	ready         []*memoryMessage
+	signal        chan struct{}
+	subscriptions map[*memorySubscription]struct{}
+	deleted       bool
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+func NewMemoryBroker(retryDelay time.Duration) *MemoryBroker {
+	return &MemoryBroker{
+		queues:     make(map[string]*memoryQueue),
+		retryDelay: retryDelay,
+	}
+}
+
+func (b *MemoryBroker) queue(tenantID string) (*memoryQueue, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q, ok := b.queues[tenantID]
+	return q, ok
+}
+
+func (b *MemoryBroker) CreateTenantQueue(tenantID string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, exists := b.queues[tenantID]; !exists {
+		b.queues[tenantID] = &memoryQueue{
+			signal:        make(chan struct{}),
+			subscriptions: make(map[*memorySubscription]struct{}),
+		}
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) DeleteTenantQueue(tenantID string) error {
+	b.mutex.Lock()
+	q, exists := b.queues[tenantID]
+	delete(b.queues, tenantID)
+	b.mutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	q.mutex.Lock()
+	q.deleted = true
+	subscriptions := make([]*memorySubscription, 0, len(q.subscriptions))
+	for sub := range q.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	q.mutex.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.Close()
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) PublishMessageWithHeaders(ctx context.Context, tenantID string, payload []byte, headers map[string]any) error {
+	q, exists := b.queue(tenantID)
+	if !exists {
+		return fmt.Errorf("%w: queue tenant_%s_queue", ErrUnroutable, tenantID)
+	}
+
+	copied := make(map[string]any, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+
+	q.push(&memoryMessage{body: payload, headers: copied, priority: messagePriority(headers)}, false)
+	return nil
+}
+
+// QueueDepth returns the number of ready (not yet delivered) messages.
+func (b *MemoryBroker) QueueDepth(tenantID string) int {
+	q, exists := b.queue(tenantID)
+	if !exists {
+		return 0
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.ready)
+}
+
+func (b *MemoryBroker) MessageCount(tenantID string) (int, error) {
+	return b.QueueDepth(tenantID), nil
+}
+
+// Consume ignores prefetch: deliveries are handed over one at a time as
+// workers take them, so the worker pool already bounds them.
+func (b *MemoryBroker) Consume(tenantID string, prefetch int) (Subscription, error) {
+	q, exists := b.queue(tenantID)
+	if !exists {
+		return nil, fmt.Errorf("queue tenant_%s_queue not found", tenantID)
+	}
+
+	sub := &memorySubscription{
+		queue:      q,
+		retryDelay: b.retryDelay,
+		deliveries: make(chan Delivery),
+		unacked:    make(map[*memoryMessage]struct{}),
+		done:       make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+
+	q.mutex.Lock()
+	q.subscriptions[sub] = struct{}{}
+	q.mutex.Unlock()
+
+	go sub.dispatch()
+
+	return sub, nil
+}
+
+// push queues a message behind those of the same or higher priority, or in
+// front of its priority when it is being requeued, and wakes every waiting
+// subscription.
+func (q *memoryQueue) push(msg *memoryMessage, front bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.deleted {
+		return
+	}
+
+	i := 0
+	for i < len(q.ready) && (q.ready[i].priority > msg.priority ||
+		!front && q.ready[i].priority == msg.priority) {
+		i++
+	}
+	q.ready = slices.Insert(q.ready, i, msg)
+
+	close(q.signal)
+	q.signal = make(chan struct{})
+}
+
+// pop returns the next ready message, or a channel that is closed once more
+// messages arrive.
+func (q *memoryQueue) pop() (*memoryMessage, <-chan struct{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.ready) == 0 {
+		return nil, q.signal
+	}
+
+	msg := q.ready[0]
+	q.ready = q.ready[1:]
+	return msg, nil
+}
+
+type memorySubscription struct {
+	queue      *memoryQueue
+	retryDelay time.Duration
+	deliveries chan Delivery
+	unacked    map[*memoryMessage]struct{}
+	mutex      sync.Mutex
+	done       chan struct{}
+	finished   chan struct{}
+	closeOnce  sync.Once
+}
+
+func (s *memorySubscription) Deliveries() <-chan Delivery {
+	return s.deliveries
+}
+
+func (s *memorySubscription) SetPrefetch(prefetch int) error {
+	return nil
+}
+
+// Close stops delivery and requeues every message that was handed out but
+// not yet acked, like RabbitMQ does when a channel closes.
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.finished
+
+		s.queue.mutex.Lock()
+		delete(s.queue.subscriptions, s)
+		s.queue.mutex.Unlock()
+
+		s.mutex.Lock()
+		pending := s.unacked
+		s.unacked = make(map[*memoryMessage]struct{})
+		s.mutex.Unlock()
+
+		for msg := range pending {
+			msg.redelivered = true
+			s.queue.push(msg, true)
+		}
+	})
+
+	return nil
+}
+
+func (s *memorySubscription) dispatch() {
+	defer close(s.finished)
+	defer close(s.deliveries)
+
+	for {
+		msg, wait := s.queue.pop()
+		if msg == nil {
+			select {
+			case <-wait:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		s.mutex.Lock()
+		s.unacked[msg] = struct{}{}
+		s.mutex.Unlock()
+
+		delivery := Delivery{
+			Body:         msg.body,
+			Headers:      msg.headers,
+			ContentType:  "application/json",
+			Redelivered:  msg.redelivered,
+			Priority:     msg.priority,
+			RetryCount:   msg.retries,
+			acknowledger: &memoryAcknowledger{subscription: s, message: msg},
+		}
+
+		select {
+		case s.deliveries <- delivery:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+type memoryAcknowledger struct {
+	subscription *memorySubscription
+	message      *memoryMessage
+}
+
+func (a *memoryAcknowledger) settle() bool {
+	a.subscription.mutex.Lock()
+	defer a.subscription.mutex.Unlock()
+
+	if _, ok := a.subscription.unacked[a.message]; !ok {
+		return false
+	}
+	delete(a.subscription.unacked, a.message)
+	return true
+}
+
+func (a *memoryAcknowledger) Ack() error {
+	if !a.settle() {
+		return fmt.Errorf("delivery already settled or subscription closed")
+	}
+	return nil
+}
+
+func (a *memoryAcknowledger) Nack(requeue bool) error {
+	if !a.settle() {
+		return fmt.Errorf("delivery already settled or subscription closed")
+	}
+
+	if requeue {
+		a.message.redelivered = true
+		a.subscription.queue.push(a.message, true)
+	}
+	return nil
+}
+
+// Retry puts the message back at the tail of its priority once the retry
+// delay has passed.
+func (a *memoryAcknowledger) Retry() error {
+	if !a.settle() {
+		return fmt.Errorf("delivery already settled or subscription closed")
+	}
+
+	a.message.retries++
+	a.message.redelivered = true
+	time.AfterFunc(a.subscription.retryDelay, func() {
+		a.subscription.queue.push(a.message, false)
+	})
+	return nil
+}
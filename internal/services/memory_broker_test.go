@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub Subscription) Delivery {
+	t.Helper()
+
+	select {
+	case d, ok := <-sub.Deliveries():
+		if !ok {
+			t.Fatalf("deliveries channel closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for delivery")
+	}
+	return Delivery{}
+}
+
+func TestMemoryBrokerPublishUnroutable(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+
+	err := broker.PublishMessageWithHeaders(context.Background(), "missing", []byte(`{}`), nil)
+	if !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("expected ErrUnroutable, got %v", err)
+	}
+}
+
+func TestMemoryBrokerConsumeAndAck(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := broker.Consume("t1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	headers := map[string]any{"message_id": "m1"}
+	if err := broker.PublishMessageWithHeaders(context.Background(), "t1", []byte(`{"n":1}`), headers); err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, sub)
+	if string(d.Body) != `{"n":1}` || d.Headers["message_id"] != "m1" {
+		t.Fatalf("unexpected delivery: %s %v", d.Body, d.Headers)
+	}
+	if d.Redelivered {
+		t.Fatalf("first delivery must not be marked redelivered")
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(); err == nil {
+		t.Fatalf("expected error on double ack")
+	}
+}
+
+func TestMemoryBrokerNackRequeue(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	broker.CreateTenantQueue("t1")
+
+	sub, _ := broker.Consume("t1", 10)
+	defer sub.Close()
+
+	broker.PublishMessageWithHeaders(context.Background(), "t1", []byte("a"), nil)
+
+	first := receive(t, sub)
+	if err := first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+
+	again := receive(t, sub)
+	if string(again.Body) != "a" || !again.Redelivered {
+		t.Fatalf("expected redelivery of requeued message, got %s redelivered=%v", again.Body, again.Redelivered)
+	}
+	again.Nack(false)
+
+	if depth := broker.QueueDepth("t1"); depth != 0 {
+		t.Fatalf("expected empty queue after nack without requeue, got %d", depth)
+	}
+}
+
+func TestMemoryBrokerRedeliversUnackedOnClose(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	broker.CreateTenantQueue("t1")
+
+	sub, _ := broker.Consume("t1", 10)
+	broker.PublishMessageWithHeaders(context.Background(), "t1", []byte("a"), nil)
+	receive(t, sub)
+
+	sub.Close()
+	if _, ok := <-sub.Deliveries(); ok {
+		t.Fatalf("expected deliveries channel to be closed")
+	}
+
+	next, _ := broker.Consume("t1", 10)
+	defer next.Close()
+
+	d := receive(t, next)
+	if string(d.Body) != "a" || !d.Redelivered {
+		t.Fatalf("expected unacked message to be redelivered, got %s redelivered=%v", d.Body, d.Redelivered)
+	}
+}
+
+func TestMemoryBrokerDeleteQueueClosesSubscriptions(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	broker.CreateTenantQueue("t1")
+
+	sub, _ := broker.Consume("t1", 10)
+	broker.DeleteTenantQueue("t1")
+
+	select {
+	case _, ok := <-sub.Deliveries():
+		if ok {
+			t.Fatalf("expected no deliveries after queue deletion")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscription was not closed")
+	}
+
+	err := broker.PublishMessageWithHeaders(context.Background(), "t1", []byte("a"), nil)
+	if !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("expected ErrUnroutable after deletion, got %v", err)
+	}
+}
+
+func TestMemoryBrokerDeliversByPriority(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A normal backlog followed by a high and an urgent message; priorities
+	// arrive as float64 when headers come back from the outbox JSON.
+	publish := []struct {
+		id       string
+		priority any
+	}{
+		{"n1", 0}, {"n2", 0}, {"n3", nil}, {"h1", float64(1)}, {"u1", int32(2)}, {"n4", 0},
+	}
+	for _, p := range publish {
+		headers := map[string]any{"message_id": p.id, "priority": p.priority}
+		if err := broker.PublishMessageWithHeaders(context.Background(), "t1", []byte(`{}`), headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub, err := broker.Consume("t1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	var order []string
+	for range publish {
+		d := receive(t, sub)
+		order = append(order, d.Headers["message_id"].(string))
+		d.Ack()
+	}
+
+	want := []string{"u1", "h1", "n1", "n2", "n3", "n4"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("expected delivery order %v, got %v", want, order)
+	}
+}
+
+func TestMemoryBrokerRetryRedeliversWithCount(t *testing.T) {
+	broker := NewMemoryBroker(50 * time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := broker.Consume("t1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	broker.PublishMessageWithHeaders(context.Background(), "t1", []byte(`{}`), map[string]any{"message_id": "m1"})
+
+	for want := 0; want < 3; want++ {
+		d := receive(t, sub)
+		if d.RetryCount != want {
+			t.Fatalf("expected retry count %d, got %d", want, d.RetryCount)
+		}
+
+		retriedAt := time.Now()
+		if err := d.Retry(); err != nil {
+			t.Fatal(err)
+		}
+		if broker.QueueDepth("t1") != 0 {
+			t.Fatalf("retried message must wait for the retry delay")
+		}
+		if err := d.Ack(); err == nil {
+			t.Fatalf("expected settling a retried delivery to fail")
+		}
+
+		next := receive(t, sub)
+		if elapsed := time.Since(retriedAt); elapsed < 50*time.Millisecond {
+			t.Fatalf("message redelivered after %s, before the retry delay", elapsed)
+		}
+		next.Nack(true)
+	}
+}
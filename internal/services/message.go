@@ -4,24 +4,35 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/aarondl/null/v8"
 	"github.com/aarondl/sqlboiler/v4/boil"
 	"github.com/aarondl/sqlboiler/v4/queries/qm"
 )
 
+// ErrNotConfirmed means the message is stored but the broker has not
+// confirmed it yet; the outbox relay keeps retrying in the background.
+var ErrNotConfirmed = errors.New("message stored but not confirmed by broker")
+
+var ErrNotScheduled = errors.New("message not found or no longer scheduled")
+
 type MessageService struct {
-	db       *sql.DB
-	rabbitmq *RabbitMQService
+	db     *sql.DB
+	outbox *OutboxRelay
 }
 
-func NewMessageService(db *sql.DB, rabbitmq *RabbitMQService) *MessageService {
-	return &MessageService{db: db, rabbitmq: rabbitmq}
+func NewMessageService(db *sql.DB, outbox *OutboxRelay) *MessageService {
+	return &MessageService{db: db, outbox: outbox}
 }
 
-func (s *MessageService) Publish(ctx context.Context, tenantID, messageID string, messageReq *models.MessageRequest) error {
+// Publish stores the message and hands it to the broker. Messages scheduled
+// in the future are only stored; the Scheduler releases them when due. The
+// returned status is either "queued" or "scheduled".
+func (s *MessageService) Publish(ctx context.Context, tenantID, messageID string, messageReq *models.MessageRequest) (string, error) {
 	messagePayload := map[string]any{
 		"id":           messageID,
 		"tenant_id":    tenantID,
@@ -34,7 +45,7 @@ func (s *MessageService) Publish(ctx context.Context, tenantID, messageID string
 	// Convert to JSON
 	payload, err := json.Marshal(messagePayload)
 	if err != nil {
-		return fmt.Errorf("Failed to serialize message")
+		return "", fmt.Errorf("Failed to serialize message")
 	}
 
 	// Prepare headers
@@ -44,28 +55,137 @@ func (s *MessageService) Publish(ctx context.Context, tenantID, messageID string
 	headers["priority"] = messageReq.Priority
 	headers["created_at"] = time.Now().Unix()
 
-	// Handle scheduled messages
-	if messageReq.ScheduledAt != nil && messageReq.ScheduledAt.After(time.Now()) {
-		headers["scheduled_at"] = messageReq.ScheduledAt.Unix()
-	}
-
 	message := &models.Message{
 		ID:       messageID,
 		TenantID: tenantID,
 		Payload:  payload,
 	}
 
-	err = message.Insert(ctx, s.db, boil.Infer())
+	// Handle scheduled messages
+	scheduled := messageReq.ScheduledAt != nil && messageReq.ScheduledAt.After(time.Now())
+	if scheduled {
+		headers["scheduled_at"] = messageReq.ScheduledAt.Unix()
+		message.Status = null.StringFrom("scheduled")
+		message.ScheduledAt = null.TimeFrom(*messageReq.ScheduledAt)
+	}
+
+	// Store the message and its outbox entry atomically; the relay publishes it
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := checkSuspended(ctx, tx, tenantID); err != nil {
+		return "", err
+	}
+
+	if err := message.Insert(ctx, tx, boil.Infer()); err != nil {
+		return "", err
+	}
+
+	if scheduled {
+		// Keep the headers with the row until the scheduler releases it
+		headersJSON, err := json.Marshal(headers)
+		if err != nil {
+			return "", err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE messages SET headers = $3 WHERE id = $1 AND tenant_id = $2`,
+			messageID, tenantID, headersJSON)
+		if err != nil {
+			return "", err
+		}
+
+		return "scheduled", tx.Commit()
+	}
+
+	entryID, err := s.outbox.Enqueue(ctx, tx, messageID, tenantID, payload, headers)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	// Wait for the broker to take ownership before reporting success
+	if err := s.outbox.Deliver(ctx, entryID); err != nil {
+		if errors.Is(err, ErrUnroutable) {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %v", ErrNotConfirmed, err)
+	}
+
+	return "queued", nil
+}
+
+// rejectsPublishes is an SQL condition on the tenants row t: the tenant is
+// suspended and its suspension config rejects publishes.
+var rejectsPublishes = fmt.Sprintf(`t.status = '%s' AND EXISTS (
+            SELECT 1 FROM tenant_configs c
+            WHERE c.tenant_id = t.id AND c.config_key = '%s' AND c.is_active = true
+            AND c.config_value->>'publish' = '%s'
+        )`, TenantStatusSuspended, SuspensionConfigKey, SuspendedPublishReject)
+
+// checkSuspended fails with ErrTenantSuspended when the tenant is suspended
+// and rejects publishes. The tenant row stays locked until tx ends, so a
+// concurrent suspend applies either before or after the publish.
+func checkSuspended(ctx context.Context, tx *sql.Tx, tenantID string) error {
+	var rejects bool
+	err := tx.QueryRowContext(ctx, `
+        SELECT `+rejectsPublishes+` FROM tenants t WHERE t.id = $1 FOR SHARE
+    `, tenantID).Scan(&rejects)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Unknown tenants fail on insert
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if rejects {
+		return ErrTenantSuspended
+	}
 
-	return s.rabbitmq.PublishMessageWithHeaders(ctx, tenantID, payload, headers)
+	return nil
 }
 
-func (s *MessageService) GetMessagesPaginated(ctx context.Context, cursor int64, limit int) ([]*models.Message, int64, error) {
+// GetMessage returns a single message of a tenant.
+func (s *MessageService) GetMessage(ctx context.Context, tenantID, messageID string) (*models.Message, error) {
+	return models.Messages(
+		qm.Where("id = ? AND tenant_id = ?", messageID, tenantID),
+	).One(ctx, s.db)
+}
+
+// CancelScheduled cancels a scheduled message that has not been released yet.
+func (s *MessageService) CancelScheduled(ctx context.Context, tenantID, messageID string) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE messages SET status = 'cancelled'
+        WHERE id = $1 AND tenant_id = $2 AND status = 'scheduled'
+    `, messageID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotScheduled
+	}
+
+	return nil
+}
+
+// GetMessagesPaginated lists messages of tenantID, or of every tenant when
+// tenantID is empty.
+func (s *MessageService) GetMessagesPaginated(ctx context.Context, tenantID string, cursor int64, limit int) ([]*models.Message, int64, error) {
 	var queryMods []qm.QueryMod
 	
+	if tenantID != "" {
+		queryMods = append(queryMods, models.MessageWhere.TenantID.EQ(tenantID))
+	}
 	if cursor > 0 {
 		queryMods = append(queryMods, qm.Where("created_at > (SELECT created_at FROM messages WHERE id = ?)", fmt.Sprintf("%d", cursor)))
 	}
@@ -12,6 +12,11 @@ type MonitoringService struct {
 	QueueDepth          *prometheus.GaugeVec
 	WorkerActivity      *prometheus.GaugeVec
 	ProcessingDuration  *prometheus.HistogramVec
+	WorkerPoolSize      *prometheus.GaugeVec
+	ScalingDecisions    *prometheus.CounterVec
+	WorkerBudgetUsed    prometheus.Gauge
+	WorkerShare         *prometheus.GaugeVec
+	WorkersBorrowed     *prometheus.GaugeVec
 }
 
 func NewMonitoringService() *MonitoringService {
@@ -41,6 +46,26 @@ func NewMonitoringService() *MonitoringService {
 			Help:    "Duration of message processing",
 			Buckets: prometheus.DefBuckets,
 		}, []string{"tenant_id", "message_type"}),
+		WorkerPoolSize: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "worker_pool_size",
+			Help: "Number of workers per tenant",
+		}, []string{"tenant_id"}),
+		ScalingDecisions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "autoscaler_decisions_total",
+			Help: "The total number of worker pool resizes by the autoscaler",
+		}, []string{"tenant_id", "direction"}),
+		WorkerBudgetUsed: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "worker_budget_busy",
+			Help: "Number of busy workers on the node across all tenants",
+		}),
+		WorkerShare: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "worker_budget_share",
+			Help: "Number of workers each tenant is entitled to in the node's budget",
+		}, []string{"tenant_id"}),
+		WorkersBorrowed: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "worker_budget_borrowed",
+			Help: "Number of busy workers per tenant above its share",
+		}, []string{"tenant_id"}),
 	}
 }
 
@@ -63,4 +88,31 @@ func (m *MonitoringService) UpdateQueueDepth(tenantID string, depth float64) {
 
 func (m *MonitoringService) UpdateWorkerActivity(tenantID string, activeWorkers float64) {
 	m.WorkerActivity.WithLabelValues(tenantID).Set(activeWorkers)
+}
+
+func (m *MonitoringService) UpdateWorkerPoolSize(tenantID string, workers float64) {
+	m.WorkerPoolSize.WithLabelValues(tenantID).Set(workers)
+}
+
+func (m *MonitoringService) RecordScalingDecision(tenantID, direction string) {
+	m.ScalingDecisions.WithLabelValues(tenantID, direction).Inc()
+}
+
+// ForgetTenant drops the per-tenant gauges of a tenant no longer consumed
+// in this process.
+func (m *MonitoringService) ForgetTenant(tenantID string) {
+	m.QueueDepth.DeleteLabelValues(tenantID)
+	m.WorkerActivity.DeleteLabelValues(tenantID)
+	m.WorkerPoolSize.DeleteLabelValues(tenantID)
+}
+
+// UpdateWorkerBudget replaces the per-tenant allocation gauges with report.
+func (m *MonitoringService) UpdateWorkerBudget(report BudgetReport) {
+	m.WorkerBudgetUsed.Set(float64(report.Busy))
+	m.WorkerShare.Reset()
+	m.WorkersBorrowed.Reset()
+	for _, allocation := range report.Tenants {
+		m.WorkerShare.WithLabelValues(allocation.TenantID).Set(float64(allocation.Share))
+		m.WorkersBorrowed.WithLabelValues(allocation.TenantID).Set(float64(allocation.Borrowed))
+	}
 }
\ No newline at end of file
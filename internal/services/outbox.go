@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	outboxPollInterval = 1 * time.Second
+	// outboxHandoffDelay keeps the relay away from entries the API is still
+	// publishing synchronously.
+	outboxHandoffDelay = 10 * time.Second
+	outboxBatchSize    = 100
+	outboxMaxAttempts  = 10
+	// outboxLease is how long a claimed entry belongs to the relay that
+	// claimed it. Entries left publishing by a crashed relay are claimed
+	// again once it has passed.
+	outboxLease = time.Minute
+)
+
+type OutboxEntry struct {
+	ID        string
+	MessageID string
+	TenantID  string
+	Payload   []byte
+	Headers   map[string]any
+	Attempts  int
+}
+
+// OutboxRelay drains outbox_messages to the broker. Entries are written in the
+// same transaction as the message row, so every stored message is eventually
+// published at least once.
+type OutboxRelay struct {
+	db     *sql.DB
+	broker Broker
+}
+
+func NewOutboxRelay(db *sql.DB, broker Broker) *OutboxRelay {
+	return &OutboxRelay{
+		db:     db,
+		broker: broker,
+	}
+}
+
+// Enqueue stores an outbox entry using the caller's transaction. The caller
+// is expected to Deliver it right after commit; the relay only takes over
+// once the handoff delay has passed.
+func (r *OutboxRelay) Enqueue(ctx context.Context, tx *sql.Tx, messageID, tenantID string, payload []byte, headers map[string]any) (string, error) {
+	return r.enqueue(ctx, tx, messageID, tenantID, payload, headers, outboxHandoffDelay)
+}
+
+// EnqueueNow stores an outbox entry that the relay publishes on its next pass.
+func (r *OutboxRelay) EnqueueNow(ctx context.Context, tx *sql.Tx, messageID, tenantID string, payload []byte, headers map[string]any) (string, error) {
+	return r.enqueue(ctx, tx, messageID, tenantID, payload, headers, 0)
+}
+
+func (r *OutboxRelay) enqueue(ctx context.Context, tx *sql.Tx, messageID, tenantID string, payload []byte, headers map[string]any, delay time.Duration) (string, error) {
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+        INSERT INTO outbox_messages (message_id, tenant_id, payload, headers, next_attempt_at)
+        VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 millisecond')
+        RETURNING id
+    `
+	var id string
+	err = tx.QueryRowContext(ctx, query, messageID, tenantID, payload, headersJSON, delay.Milliseconds()).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+
+	return id, nil
+}
+
+// Deliver publishes a single committed entry right away and returns the
+// broker's verdict. Entries that fail transiently stay pending for the relay.
+func (r *OutboxRelay) Deliver(ctx context.Context, entryID string) error {
+	query := `
+        UPDATE outbox_messages
+        SET status = 'publishing', next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+        WHERE id = $1 AND status = 'pending'
+        RETURNING id, message_id, tenant_id, payload, headers, attempts
+    `
+	entries, err := r.scanEntries(r.db.QueryContext(ctx, query, entryID, outboxLease.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		// Already handled by the relay
+		return nil
+	}
+
+	entry := entries[0]
+	publishErr := r.broker.PublishMessageWithHeaders(ctx, entry.TenantID, entry.Payload, entry.Headers)
+	if err := r.markResult(ctx, entry, publishErr); err != nil {
+		return err
+	}
+
+	return publishErr
+}
+
+func (r *OutboxRelay) Start(ctx context.Context) {
+	go r.run(ctx)
+}
+
+func (r *OutboxRelay) run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("outbox relay has stopped")
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := r.drain(ctx)
+			if err != nil {
+				log.Printf("outbox relay drain failed, err: %s", err.Error())
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// drain publishes one batch of due entries and returns how many were claimed.
+// The batch is claimed in one short statement that marks it publishing
+// under a lease, so several API replicas can relay at once without holding
+// row locks while they publish. Each entry is then marked on its own, and
+// a failed mark only leaves that entry to be claimed again after its lease.
+func (r *OutboxRelay) drain(ctx context.Context) (int, error) {
+	query := `
+        UPDATE outbox_messages
+        SET status = 'publishing', next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+        WHERE id IN (
+            SELECT id FROM outbox_messages
+            WHERE status IN ('pending', 'publishing') AND next_attempt_at <= NOW()
+            ORDER BY created_at ASC
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, message_id, tenant_id, payload, headers, attempts
+    `
+	claimedAt := time.Now()
+	entries, err := r.scanEntries(r.db.QueryContext(ctx, query, outboxBatchSize, outboxLease.Milliseconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		// Past the lease the entry may belong to another relay already
+		if time.Since(claimedAt) >= outboxLease {
+			break
+		}
+
+		publishErr := r.broker.PublishMessageWithHeaders(ctx, entry.TenantID, entry.Payload, entry.Headers)
+		if err := r.markResult(ctx, entry, publishErr); err != nil {
+			log.Printf("Failed to mark outbox entry %s, err: %s", entry.ID, err.Error())
+		}
+	}
+
+	return len(entries), nil
+}
+
+func (r *OutboxRelay) scanEntries(rows *sql.Rows, err error) ([]*OutboxEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*OutboxEntry
+	for rows.Next() {
+		entry := &OutboxEntry{}
+		var headersJSON []byte
+		if err := rows.Scan(&entry.ID, &entry.MessageID, &entry.TenantID, &entry.Payload, &headersJSON, &entry.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(headersJSON, &entry.Headers); err != nil {
+			entry.Headers = make(map[string]any)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// markResult records the outcome of publishing a claimed entry. Each
+// outcome is a single statement.
+func (r *OutboxRelay) markResult(ctx context.Context, entry *OutboxEntry, publishErr error) error {
+	if publishErr == nil {
+		_, err := r.db.ExecContext(ctx, `
+            UPDATE outbox_messages
+            SET status = 'published', attempts = attempts + 1, published_at = NOW(), last_error = NULL
+            WHERE id = $1 AND status = 'publishing'
+        `, entry.ID)
+		return err
+	}
+
+	attempts := entry.Attempts + 1
+	// An unroutable message will never find its queue by retrying
+	if attempts >= outboxMaxAttempts || errors.Is(publishErr, ErrUnroutable) {
+		log.Printf("outbox entry %s for tenant %s gave up after %d attempts, err: %s",
+			entry.ID, entry.TenantID, attempts, publishErr.Error())
+
+		_, err := r.db.ExecContext(ctx, `
+            WITH failed AS (
+                UPDATE outbox_messages
+                SET status = 'failed', attempts = $2, last_error = $3
+                WHERE id = $1 AND status = 'publishing'
+                RETURNING message_id, tenant_id
+            )
+            UPDATE messages m SET status = 'failed'
+            FROM failed
+            WHERE m.id = failed.message_id AND m.tenant_id = failed.tenant_id
+        `, entry.ID, attempts, publishErr.Error())
+		return err
+	}
+
+	// Exponential backoff capped at one minute
+	backoff := min(time.Duration(1<<attempts)*time.Second, time.Minute)
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE outbox_messages
+        SET status = 'pending', attempts = $2, last_error = $3, next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond'
+        WHERE id = $1 AND status = 'publishing'
+    `, entry.ID, attempts, publishErr.Error(), backoff.Milliseconds())
+	return err
+}
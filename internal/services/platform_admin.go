@@ -0,0 +1,154 @@
+package services
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RolePlatformAdmin is the role of the platform admin. Only platform admins
+// create, delete and size tenants; tenant users can never hold it.
+const RolePlatformAdmin = "platform_admin"
+
+// maxTrackedClients is how many client addresses PlatformAdmin tracks
+// failed logins of. Beyond it, it forgets those that went quiet and then,
+// if they are all still locked out, those whose lockout ends first.
+const maxTrackedClients = 1024
+
+// PlatformAdmin is the operator account of the whole deployment. It is
+// configured rather than stored, so that there is someone to create the
+// first tenants and their admins. Failed logins are counted per client
+// address, in this process only, and lock that address out rather than the
+// account: anyone could otherwise lock the only operator out.
+type PlatformAdmin struct {
+	username     string
+	passwordHash []byte
+	config       TenantUserConfig
+
+	mutex   sync.Mutex
+	clients map[string]*loginAttempts
+}
+
+// loginAttempts are the recent failed logins of a client address.
+type loginAttempts struct {
+	failed      int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewPlatformAdmin checks passwordHash is a bcrypt hash. An empty hash
+// disables the account.
+func NewPlatformAdmin(username, passwordHash string, config TenantUserConfig) (*PlatformAdmin, error) {
+	if passwordHash != "" {
+		if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+			return nil, fmt.Errorf("platform admin password hash: %w", err)
+		}
+	}
+	config.MaxFailedLogins = max(config.MaxFailedLogins, 1)
+
+	return &PlatformAdmin{
+		username:     username,
+		passwordHash: []byte(passwordHash),
+		config:       config,
+		clients:      make(map[string]*loginAttempts),
+	}, nil
+}
+
+// Authenticate verifies the platform admin's username and password, sent
+// from the client address client. Like TenantUserService.Authenticate it
+// fails with ErrInvalidCredentials or, while the client is locked out,
+// ErrUserLocked.
+func (a *PlatformAdmin) Authenticate(client, username, password string) error {
+	if a.locked(client, time.Now()) {
+		return ErrUserLocked
+	}
+
+	// bcrypt is slow on purpose; other clients need not wait for it
+	hash := a.passwordHash
+	if len(hash) == 0 {
+		hash = dummyPasswordHash()
+	}
+	validPassword := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+
+	if !validPassword || !validUsername || len(a.passwordHash) == 0 {
+		a.recordFailure(client, time.Now())
+		return ErrInvalidCredentials
+	}
+
+	a.mutex.Lock()
+	delete(a.clients, client)
+	a.mutex.Unlock()
+
+	return nil
+}
+
+func (a *PlatformAdmin) locked(client string, now time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	attempts, ok := a.clients[client]
+	return ok && attempts.lockedUntil.After(now)
+}
+
+// recordFailure counts a failed login of client and locks it out once it
+// failed MaxFailedLogins times, each within LockoutDuration of the last.
+func (a *PlatformAdmin) recordFailure(client string, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	attempts, ok := a.clients[client]
+	if !ok {
+		if len(a.clients) >= maxTrackedClients {
+			a.forgetQuietClients(now)
+		}
+		// Addresses are cheap to rotate, so even locked clients make room
+		if len(a.clients) >= maxTrackedClients {
+			a.evictClient()
+		}
+		attempts = &loginAttempts{}
+		a.clients[client] = attempts
+	}
+
+	if now.Sub(attempts.lastFailure) > a.config.LockoutDuration {
+		attempts.failed = 0
+	}
+	attempts.failed++
+	attempts.lastFailure = now
+	if attempts.failed >= a.config.MaxFailedLogins {
+		attempts.lockedUntil, attempts.failed = now.Add(a.config.LockoutDuration), 0
+	}
+}
+
+// forgetQuietClients drops clients that are not locked out and whose last
+// failure no longer counts.
+func (a *PlatformAdmin) forgetQuietClients(now time.Time) {
+	for client, attempts := range a.clients {
+		if !attempts.lockedUntil.After(now) && now.Sub(attempts.lastFailure) > a.config.LockoutDuration {
+			delete(a.clients, client)
+		}
+	}
+}
+
+// evictClient drops the client that would be forgotten first: the one whose
+// lockout, or whose last failure counting towards one, ends first.
+func (a *PlatformAdmin) evictClient() {
+	var (
+		evicted  string
+		earliest time.Time
+	)
+	for client, attempts := range a.clients {
+		ends := attempts.lastFailure.Add(a.config.LockoutDuration)
+		if attempts.lockedUntil.After(ends) {
+			ends = attempts.lockedUntil
+		}
+		if evicted == "" || ends.Before(earliest) {
+			evicted, earliest = client, ends
+		}
+	}
+
+	delete(a.clients, evicted)
+}
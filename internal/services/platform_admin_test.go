@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPlatformAdminLockout(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := NewPlatformAdmin("root", string(hash), TenantUserConfig{MaxFailedLogins: 2, LockoutDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := admin.Authenticate("10.0.0.1", "root", "correct-horse"); err != nil {
+		t.Fatalf("valid login failed: %v", err)
+	}
+	if err := admin.Authenticate("10.0.0.1", "someone", "correct-horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong username: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if err := admin.Authenticate("10.0.0.1", "root", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if err := admin.Authenticate("10.0.0.1", "root", "correct-horse"); !errors.Is(err, ErrUserLocked) {
+		t.Fatalf("after lockout: got %v, want %v", err, ErrUserLocked)
+	}
+
+	// Only the failing client is locked out, not the account
+	if err := admin.Authenticate("10.0.0.2", "root", "correct-horse"); err != nil {
+		t.Fatalf("login from another client failed: %v", err)
+	}
+}
+
+func TestPlatformAdminForgetsQuietClients(t *testing.T) {
+	admin, err := NewPlatformAdmin("root", "", TenantUserConfig{MaxFailedLogins: 2, LockoutDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := range maxTrackedClients {
+		admin.recordFailure(fmt.Sprintf("client-%d", i), now)
+	}
+	admin.recordFailure("locked", now.Add(90*time.Second))
+	admin.recordFailure("locked", now.Add(90*time.Second))
+
+	// Failures older than the lockout duration no longer count
+	later := now.Add(2 * time.Minute)
+	admin.recordFailure("client-0", later)
+	if attempts := admin.clients["client-0"]; attempts.failed != 1 || !attempts.lockedUntil.IsZero() {
+		t.Fatalf("expected a stale failure to be forgotten, got %+v", attempts)
+	}
+
+	admin.recordFailure("newcomer", later)
+	if len(admin.clients) != 3 {
+		t.Fatalf("expected only recent and locked clients to be tracked, got %d", len(admin.clients))
+	}
+	if !admin.locked("locked", later) {
+		t.Fatal("expected the locked client to stay locked")
+	}
+}
+
+func TestPlatformAdminEvictsLockedClients(t *testing.T) {
+	admin, err := NewPlatformAdmin("root", "", TenantUserConfig{MaxFailedLogins: 1, LockoutDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every client is locked out, each a second after the one before
+	now := time.Now()
+	for i := range maxTrackedClients {
+		admin.recordFailure(fmt.Sprintf("client-%d", i), now.Add(time.Duration(i)*time.Second))
+	}
+	later := now.Add(time.Duration(maxTrackedClients) * time.Second)
+	for i := range 10 {
+		admin.recordFailure(fmt.Sprintf("rotated-%d", i), later)
+	}
+
+	if len(admin.clients) != maxTrackedClients {
+		t.Fatalf("expected at most %d tracked clients, got %d", maxTrackedClients, len(admin.clients))
+	}
+	// The lockouts ending first made room
+	for i := range 10 {
+		if _, ok := admin.clients[fmt.Sprintf("client-%d", i)]; ok {
+			t.Fatalf("expected client-%d to be evicted", i)
+		}
+	}
+	if !admin.locked(fmt.Sprintf("client-%d", maxTrackedClients-1), later) || !admin.locked("rotated-9", later) {
+		t.Fatal("expected the later lockouts to be kept")
+	}
+}
+
+func TestPlatformAdminWithoutPassword(t *testing.T) {
+	if _, err := NewPlatformAdmin("root", "not a hash", TenantUserConfig{}); err == nil {
+		t.Fatal("accepted a password hash that is not bcrypt")
+	}
+
+	admin, err := NewPlatformAdmin("root", "", TenantUserConfig{MaxFailedLogins: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Authenticate("10.0.0.1", "root", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidCredentials)
+	}
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresBroker uses each tenant's messages partition as its queue. Workers
+// claim rows with FOR UPDATE SKIP LOCKED and hold them under a lease that
+// expires after the visibility timeout unless it is renewed.
+type PostgresBroker struct {
+	db                *sql.DB
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+	retryDelay        time.Duration
+}
+
+var _ Broker = (*PostgresBroker)(nil)
+
+func NewPostgresBroker(db *sql.DB, visibilityTimeout, pollInterval, retryDelay time.Duration) *PostgresBroker {
+	return &PostgresBroker{
+		db:                db,
+		visibilityTimeout: visibilityTimeout,
+		pollInterval:      pollInterval,
+		retryDelay:        retryDelay,
+	}
+}
+
+// CreateTenantQueue is a no-op; the queue is the tenant's messages partition.
+func (b *PostgresBroker) CreateTenantQueue(tenantID string) error {
+	return nil
+}
+
+// DeleteTenantQueue is a no-op; the partition is kept with the soft-deleted
+// tenant.
+func (b *PostgresBroker) DeleteTenantQueue(tenantID string) error {
+	return nil
+}
+
+// MessageCount returns the number of messages ready to be claimed.
+func (b *PostgresBroker) MessageCount(tenantID string) (int, error) {
+	var count int
+	err := b.db.QueryRow(`
+        SELECT COUNT(*) FROM messages
+        WHERE tenant_id = $1 AND status = 'pending' AND visible_at <= NOW()
+    `, tenantID).Scan(&count)
+
+	return count, err
+}
+
+// PublishMessageWithHeaders makes a message visible to consumers. The row is
+// normally inserted by MessageService already, so this only reopens it when
+// it has never been published or has failed. Duplicate publishes from the
+// outbox are therefore harmless.
+func (b *PostgresBroker) PublishMessageWithHeaders(ctx context.Context, tenantID string, payload []byte, headers map[string]any) error {
+	messageID, _ := headers["message_id"].(string)
+	if messageID == "" {
+		return fmt.Errorf("message_id header is required")
+	}
+
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO messages (id, tenant_id, payload, headers, status, retry_count, priority, visible_at)
+        VALUES ($1, $2, $3, $4, 'pending', $5, $6, NOW())
+        ON CONFLICT (id, tenant_id) DO UPDATE
+        SET payload = EXCLUDED.payload, headers = EXCLUDED.headers, status = 'pending',
+            retry_count = EXCLUDED.retry_count, priority = EXCLUDED.priority,
+            visible_at = EXCLUDED.visible_at, lease_token = NULL
+        WHERE messages.visible_at IS NULL AND messages.lease_token IS NULL
+            OR messages.status = 'failed'
+    `
+	_, err = b.db.ExecContext(ctx, query, messageID, tenantID, payload, headersJSON, headerInt(headers, "retry_count"), messagePriority(headers))
+	if err != nil {
+		return fmt.Errorf("failed to publish message to postgres queue: %w", err)
+	}
+
+	return nil
+}
+
+func (b *PostgresBroker) Consume(tenantID string, prefetch int) (Subscription, error) {
+	sub := &postgresSubscription{
+		broker:     b,
+		tenantID:   tenantID,
+		prefetch:   prefetch,
+		deliveries: make(chan Delivery),
+		inflight:   make(map[string]string),
+		done:       make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+
+	go sub.poll()
+
+	return sub, nil
+}
+
+type postgresSubscription struct {
+	broker     *PostgresBroker
+	tenantID   string
+	deliveries chan Delivery
+	// prefetch caps inflight
+	prefetch int
+	// inflight maps lease token to message ID for every unsettled delivery
+	inflight  map[string]string
+	mutex     sync.Mutex
+	done      chan struct{}
+	finished  chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *postgresSubscription) Deliveries() <-chan Delivery {
+	return s.deliveries
+}
+
+// SetPrefetch takes effect with the next claim; leases above the new limit
+// are kept until settled.
+func (s *postgresSubscription) SetPrefetch(prefetch int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prefetch = prefetch
+	return nil
+}
+
+// Close stops claiming and releases every unsettled lease so the messages
+// become visible to other consumers right away.
+func (s *postgresSubscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.finished
+
+		s.mutex.Lock()
+		tokens := make([]string, 0, len(s.inflight))
+		for token := range s.inflight {
+			tokens = append(tokens, token)
+		}
+		s.inflight = make(map[string]string)
+		s.mutex.Unlock()
+
+		if len(tokens) == 0 {
+			return
+		}
+
+		_, err = s.broker.db.Exec(`
+            UPDATE messages
+            SET status = 'pending', lease_token = NULL, visible_at = NOW()
+            WHERE tenant_id = $1 AND lease_token = ANY($2)
+        `, s.tenantID, pq.Array(tokens))
+	})
+
+	return err
+}
+
+// poll claims messages while prefetch allows. Leases are renewed alongside,
+// so that they are kept while poll waits for a busy worker to take a
+// delivery or for a lease to be settled.
+func (s *postgresSubscription) poll() {
+	defer close(s.finished)
+	defer close(s.deliveries)
+
+	renewing := make(chan struct{})
+	go func() {
+		defer close(renewing)
+		s.renew()
+	}()
+	// Close releases the leases only once renewals stopped
+	defer func() { <-renewing }()
+
+	ticker := time.NewTicker(s.broker.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed := 0
+
+		s.mutex.Lock()
+		free := s.prefetch - len(s.inflight)
+		s.mutex.Unlock()
+
+		if free > 0 {
+			deliveries, err := s.claim(free)
+			if err != nil {
+				log.Printf("Failed to claim messages for tenant %s: %v", s.tenantID, err)
+			}
+
+			for _, delivery := range deliveries {
+				select {
+				case s.deliveries <- delivery:
+					claimed++
+				case <-s.done:
+					return
+				}
+			}
+		}
+
+		// Keep claiming while the partition has work and prefetch allows
+		if claimed > 0 && claimed == free {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew extends the unsettled leases every half visibility timeout until
+// the subscription is closed.
+func (s *postgresSubscription) renew() {
+	ticker := time.NewTicker(s.broker.visibilityTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.renewLeases()
+		}
+	}
+}
+
+// claim leases up to limit visible messages, highest priority first. Expired
+// leases of crashed consumers are reclaimed the same way.
+func (s *postgresSubscription) claim(limit int) ([]Delivery, error) {
+	query := `
+        WITH claimable AS (
+            SELECT id FROM messages
+            WHERE tenant_id = $1 AND visible_at <= NOW()
+                AND (status = 'pending' OR (status = 'processing' AND lease_token IS NOT NULL))
+            ORDER BY priority DESC, visible_at ASC
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+        )
+        UPDATE messages m
+        SET status = 'processing', lease_token = gen_random_uuid(),
+            visible_at = NOW() + $3 * INTERVAL '1 millisecond', delivery_count = m.delivery_count + 1
+        FROM claimable
+        WHERE m.tenant_id = $1 AND m.id = claimable.id
+        RETURNING m.id, m.payload, m.headers, m.lease_token, m.delivery_count, m.priority, m.retry_count
+    `
+	rows, err := s.broker.db.Query(query, s.tenantID, limit, s.broker.visibilityTimeout.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var (
+			messageID     string
+			payload       []byte
+			headersJSON   []byte
+			leaseToken    string
+			deliveryCount int
+			priority      int
+			retryCount    int
+		)
+		if err := rows.Scan(&messageID, &payload, &headersJSON, &leaseToken, &deliveryCount, &priority, &retryCount); err != nil {
+			return nil, err
+		}
+
+		headers := make(map[string]any)
+		if len(headersJSON) > 0 {
+			json.Unmarshal(headersJSON, &headers)
+		}
+		headers["message_id"] = messageID
+		headers["tenant_id"] = s.tenantID
+
+		s.mutex.Lock()
+		s.inflight[leaseToken] = messageID
+		s.mutex.Unlock()
+
+		deliveries = append(deliveries, Delivery{
+			Body:         payload,
+			Headers:      headers,
+			ContentType:  "application/json",
+			Redelivered:  deliveryCount > 1,
+			Priority:     priority,
+			RetryCount:   retryCount,
+			acknowledger: &postgresAcknowledger{subscription: s, leaseToken: leaseToken},
+		})
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *postgresSubscription) renewLeases() {
+	s.mutex.Lock()
+	tokens := make([]string, 0, len(s.inflight))
+	for token := range s.inflight {
+		tokens = append(tokens, token)
+	}
+	s.mutex.Unlock()
+
+	if len(tokens) == 0 {
+		return
+	}
+
+	_, err := s.broker.db.Exec(`
+        UPDATE messages
+        SET visible_at = NOW() + $3 * INTERVAL '1 millisecond'
+        WHERE tenant_id = $1 AND lease_token = ANY($2)
+    `, s.tenantID, pq.Array(tokens), s.broker.visibilityTimeout.Milliseconds())
+	if err != nil {
+		log.Printf("Failed to renew leases for tenant %s: %v", s.tenantID, err)
+	}
+}
+
+type postgresAcknowledger struct {
+	subscription *postgresSubscription
+	leaseToken   string
+}
+
+// settle applies update to the leased row. It fails when the lease was lost,
+// e.g. because it expired and another consumer reclaimed the message.
+func (a *postgresAcknowledger) settle(update string) error {
+	s := a.subscription
+
+	s.mutex.Lock()
+	delete(s.inflight, a.leaseToken)
+	s.mutex.Unlock()
+
+	result, err := s.broker.db.Exec(update, s.tenantID, a.leaseToken)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("lease %s no longer held", a.leaseToken)
+	}
+	return nil
+}
+
+// Ack releases the lease. The message status itself is owned by the
+// processing code, which marks it completed or failed.
+func (a *postgresAcknowledger) Ack() error {
+	return a.settle(`
+        UPDATE messages SET lease_token = NULL
+        WHERE tenant_id = $1 AND lease_token = $2
+    `)
+}
+
+func (a *postgresAcknowledger) Nack(requeue bool) error {
+	if requeue {
+		return a.settle(`
+            UPDATE messages SET status = 'pending', lease_token = NULL, visible_at = NOW()
+            WHERE tenant_id = $1 AND lease_token = $2
+        `)
+	}
+
+	return a.settle(`
+        UPDATE messages SET status = 'failed', lease_token = NULL
+        WHERE tenant_id = $1 AND lease_token = $2
+    `)
+}
+
+// Retry keeps the row pending but invisible until the retry delay has
+// passed, and counts the attempt in retry_count.
+func (a *postgresAcknowledger) Retry() error {
+	return a.settle(fmt.Sprintf(`
+        UPDATE messages
+        SET status = 'pending', lease_token = NULL, retry_count = retry_count + 1,
+            visible_at = NOW() + %d * INTERVAL '1 millisecond'
+        WHERE tenant_id = $1 AND lease_token = $2
+    `, a.subscription.broker.retryDelay.Milliseconds()))
+}
+
+// headerInt reads an integer header regardless of how it was decoded: AMQP
+// tables yield int32/int64 while JSON yields float64.
+func headerInt(headers map[string]any, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
+)
+
+// ProcessingLog records handler delivery attempts in message_processing_logs.
+type ProcessingLog struct {
+	db *sql.DB
+}
+
+var _ processor.AttemptRecorder = (*ProcessingLog)(nil)
+
+func NewProcessingLog(db *sql.DB) *ProcessingLog {
+	return &ProcessingLog{db: db}
+}
+
+// RecordAttempt logs the attempt with status <channel>_<status>, e.g.
+// webhook_failed, along with the response status and latency.
+func (l *ProcessingLog) RecordAttempt(ctx context.Context, msg *processor.Message, attempt processor.Attempt) {
+	query := `
+        INSERT INTO message_processing_logs
+        (message_id, tenant_id, status, error_message, processing_duration_ms, response_status)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	_, err := l.db.ExecContext(ctx, query, msg.ID, msg.TenantID, attempt.Channel+"_"+attempt.Status,
+		sql.NullString{String: attempt.Error, Valid: attempt.Error != ""},
+		attempt.Latency.Milliseconds(),
+		sql.NullInt64{Int64: int64(attempt.ResponseStatus), Valid: attempt.ResponseStatus != 0})
+	if err != nil {
+		log.Printf("Failed to record %s attempt for message %s: %v", attempt.Channel, msg.ID, err)
+	}
+}
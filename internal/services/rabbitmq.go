@@ -2,63 +2,841 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	ErrUnroutable = errors.New("message returned by broker as unroutable")
+	ErrNacked     = errors.New("message nacked by broker")
+)
+
+var _ Broker = (*RabbitMQService)(nil)
+
 type RabbitMQService struct {
-	conn *amqp.Connection
-	ch   *amqp.Channel
+	conn  *amqp.Connection
+	mutex sync.RWMutex
+
+	// retryDelay is the TTL of the tenant delay queues
+	retryDelay time.Duration
+
+	// publishers holds idle publishing channels. Each publish has one to
+	// itself, so a basic.return, which the broker always sends ahead of the
+	// confirm, is in the channel's returns buffer once the confirm arrives.
+	publishers chan *publishChannel
+
+	// queueGates hold back publishes to a tenant queue while it is deleted
+	// and redeclared
+	gatesMutex sync.Mutex
+	queueGates map[string]*sync.RWMutex
+
+	// outdated holds the tenant queues declared before the retry topology,
+	// which lack the dead-letter exchange rejected messages are retried
+	// through
+	outdatedMutex sync.Mutex
+	outdated      map[string]bool
+}
+
+// publishChannel is a channel in confirm mode with room for the return of
+// its one outstanding publish.
+type publishChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
 }
 
-func NewRabbitMQService(conn *amqp.Connection, ch *amqp.Channel) *RabbitMQService {
-	return &RabbitMQService{conn: conn, ch: ch}
+// maxIdlePublishers caps the publishing channels kept open between
+// publishes.
+const maxIdlePublishers = 16
+
+// NewRabbitMQService puts ch into confirm mode so every publish is
+// acknowledged by the broker before it is reported as sent. Failed messages
+// are retried after retryDelay.
+func NewRabbitMQService(conn *amqp.Connection, ch *amqp.Channel, retryDelay time.Duration) (*RabbitMQService, error) {
+	r := &RabbitMQService{
+		conn:       conn,
+		retryDelay: retryDelay,
+		publishers: make(chan *publishChannel, maxIdlePublishers),
+		queueGates: make(map[string]*sync.RWMutex),
+		outdated:   make(map[string]bool),
+	}
+
+	publisher, err := newPublishChannel(ch)
+	if err != nil {
+		return nil, err
+	}
+	r.releasePublisher(publisher)
+
+	return r, nil
 }
 
+// Reset swaps in a freshly reconnected connection. Publishing channels of
+// the old connection are dropped.
+func (r *RabbitMQService) Reset(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	publisher, err := newPublishChannel(ch)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.conn = conn
+	r.mutex.Unlock()
+
+drain:
+	for {
+		select {
+		case idle := <-r.publishers:
+			idle.ch.Close()
+		default:
+			break drain
+		}
+	}
+	r.releasePublisher(publisher)
+
+	return nil
+}
+
+func newPublishChannel(ch *amqp.Channel) (*publishChannel, error) {
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	return &publishChannel{ch: ch, returns: ch.NotifyReturn(make(chan amqp.Return, 1))}, nil
+}
+
+// takePublisher returns an idle publishing channel, or opens a new one.
+// Channels closed by an error since they were released are skipped.
+func (r *RabbitMQService) takePublisher() (*publishChannel, error) {
+	for {
+		select {
+		case publisher := <-r.publishers:
+			if publisher.ch.IsClosed() {
+				continue
+			}
+			return publisher, nil
+		default:
+		}
+
+		ch, err := r.connection().Channel()
+		if err != nil {
+			return nil, err
+		}
+		return newPublishChannel(ch)
+	}
+}
+
+// releasePublisher keeps a channel whose publish was settled for the next
+// one. A channel with a publish still outstanding must be closed instead, or
+// its late return would be taken for the next publish's.
+func (r *RabbitMQService) releasePublisher(publisher *publishChannel) {
+	if publisher.ch.IsClosed() {
+		return
+	}
+
+	select {
+	case r.publishers <- publisher:
+	default:
+		publisher.ch.Close()
+	}
+}
+
+// queueGate returns the lock publishes to queueName read-hold while they
+// wait for their confirm.
+func (r *RabbitMQService) queueGate(queueName string) *sync.RWMutex {
+	r.gatesMutex.Lock()
+	defer r.gatesMutex.Unlock()
+
+	gate, ok := r.queueGates[queueName]
+	if !ok {
+		gate = &sync.RWMutex{}
+		r.queueGates[queueName] = gate
+	}
+
+	return gate
+}
+
+// forgetQueueGate drops the gate of a deleted queue, so that gates of
+// deleted tenants do not pile up.
+func (r *RabbitMQService) forgetQueueGate(queueName string) {
+	r.gatesMutex.Lock()
+	defer r.gatesMutex.Unlock()
+
+	delete(r.queueGates, queueName)
+}
+
+// setOutdated records whether queueName lacks its dead-letter exchange.
+func (r *RabbitMQService) setOutdated(queueName string, outdated bool) {
+	r.outdatedMutex.Lock()
+	defer r.outdatedMutex.Unlock()
+
+	if outdated {
+		r.outdated[queueName] = true
+	} else {
+		delete(r.outdated, queueName)
+	}
+}
+
+func (r *RabbitMQService) isOutdated(queueName string) bool {
+	r.outdatedMutex.Lock()
+	defer r.outdatedMutex.Unlock()
+
+	return r.outdated[queueName]
+}
+
+func (r *RabbitMQService) connection() *amqp.Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.conn
+}
+
+func tenantQueueName(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_queue", tenantID)
+}
+
+func retryExchangeName(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_retry", tenantID)
+}
+
+func retryQueueName(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_retry_queue", tenantID)
+}
+
+// tenantQueueArgs makes the tenant queue a priority queue whose rejected
+// messages are dead-lettered to the tenant's retry exchange.
+func tenantQueueArgs(tenantID string) amqp.Table {
+	return amqp.Table{
+		"x-max-priority":         MaxPriority,
+		"x-dead-letter-exchange": retryExchangeName(tenantID),
+	}
+}
+
+// CreateTenantQueue declares the tenant queue together with its retry
+// topology: rejected messages go through the retry exchange into a delay
+// queue, whose TTL dead-letters them back to the tenant queue. Queues
+// declared before this topology existed cannot be redeclared with different
+// arguments, so they keep working as they are until MigrateTenantQueue
+// rebuilds them; meanwhile their consumers publish retries into the delay
+// queue themselves.
 func (r *RabbitMQService) CreateTenantQueue(tenantID string) error {
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
+	if err := r.declareRetryTopology(tenantID); err != nil {
+		return err
+	}
+
+	queueName := tenantQueueName(tenantID)
+	outdated, err := r.declareQueue(queueName, tenantQueueArgs(tenantID))
+	if err != nil {
+		return err
+	}
+
+	r.setOutdated(queueName, outdated)
+	if outdated {
+		log.Printf("Queue %s was declared with outdated arguments, priorities and delayed retries are unavailable until it is migrated", queueName)
+	}
+
+	return nil
+}
+
+func (r *RabbitMQService) declareRetryTopology(tenantID string) error {
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	exchange := retryExchangeName(tenantID)
+	if err := ch.ExchangeDeclare(exchange, "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
 
-	_, err := r.ch.QueueDeclare(
+	delayQueue := retryQueueName(tenantID)
+	outdated, err := r.declareQueue(delayQueue, amqp.Table{
+		"x-message-ttl":             r.retryDelay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": tenantQueueName(tenantID),
+	})
+	if err != nil {
+		return err
+	}
+
+	if outdated {
+		log.Printf("Queue %s keeps its previous retry delay; delete it to apply %s", delayQueue, r.retryDelay)
+	}
+
+	return ch.QueueBind(delayQueue, "", exchange, false, nil)
+}
+
+// declareQueue declares a durable queue on a throwaway channel, because the
+// PRECONDITION_FAILED caused by mismatching arguments closes the channel it
+// was sent on. It reports whether the queue already exists with different
+// arguments.
+func (r *RabbitMQService) declareQueue(queueName string, args amqp.Table) (bool, error) {
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
 		false,     // exclusive
 		false,     // no-wait
-		nil,       // arguments
+		args,      // arguments
 	)
 
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// MigrateTenantQueue rebuilds a tenant queue declared with outdated
+// arguments. The backlog is moved to a staging priority queue, the old queue
+// is deleted and redeclared with the current arguments, and the backlog is
+// moved back. The delete is refused while the queue still has consumers or
+// messages, so it must run before any consumer attaches; on failure the old
+// queue is left in place. A migration interrupted after the redeclare is
+// finished on the next call.
+func (r *RabbitMQService) MigrateTenantQueue(tenantID string) error {
+	queueName := tenantQueueName(tenantID)
+	stagingName := queueName + "_migration"
+
+	outdated, err := r.declareQueue(queueName, tenantQueueArgs(tenantID))
+	if err != nil {
+		return err
+	}
+
+	if outdated {
+		if _, err := r.declareQueue(stagingName, amqp.Table{"x-max-priority": MaxPriority}); err != nil {
+			return err
+		}
+
+		if err := r.replaceQueue(tenantID, stagingName); err != nil {
+			return err
+		}
+	}
+	r.setOutdated(queueName, false)
+
+	if !outdated && !r.queueExists(stagingName) {
+		return nil
+	}
+
+	moved, err := r.moveMessages(stagingName, queueName)
+	if err != nil {
+		return fmt.Errorf("failed to restore messages from %s: %w", stagingName, err)
+	}
+
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDelete(stagingName, false, true, false); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", stagingName, err)
+	}
+
+	log.Printf("Queue %s migrated, %d messages carried over", queueName, moved)
+	return nil
+}
+
+// replaceQueue empties the tenant queue into stagingName, then deletes and
+// redeclares it. Publishes from this process wait while the queue is briefly
+// missing; a message published elsewhere in between makes the delete fail,
+// in which case the move is repeated. If the queue cannot be recreated, the
+// backlog is moved back before the error is returned.
+func (r *RabbitMQService) replaceQueue(tenantID, stagingName string) error {
+	const attempts = 3
+
+	queueName := tenantQueueName(tenantID)
+	for attempt := 1; ; attempt++ {
+		if _, err := r.moveMessages(queueName, stagingName); err != nil {
+			return fmt.Errorf("failed to move messages to %s: %w", stagingName, err)
+		}
+
+		err := r.recreateQueue(queueName, tenantQueueArgs(tenantID))
+		if err == nil {
+			return nil
+		}
+
+		if attempt == attempts {
+			if restoreErr := r.restoreQueue(tenantID, stagingName); restoreErr != nil {
+				return errors.Join(err, restoreErr)
+			}
+			return err
+		}
+		log.Printf("Failed to recreate queue %s, retrying, err: %s", queueName, err.Error())
+	}
+}
+
+// restoreQueue moves the backlog of a failed replaceQueue back from
+// stagingName, declaring the tenant queue again if the failure left it
+// deleted.
+func (r *RabbitMQService) restoreQueue(tenantID, stagingName string) error {
+	queueName := tenantQueueName(tenantID)
+	if !r.queueExists(queueName) {
+		if _, err := r.declareQueue(queueName, tenantQueueArgs(tenantID)); err != nil {
+			return fmt.Errorf("failed to redeclare %s: %w", queueName, err)
+		}
+	}
+
+	if _, err := r.moveMessages(stagingName, queueName); err != nil {
+		return fmt.Errorf("failed to restore messages from %s: %w", stagingName, err)
+	}
+
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDelete(stagingName, false, true, false)
 	return err
 }
 
-func (r *RabbitMQService) DeleteTenantQueue(tenantID string) error {
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
-	_, err := r.ch.QueueDelete(queueName, false, false, false)
+// recreateQueue deletes and redeclares queueName. Only publishes to that
+// queue wait meanwhile.
+func (r *RabbitMQService) recreateQueue(queueName string, args amqp.Table) error {
+	gate := r.queueGate(queueName)
+	gate.Lock()
+	defer gate.Unlock()
+
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDelete(queueName, true, true, false); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", queueName, err)
+	}
+
+	_, err = ch.QueueDeclare(queueName, true, false, false, false, args)
 	return err
 }
 
+func (r *RabbitMQService) queueExists(queueName string) bool {
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return false
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclarePassive(queueName, true, false, false, false, nil)
+	return err == nil
+}
+
+// MessageCount returns the number of ready messages in the tenant queue.
+func (r *RabbitMQService) MessageCount(tenantID string) (int, error) {
+	// A passive declare of a missing queue closes the channel it runs on
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	queue, err := ch.QueueDeclarePassive(tenantQueueName(tenantID), true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return queue.Messages, nil
+}
+
+// moveMessages transfers every ready message from src to dst. A message is
+// acked on src only after the broker confirmed its copy on dst, so a failure
+// part way leaves each message in at least one of the two queues.
+func (r *RabbitMQService) moveMessages(src, dst string) (int, error) {
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return 0, err
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	moved := 0
+	for {
+		msg, ok, err := ch.Get(src, false)
+		if err != nil {
+			return moved, err
+		}
+		if !ok {
+			return moved, nil
+		}
+
+		confirm, err := ch.PublishWithDeferredConfirm("", dst, true, false, amqp.Publishing{
+			Headers:      msg.Headers,
+			ContentType:  msg.ContentType,
+			DeliveryMode: msg.DeliveryMode,
+			Priority:     uint8(messagePriority(msg.Headers)),
+			Body:         msg.Body,
+		})
+		if err != nil {
+			msg.Nack(false, true)
+			return moved, err
+		}
+
+		acked := confirm.Wait()
+		select {
+		case ret := <-returns:
+			msg.Nack(false, true)
+			return moved, fmt.Errorf("%w: %s (queue %s)", ErrUnroutable, ret.ReplyText, dst)
+		default:
+		}
+		if !acked {
+			msg.Nack(false, true)
+			return moved, fmt.Errorf("%w: queue %s", ErrNacked, dst)
+		}
+
+		if err := msg.Ack(false); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+}
+
+func (r *RabbitMQService) DeleteTenantQueue(tenantID string) error {
+	queueName := tenantQueueName(tenantID)
+	defer r.forgetQueueGate(queueName)
+	defer r.setOutdated(queueName, false)
+
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDelete(queueName, false, false, false); err != nil {
+		return err
+	}
+
+	if _, err := ch.QueueDelete(retryQueueName(tenantID), false, false, false); err != nil {
+		return err
+	}
+
+	return ch.ExchangeDelete(retryExchangeName(tenantID), false, false)
+}
+
 func (r *RabbitMQService) PublishMessageWithHeaders(ctx context.Context, tenantID string, payload []byte, headers map[string]any) error {
 	ctxTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
-	err := r.ch.PublishWithContext(ctxTimeout,
+	table := amqp.Table{}
+	for k, v := range headers {
+		table[k] = v
+	}
+
+	queueName := tenantQueueName(tenantID)
+	gate := r.queueGate(queueName)
+	gate.RLock()
+	defer gate.RUnlock()
+
+	return r.publish(ctxTimeout, queueName, amqp.Publishing{
+		ContentType: fiber.MIMEApplicationJSON,
+		Priority:    uint8(messagePriority(headers)),
+		Body:        payload,
+		Headers:     table,
+	})
+}
+
+// publishRetry stands in for the missing dead-letter exchange of an
+// outdated tenant queue. It publishes a retried message into the tenant's
+// delay queue, which dead-letters it back to the tenant queue once the
+// retry delay passed. The broker does not count these retries, so the count
+// travels in the retry_count header.
+func (r *RabbitMQService) publishRetry(tenantID string, msg amqp.Delivery, retryCount int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["retry_count"] = retryCount + 1
+
+	return r.publish(ctx, retryQueueName(tenantID), amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: msg.DeliveryMode,
+		Priority:     msg.Priority,
+		Body:         msg.Body,
+	})
+}
+
+// publish sends msg to queueName through the default exchange and waits for
+// the broker to confirm it.
+func (r *RabbitMQService) publish(ctx context.Context, queueName string, msg amqp.Publishing) error {
+	publisher, err := r.takePublisher()
+	if err != nil {
+		log.Printf("Failed to open a publishing channel, err: %s", err.Error())
+		return err
+	}
+
+	confirm, err := publisher.ch.PublishWithDeferredConfirmWithContext(ctx,
 		"",        // exchange
 		queueName, // routing key
-		false,     // mandatory
+		true,      // mandatory
 		false,
-		amqp.Publishing{
-			ContentType: fiber.MIMEApplicationJSON,
-			Body:        payload,
-			Headers:     headers,
-		})
+		msg)
 	if err != nil {
+		publisher.ch.Close()
 		log.Printf("Failed to publish a message, err: %s", err.Error())
 		return err
 	}
-	log.Printf(" [x] Sent %s", payload)
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		publisher.ch.Close()
+		return fmt.Errorf("waiting for publisher confirm: %w", err)
+	}
+
+	// The return, if any, was buffered before the confirm was dispatched
+	select {
+	case ret := <-publisher.returns:
+		r.releasePublisher(publisher)
+		log.Printf("Message returned by broker, routing key: %s, reason: %s", ret.RoutingKey, ret.ReplyText)
+		return fmt.Errorf("%w: %s (queue %s)", ErrUnroutable, ret.ReplyText, queueName)
+	default:
+	}
+	r.releasePublisher(publisher)
+
+	if !acked {
+		return fmt.Errorf("%w: queue %s", ErrNacked, queueName)
+	}
+
+	return nil
+}
+
+func (r *RabbitMQService) Consume(tenantID string, prefetch int) (Subscription, error) {
+	ch, err := r.connection().Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &rabbitMQSubscription{
+		ch:          ch,
+		queueName:   tenantQueueName(tenantID),
+		consumerTag: fmt.Sprintf("consumer_%s", tenantID),
+		deliveries:  make(chan Delivery),
+		next:        make(chan (<-chan amqp.Delivery), 1),
+		done:        make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+	if r.isOutdated(sub.queueName) {
+		sub.retry = func(msg amqp.Delivery, retryCount int) error {
+			return r.publishRetry(tenantID, msg, retryCount)
+		}
+	}
+
+	msgs, err := sub.consume(prefetch)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	go sub.forward(msgs)
+
+	return sub, nil
+}
+
+type rabbitMQSubscription struct {
+	ch          *amqp.Channel
+	queueName   string
+	consumerTag string
+	deliveries  chan Delivery
+	// retry publishes a retried message into the delay queue when the
+	// tenant queue is outdated, and is nil otherwise
+	retry func(msg amqp.Delivery, retryCount int) error
+	// next hands forward the deliveries of the consumer SetPrefetch started
+	// in place of the canceled one, or nil if that failed
+	next      chan (<-chan amqp.Delivery)
+	restarts  atomic.Int32
+	mutex     sync.Mutex
+	done      chan struct{}
+	finished  chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *rabbitMQSubscription) Deliveries() <-chan Delivery {
+	return s.deliveries
+}
+
+func (s *rabbitMQSubscription) consume(prefetch int) (<-chan amqp.Delivery, error) {
+	if err := s.ch.Qos(prefetch, 0, false); err != nil {
+		return nil, err
+	}
+
+	return s.ch.Consume(
+		s.queueName,   // queue
+		s.consumerTag, // consumer
+		false,         // auto-ack
+		false,         // exclusive
+		false,         // no-local
+		false,         // no-wait
+		nil,           // args
+	)
+}
+
+// SetPrefetch restarts the consumer with the new prefetch, as RabbitMQ only
+// applies it to consumers started afterwards. Deliveries of the canceled
+// consumer stay on the channel, so they are still processed and acked.
+func (s *rabbitMQSubscription) SetPrefetch(prefetch int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.restarts.Add(1)
+	if err := s.ch.Cancel(s.consumerTag, false); err != nil {
+		s.handOver(nil)
+		return err
+	}
+
+	msgs, err := s.consume(prefetch)
+	if err != nil {
+		s.handOver(nil)
+		return err
+	}
+	s.handOver(msgs)
+
 	return nil
 }
+
+func (s *rabbitMQSubscription) handOver(msgs <-chan amqp.Delivery) {
+	select {
+	case s.next <- msgs:
+	case <-s.done:
+	case <-s.finished:
+	}
+}
+
+// Close closes the consumer channel; unacked deliveries are requeued by the
+// broker.
+func (s *rabbitMQSubscription) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.ch.Close()
+}
+
+func (s *rabbitMQSubscription) forward(msgs <-chan amqp.Delivery) {
+	defer close(s.finished)
+	defer close(s.deliveries)
+
+	var restarts int32
+	for msgs != nil {
+		if !s.forwardConsumer(msgs) {
+			return
+		}
+		msgs = nil
+
+		// A consumer canceled by SetPrefetch is followed by its replacement
+		if s.restarts.Load() > restarts {
+			restarts++
+			select {
+			case msgs = <-s.next:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// forwardConsumer forwards the deliveries of one consumer until they end,
+// or returns false once the subscription is closed.
+func (s *rabbitMQSubscription) forwardConsumer(msgs <-chan amqp.Delivery) bool {
+	for msg := range msgs {
+		// Retries published by publishRetry count too, e.g. of a message
+		// carried over by a migration
+		retryCount := deathCount(msg.Headers, s.queueName, "rejected") + headerInt(msg.Headers, "retry_count")
+		acknowledger := &rabbitMQAcknowledger{delivery: msg}
+		if s.retry != nil {
+			acknowledger.retry = func() error { return s.retry(msg, retryCount) }
+		}
+
+		delivery := Delivery{
+			Body:         msg.Body,
+			Headers:      msg.Headers,
+			ContentType:  msg.ContentType,
+			Redelivered:  msg.Redelivered,
+			Priority:     int(msg.Priority),
+			RetryCount:   retryCount,
+			acknowledger: acknowledger,
+		}
+
+		select {
+		case s.deliveries <- delivery:
+		case <-s.done:
+			return false
+		}
+	}
+
+	return true
+}
+
+type rabbitMQAcknowledger struct {
+	delivery amqp.Delivery
+	// retry replaces dead-lettering on outdated tenant queues
+	retry func() error
+}
+
+func (a *rabbitMQAcknowledger) Ack() error {
+	return a.delivery.Ack(false)
+}
+
+// Nack without requeue dead-letters the message into the tenant's retry
+// exchange, the same as Retry.
+func (a *rabbitMQAcknowledger) Nack(requeue bool) error {
+	if !requeue {
+		return a.Retry()
+	}
+	return a.delivery.Nack(false, true)
+}
+
+// Retry rejects the message so the broker routes it through the delay queue
+// and back to the tenant queue once its TTL expires. Nothing is held in
+// memory meanwhile, so a worker crash cannot lose it. An outdated tenant
+// queue would drop a rejected message instead, so there it is published
+// into the delay queue before it is acked.
+func (a *rabbitMQAcknowledger) Retry() error {
+	if a.retry == nil {
+		return a.delivery.Nack(false, false)
+	}
+
+	if err := a.retry(); err != nil {
+		return err
+	}
+	return a.delivery.Ack(false)
+}
+
+// deathCount returns how often the broker dead-lettered a message out of
+// queue for reason, as recorded in its x-death header. The broker owns this
+// header, so unlike a counter kept by the publisher it survives restarts and
+// republishing.
+func deathCount(headers amqp.Table, queue, reason string) int {
+	deaths, _ := headers["x-death"].([]any)
+
+	for _, death := range deaths {
+		entry, ok := death.(amqp.Table)
+		if !ok || entry["queue"] != queue || entry["reason"] != reason {
+			continue
+		}
+		return headerInt(entry, "count")
+	}
+
+	return 0
+}
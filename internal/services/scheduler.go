@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	schedulerPollInterval = 1 * time.Second
+	schedulerBatchSize    = 100
+)
+
+// Scheduler releases scheduled messages into the outbox once they are due.
+// Due rows are claimed with SKIP LOCKED and flipped to pending in the same
+// transaction that writes the outbox entry, so several scheduler instances
+// can run side by side without releasing a message twice.
+type Scheduler struct {
+	db     *sql.DB
+	outbox *OutboxRelay
+}
+
+func NewScheduler(db *sql.DB, outbox *OutboxRelay) *Scheduler {
+	return &Scheduler{db: db, outbox: outbox}
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("scheduler has stopped")
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := s.ReleaseDue(ctx)
+			if err != nil {
+				log.Printf("scheduler release failed, err: %s", err.Error())
+				break
+			}
+			if n < schedulerBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// ReleaseDue releases one batch of due messages and returns how many it
+// released.
+func (s *Scheduler) ReleaseDue(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Messages of tenants that reject publishes while suspended stay
+	// scheduled until the tenant is resumed. The tenant rows stay locked
+	// like on publish, so a concurrent suspend applies before or after.
+	query := `
+        SELECT m.id, m.tenant_id, m.payload, m.headers
+        FROM messages m
+        JOIN tenants t ON t.id = m.tenant_id
+        WHERE m.status = 'scheduled' AND m.scheduled_at <= NOW()
+        AND NOT (` + rejectsPublishes + `)
+        ORDER BY m.scheduled_at ASC
+        LIMIT $1
+        FOR UPDATE OF m SKIP LOCKED
+        FOR SHARE OF t
+    `
+	rows, err := tx.QueryContext(ctx, query, schedulerBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type dueMessage struct {
+		id       string
+		tenantID string
+		payload  []byte
+		headers  map[string]any
+	}
+
+	var due []dueMessage
+	for rows.Next() {
+		var msg dueMessage
+		var headersJSON []byte
+		if err := rows.Scan(&msg.id, &msg.tenantID, &msg.payload, &headersJSON); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		msg.headers = scheduledHeaders(headersJSON, msg.id, msg.tenantID)
+		due = append(due, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, msg := range due {
+		_, err := tx.ExecContext(ctx, `
+            UPDATE messages SET status = 'pending'
+            WHERE id = $1 AND tenant_id = $2
+        `, msg.id, msg.tenantID)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := s.outbox.EnqueueNow(ctx, tx, msg.id, msg.tenantID, msg.payload, msg.headers); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if len(due) > 0 {
+		log.Printf("scheduler released %d due messages", len(due))
+	}
+
+	return len(due), nil
+}
+
+// scheduledHeaders decodes the headers stored with a scheduled message.
+// Rows without usable headers are published with their ids alone.
+func scheduledHeaders(headersJSON []byte, messageID, tenantID string) map[string]any {
+	var headers map[string]any
+	if err := json.Unmarshal(headersJSON, &headers); err != nil || headers == nil {
+		return map[string]any{"message_id": messageID, "tenant_id": tenantID}
+	}
+
+	return headers
+}
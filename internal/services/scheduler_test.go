@@ -0,0 +1,33 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScheduledHeaders(t *testing.T) {
+	fallback := map[string]any{"message_id": "m1", "tenant_id": "t1"}
+
+	tests := []struct {
+		name    string
+		headers string
+		want    map[string]any
+	}{
+		{
+			name:    "keeps stored headers",
+			headers: `{"message_id":"m1","tenant_id":"t1","priority":2}`,
+			want:    map[string]any{"message_id": "m1", "tenant_id": "t1", "priority": float64(2)},
+		},
+		{name: "falls back without headers", headers: ``, want: fallback},
+		{name: "falls back on null", headers: `null`, want: fallback},
+		{name: "falls back on malformed headers", headers: `{"message_id":`, want: fallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheduledHeaders([]byte(tt.headers), "m1", "t1"); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
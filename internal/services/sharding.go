@@ -0,0 +1,564 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// deadNodeRetention is how long a node that stopped heartbeating stays
+// listed before its row is pruned.
+const deadNodeRetention = time.Hour
+
+// Assignment is a tenant slot owned by a worker node.
+type Assignment struct {
+	TenantID       string    `json:"tenant_id"`
+	TenantName     string    `json:"tenant_name"`
+	Slot           int       `json:"slot"`
+	NodeID         string    `json:"node_id"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	AssignedAt     time.Time `json:"assigned_at"`
+	Expired        bool      `json:"expired"`
+}
+
+// WorkerNode is a worker process as seen through its heartbeats.
+type WorkerNode struct {
+	ID          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	Alive       bool      `json:"alive"`
+	Tenants     int       `json:"tenants"`
+}
+
+// PoolUsage is a tenant's worker pool on one node: its size, as resized by
+// the autoscaler, and how many of its workers process a message, which the
+// node's worker budget may hold below the size.
+type PoolUsage struct {
+	Workers int `json:"workers"`
+	Busy    int `json:"busy"`
+}
+
+// ShardCoordinator shards tenants across worker nodes. Each tenant has
+// replicas slots and a node owns a slot through a lease that its heartbeat
+// renews. Rebalance keeps every node at its fair share; the slots of a node
+// that stops heartbeating expire and are claimed by the others.
+//
+// A node also tracks its leases locally: once they lapse without a renewal
+// it no longer owns any tenant, even before another node claims its slots.
+type ShardCoordinator struct {
+	db       *sql.DB
+	nodeID   string
+	hostname string
+	leaseTTL time.Duration
+	replicas int
+
+	// mu serializes renewals, so a heartbeat never overwrites the view of
+	// a rebalance that committed after it
+	mu sync.Mutex
+	// owned and leaseExpiresAt are the tenants of the last renewal and when
+	// their leases lapse, by the local clock
+	owned          map[string]bool
+	leaseExpiresAt time.Time
+	// replicasInUse is the replica count of the last rebalance, which is
+	// lower than replicas while there are fewer live nodes
+	replicasInUse int
+	// usage returns the pools renewals record with the slots
+	usage func() map[string]PoolUsage
+}
+
+func NewShardCoordinator(db *sql.DB, nodeID, hostname string, leaseTTL time.Duration, replicas int) *ShardCoordinator {
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	return &ShardCoordinator{
+		db:            db,
+		nodeID:        nodeID,
+		hostname:      hostname,
+		leaseTTL:      leaseTTL,
+		replicas:      replicas,
+		replicasInUse: replicas,
+	}
+}
+
+func (c *ShardCoordinator) NodeID() string {
+	return c.nodeID
+}
+
+// Replicas is the number of nodes each tenant is spread across: the
+// configured count, or the number of live nodes if that is lower.
+func (c *ShardCoordinator) Replicas() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.replicasInUse
+}
+
+// ReportUsage makes renewals record the node's worker pools, as returned by
+// usage, with its slots, so the API can report what the nodes run.
+func (c *ShardCoordinator) ReportUsage(usage func() map[string]PoolUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usage = usage
+}
+
+// Owns reports whether the node holds a slot of the tenant under a lease
+// that has not lapsed.
+func (c *ShardCoordinator) Owns(tenantID string) bool {
+	return c.owns(tenantID, time.Now())
+}
+
+func (c *ShardCoordinator) owns(tenantID string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.owned[tenantID] && now.Before(c.leaseExpiresAt)
+}
+
+// LeaseExpiresAt is when the node's leases lapse unless renewed.
+func (c *ShardCoordinator) LeaseExpiresAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.leaseExpiresAt
+}
+
+// renewed records the tenants whose leases a renewal that began at start
+// extended. The leases were renewed on the database clock after start, so
+// they lapse locally no later than there.
+func (c *ShardCoordinator) renewed(start time.Time, owned map[string]bool) {
+	c.owned = owned
+	c.leaseExpiresAt = start.Add(c.leaseTTL)
+}
+
+// Join registers the node. A node restarting under the same ID keeps the
+// slots it still holds.
+func (c *ShardCoordinator) Join(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `
+        INSERT INTO worker_nodes (id, hostname, started_at, heartbeat_at)
+        VALUES ($1, $2, NOW(), NOW())
+        ON CONFLICT (id) DO UPDATE SET hostname = $2, started_at = NOW(), heartbeat_at = NOW()
+    `, c.nodeID, c.hostname)
+	if err != nil {
+		return fmt.Errorf("failed to register worker node %s: %w", c.nodeID, err)
+	}
+
+	return nil
+}
+
+// Heartbeat marks the node alive and renews the leases of its slots.
+// Tenants whose slots were claimed by another node after the leases lapsed
+// are no longer owned.
+func (c *ShardCoordinator) Heartbeat(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE worker_nodes SET heartbeat_at = NOW() WHERE id = $1
+    `, c.nodeID)
+	if err != nil {
+		return err
+	}
+
+	owned, err := c.renewLeases(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.renewed(start, owned)
+
+	return nil
+}
+
+// renewLeases renews the leases of the node's slots, records the pools of
+// their tenants and returns the tenants.
+func (c *ShardCoordinator) renewLeases(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	usage := map[string]PoolUsage{}
+	if c.usage != nil {
+		usage = c.usage()
+	}
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tenants without a pool here have not started yet or were stopped
+	rows, err := tx.QueryContext(ctx, `
+        UPDATE tenant_assignments
+        SET lease_expires_at = NOW() + $2 * INTERVAL '1 millisecond',
+            workers = ($3::jsonb -> tenant_id::text ->> 'workers')::int,
+            busy = ($3::jsonb -> tenant_id::text ->> 'busy')::int
+        WHERE node_id = $1
+        RETURNING tenant_id
+    `, c.nodeID, c.leaseTTL.Milliseconds(), string(usageJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenantIDs := make(map[string]bool)
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, err
+		}
+		tenantIDs[tenantID] = true
+	}
+
+	return tenantIDs, rows.Err()
+}
+
+// Rebalance plans which node each tenant slot belongs to, releases the
+// node's slots that belong to another node and claims the unowned and
+// expired ones that belong to it. Slots held by live nodes change hands
+// only once their holder released them, so a handover takes a rebalance of
+// either node. It then renews the leases of the node's slots and returns
+// the tenants it owns. Nodes rebalance one at a time and, seeing the same
+// assignments, plan alike.
+func (c *ShardCoordinator) Rebalance(ctx context.Context) (map[string]bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('tenant_assignments'))`); err != nil {
+		return nil, err
+	}
+
+	// Slots of deleted tenants and of replicas above the configured count
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM tenant_assignments a
+        USING tenants t
+        WHERE a.tenant_id = t.id AND (t.deleted_at IS NOT NULL OR a.slot >= $1)
+    `, c.replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM worker_nodes
+        WHERE heartbeat_at < NOW() - $1 * INTERVAL '1 millisecond'
+        AND NOT EXISTS (SELECT 1 FROM tenant_assignments WHERE node_id = worker_nodes.id)
+    `, deadNodeRetention.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := queryStrings(ctx, tx, `SELECT id FROM tenants WHERE deleted_at IS NULL ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	liveNodes, err := queryStrings(ctx, tx, `
+        SELECT id FROM worker_nodes WHERE heartbeat_at > NOW() - $1 * INTERVAL '1 millisecond'
+    `, c.leaseTTL.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	// This node is alive even if its heartbeat is late
+	if !slices.Contains(liveNodes, c.nodeID) {
+		liveNodes = append(liveNodes, c.nodeID)
+	}
+
+	held := make(map[tenantSlot]slotLease)
+	rows, err := tx.QueryContext(ctx, `
+        SELECT tenant_id, slot, node_id, lease_expires_at < NOW() FROM tenant_assignments
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slot tenantSlot
+		var lease slotLease
+		if err := rows.Scan(&slot.TenantID, &slot.Slot, &lease.NodeID, &lease.Expired); err != nil {
+			return nil, err
+		}
+		held[slot] = lease
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	plan, replicas := planAssignments(tenants, liveNodes, c.replicas, held)
+
+	var released, claimed int
+	for slot, lease := range held {
+		if lease.NodeID != c.nodeID || plan[slot] == c.nodeID {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+            DELETE FROM tenant_assignments WHERE tenant_id = $1 AND slot = $2 AND node_id = $3
+        `, slot.TenantID, slot.Slot, c.nodeID)
+		if err != nil {
+			return nil, err
+		}
+		released++
+	}
+	for slot, nodeID := range plan {
+		if lease, ok := held[slot]; nodeID != c.nodeID || (ok && !lease.Expired) {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO tenant_assignments (tenant_id, slot, node_id, lease_expires_at, assigned_at)
+            VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond', NOW())
+            ON CONFLICT (tenant_id, slot) DO UPDATE
+            SET node_id = EXCLUDED.node_id, lease_expires_at = EXCLUDED.lease_expires_at, assigned_at = NOW()
+        `, slot.TenantID, slot.Slot, c.nodeID, c.leaseTTL.Milliseconds())
+		if err != nil {
+			return nil, err
+		}
+		claimed++
+	}
+	if released > 0 {
+		log.Printf("Node %s released %d tenant slots for rebalancing", c.nodeID, released)
+	}
+	if claimed > 0 {
+		log.Printf("Node %s claimed %d tenant slots", c.nodeID, claimed)
+	}
+
+	tenantIDs, err := c.renewLeases(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	c.renewed(start, tenantIDs)
+	c.replicasInUse = replicas
+
+	return tenantIDs, nil
+}
+
+// tenantSlot is one of a tenant's slots.
+type tenantSlot struct {
+	TenantID string
+	Slot     int
+}
+
+// slotLease is the node holding a slot and whether its lease expired.
+type slotLease struct {
+	NodeID  string
+	Expired bool
+}
+
+// planAssignments returns the node each slot of tenants should belong to,
+// and the number of slots per tenant: replicas, or the number of live
+// nodes if that is lower, as a node never holds two slots of the same
+// tenant. Each live node gets its fair share of slots, give or take one.
+// Live nodes keep the slots they hold as far as their share allows, so a
+// node joining or leaving moves few tenants.
+func planAssignments(tenants, liveNodes []string, replicas int, held map[tenantSlot]slotLease) (map[tenantSlot]string, int) {
+	plan := make(map[tenantSlot]string)
+	if len(liveNodes) == 0 {
+		return plan, 0
+	}
+	liveNodes = slices.Sorted(slices.Values(liveNodes))
+	slots := min(replicas, len(liveNodes))
+
+	// Nodes holding more slots get the larger shares
+	holding := make(map[string]int, len(liveNodes))
+	for slot, lease := range held {
+		if !lease.Expired && slot.Slot < slots {
+			holding[lease.NodeID]++
+		}
+	}
+	byHolding := slices.Clone(liveNodes)
+	slices.SortStableFunc(byHolding, func(a, b string) int { return holding[b] - holding[a] })
+
+	share, extra := len(tenants)*slots/len(liveNodes), len(tenants)*slots%len(liveNodes)
+	free := make(map[string]int, len(liveNodes))
+	for i, nodeID := range byHolding {
+		free[nodeID] = share
+		if i < extra {
+			free[nodeID]++
+		}
+	}
+
+	for _, tenantID := range tenants {
+		for slot := range slots {
+			lease, ok := held[tenantSlot{tenantID, slot}]
+			if ok && !lease.Expired && free[lease.NodeID] > 0 {
+				plan[tenantSlot{tenantID, slot}] = lease.NodeID
+				free[lease.NodeID]--
+			}
+		}
+	}
+
+	// Hand the other slots to the nodes with the most room left
+	for _, tenantID := range tenants {
+		for slot := range slots {
+			if _, ok := plan[tenantSlot{tenantID, slot}]; ok {
+				continue
+			}
+			var best string
+			for _, nodeID := range liveNodes {
+				if free[nodeID] > free[best] && !holdsTenant(plan, tenantID, slots, nodeID) {
+					best = nodeID
+				}
+			}
+			if best == "" {
+				return roundRobin(tenants, liveNodes, slots), slots
+			}
+			plan[tenantSlot{tenantID, slot}] = best
+			free[best]--
+		}
+	}
+
+	return plan, slots
+}
+
+func holdsTenant(plan map[tenantSlot]string, tenantID string, slots int, nodeID string) bool {
+	for slot := range slots {
+		if plan[tenantSlot{tenantID, slot}] == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// roundRobin deals the slots out in turn. Kept slots can leave no node
+// with room that does not already hold the tenant; dealing them afresh
+// always fits.
+func roundRobin(tenants, liveNodes []string, slots int) map[tenantSlot]string {
+	plan := make(map[tenantSlot]string, len(tenants)*slots)
+	for i, tenantID := range tenants {
+		for slot := range slots {
+			plan[tenantSlot{tenantID, slot}] = liveNodes[(i*slots+slot)%len(liveNodes)]
+		}
+	}
+
+	return plan
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// Leave releases every slot of the node so others can claim them right
+// away instead of waiting for the leases to expire.
+func (c *ShardCoordinator) Leave(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tenant_assignments WHERE node_id = $1`, c.nodeID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM worker_nodes WHERE id = $1`, c.nodeID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.owned = nil
+
+	return nil
+}
+
+// AssignmentService reports which worker node serves which tenant.
+type AssignmentService struct {
+	db       *sql.DB
+	leaseTTL time.Duration
+}
+
+func NewAssignmentService(db *sql.DB, leaseTTL time.Duration) *AssignmentService {
+	return &AssignmentService{db: db, leaseTTL: leaseTTL}
+}
+
+// List returns the assignments of live tenants, optionally only those of
+// one tenant or one node.
+func (s *AssignmentService) List(ctx context.Context, tenantID, nodeID string) ([]Assignment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT a.tenant_id, t.name, a.slot, a.node_id, a.lease_expires_at, a.assigned_at,
+               a.lease_expires_at < NOW()
+        FROM tenant_assignments a
+        JOIN tenants t ON t.id = a.tenant_id
+        WHERE t.deleted_at IS NULL
+        AND ($1 = '' OR a.tenant_id::text = $1)
+        AND ($2 = '' OR a.node_id = $2)
+        ORDER BY t.name, a.slot
+    `, tenantID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []Assignment{}
+	for rows.Next() {
+		var a Assignment
+		if err := rows.Scan(&a.TenantID, &a.TenantName, &a.Slot, &a.NodeID, &a.LeaseExpiresAt, &a.AssignedAt, &a.Expired); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+
+	return assignments, rows.Err()
+}
+
+// Nodes returns the registered worker nodes with their slot counts.
+func (s *AssignmentService) Nodes(ctx context.Context) ([]WorkerNode, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT n.id, COALESCE(n.hostname, ''), n.started_at, n.heartbeat_at,
+               n.heartbeat_at > NOW() - $1 * INTERVAL '1 millisecond',
+               (SELECT COUNT(*) FROM tenant_assignments a WHERE a.node_id = n.id)
+        FROM worker_nodes n
+        ORDER BY n.id
+    `, s.leaseTTL.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []WorkerNode{}
+	for rows.Next() {
+		var n WorkerNode
+		if err := rows.Scan(&n.ID, &n.Hostname, &n.StartedAt, &n.HeartbeatAt, &n.Alive, &n.Tenants); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, rows.Err()
+}
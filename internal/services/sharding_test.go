@@ -0,0 +1,180 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testTenants(n int) []string {
+	tenants := make([]string, n)
+	for i := range tenants {
+		tenants[i] = fmt.Sprintf("t%d", i)
+	}
+	return tenants
+}
+
+// holding turns a plan into the slots its nodes hold.
+func holding(plan map[tenantSlot]string) map[tenantSlot]slotLease {
+	held := make(map[tenantSlot]slotLease, len(plan))
+	for slot, nodeID := range plan {
+		held[slot] = slotLease{NodeID: nodeID}
+	}
+	return held
+}
+
+func TestPlanAssignments(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenants  int
+		replicas int
+		held     map[tenantSlot]slotLease
+		live     []string
+		// slots is the number of slots per tenant, moved the number of
+		// slots taken from live nodes holding them
+		slots int
+		moved int
+	}{
+		{
+			name:     "first node",
+			tenants:  4,
+			replicas: 2,
+			live:     []string{"a"},
+			slots:    1,
+		},
+		{
+			name:     "join",
+			tenants:  6,
+			replicas: 1,
+			held:     holding(roundRobin(testTenants(6), []string{"a"}, 1)),
+			live:     []string{"a", "b"},
+			slots:    1,
+			moved:    3,
+		},
+		{
+			name:     "join adds a replica",
+			tenants:  3,
+			replicas: 2,
+			held:     holding(roundRobin(testTenants(3), []string{"a"}, 1)),
+			live:     []string{"a", "b"},
+			slots:    2,
+		},
+		{
+			name:     "leave",
+			tenants:  7,
+			replicas: 2,
+			held: func() map[tenantSlot]slotLease {
+				held := holding(roundRobin(testTenants(7), []string{"a", "b", "c"}, 2))
+				for slot, lease := range held {
+					if lease.NodeID == "c" {
+						delete(held, slot)
+					}
+				}
+				return held
+			}(),
+			live:  []string{"a", "b"},
+			slots: 2,
+		},
+		{
+			name:     "expiry",
+			tenants:  7,
+			replicas: 2,
+			held: func() map[tenantSlot]slotLease {
+				held := holding(roundRobin(testTenants(7), []string{"a", "b", "c"}, 2))
+				for slot, lease := range held {
+					if lease.NodeID == "c" {
+						held[slot] = slotLease{NodeID: "c", Expired: true}
+					}
+				}
+				return held
+			}(),
+			live:  []string{"a", "b"},
+			slots: 2,
+		},
+		{
+			name:     "more replicas than nodes",
+			tenants:  2,
+			replicas: 3,
+			live:     []string{"a", "b"},
+			slots:    2,
+		},
+		{
+			name:     "kept slots leave no room",
+			tenants:  2,
+			replicas: 2,
+			held: map[tenantSlot]slotLease{
+				{"t0", 0}: {NodeID: "a"},
+				{"t1", 0}: {NodeID: "b"},
+				{"t1", 1}: {NodeID: "c"},
+			},
+			live:  []string{"a", "b", "c"},
+			slots: 2,
+			moved: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := testTenants(tt.tenants)
+			plan, slots := planAssignments(tenants, tt.live, tt.replicas, tt.held)
+			if slots != tt.slots {
+				t.Fatalf("expected %d slots per tenant, got %d", tt.slots, slots)
+			}
+			if len(plan) != tt.tenants*slots {
+				t.Fatalf("expected %d slots to be planned, got %d", tt.tenants*slots, len(plan))
+			}
+
+			perNode := make(map[string]int)
+			for _, tenantID := range tenants {
+				nodes := make(map[string]bool)
+				for slot := range slots {
+					nodeID, ok := plan[tenantSlot{tenantID, slot}]
+					if !ok {
+						t.Fatalf("slot %d of %s is not planned", slot, tenantID)
+					}
+					if nodes[nodeID] {
+						t.Fatalf("node %s planned for two slots of %s", nodeID, tenantID)
+					}
+					nodes[nodeID] = true
+					perNode[nodeID]++
+				}
+			}
+			share := tt.tenants * slots / len(tt.live)
+			for _, nodeID := range tt.live {
+				if perNode[nodeID] < share || perNode[nodeID] > share+1 {
+					t.Fatalf("node %s planned %d slots, want %d or %d", nodeID, perNode[nodeID], share, share+1)
+				}
+			}
+
+			var moved int
+			for slot, lease := range tt.held {
+				if !lease.Expired && slot.Slot < slots && plan[slot] != lease.NodeID {
+					moved++
+				}
+			}
+			if moved != tt.moved {
+				t.Fatalf("expected %d slots to move, got %d", tt.moved, moved)
+			}
+		})
+	}
+}
+
+func TestShardCoordinatorLeaseLapses(t *testing.T) {
+	c := NewShardCoordinator(nil, "a", "a", time.Minute, 1)
+	now := time.Now()
+
+	if c.owns("t1", now) {
+		t.Fatal("expected no tenant to be owned before the first renewal")
+	}
+
+	c.renewed(now, map[string]bool{"t1": true})
+	if !c.owns("t1", now.Add(30*time.Second)) {
+		t.Fatal("expected the tenant to be owned within the lease")
+	}
+	if c.owns("t2", now) {
+		t.Fatal("expected a tenant without a slot not to be owned")
+	}
+	if c.owns("t1", now.Add(time.Minute)) {
+		t.Fatal("expected the tenant not to be owned once the lease lapsed")
+	}
+}
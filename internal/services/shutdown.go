@@ -4,7 +4,6 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,7 +12,9 @@ import (
 type ShutdownManager struct {
 	TenantManager *TenantManager
 	Server        *fiber.App
-	wg            sync.WaitGroup
+	// Quiesce stops background work that would start consumers again while
+	// they drain
+	Quiesce func()
 }
 
 func (sm *ShutdownManager) GracefulShutdown() {
@@ -23,11 +24,14 @@ func (sm *ShutdownManager) GracefulShutdown() {
 	<-quit
 	log.Println("Shutting down server...")
 
-	// Stop all tenant consumers
-	sm.TenantManager.StopAllConsumers()
+	if sm.Quiesce != nil {
+		sm.Quiesce()
+	}
 
-	// Wait for ongoing processing to complete
-	sm.wg.Wait()
+	// Stop all tenant consumers, waiting for ongoing processing to complete
+	if sm.TenantManager != nil {
+		sm.TenantManager.StopAllConsumers()
+	}
 
 	// Shutdown HTTP server
 	if sm.Server != nil {
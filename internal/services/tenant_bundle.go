@@ -0,0 +1,442 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/aarondl/null/v8"
+	"github.com/aarondl/sqlboiler/v4/boil"
+	"github.com/google/uuid"
+)
+
+// BundleVersion is the version of the bundles Export writes. Import reads
+// bundles up to this version.
+const BundleVersion = 1
+
+const (
+	bundleManifest = "manifest.json"
+	bundleTenant   = "tenant.json"
+)
+
+var (
+	ErrInvalidBundle     = errors.New("invalid tenant bundle")
+	ErrUnsupportedBundle = errors.New("unsupported tenant bundle version")
+	ErrTenantExists      = errors.New("tenant already exists")
+)
+
+// bundleTable is a table exported as NDJSON, one row per line. Tables are
+// written and imported in this order so rows come after those they
+// reference.
+type bundleTable struct {
+	name    string
+	orderBy string
+	// ownID is set for tables whose id is unique across tenants; such rows
+	// get a new id when imported under a new tenant ID
+	ownID bool
+	// redact blanks the secrets of a row unless they are exported on
+	// purpose
+	redact func(row map[string]any)
+}
+
+var bundleTables = []bundleTable{
+	{name: "tenant_configs", orderBy: "config_key, version", ownID: true, redact: redactConfigSecrets},
+	{name: "messages", orderBy: "created_at, id"},
+	{name: "message_processing_logs", orderBy: "created_at, id", ownID: true},
+	{name: "dead_letter_messages", orderBy: "created_at, id", ownID: true},
+}
+
+// BundleManifest is the first entry of a bundle.
+type BundleManifest struct {
+	Version    int            `json:"version"`
+	TenantID   string         `json:"tenant_id"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Rows       map[string]int `json:"rows"`
+}
+
+// ExportOptions control what a bundle contains. By default the webhook
+// secret and SMTP password are left out, so they have to be set again after
+// an import.
+type ExportOptions struct {
+	IncludeSecrets bool
+}
+
+func redactConfigSecrets(row map[string]any) {
+	key, _ := row["config_key"].(string)
+	value, _ := row["config_value"].(map[string]any)
+
+	if field, ok := secretConfigFields[key]; ok && value != nil {
+		if _, ok := value[field]; ok {
+			value[field] = ""
+		}
+	}
+}
+
+// ImportOptions control how a bundle is imported. By default the tenant
+// gets a new ID and keeps its name.
+type ImportOptions struct {
+	KeepID bool
+	Name   string
+}
+
+// TenantBundler exports a tenant with its configs, messages, processing
+// logs and dead letters as a tar.gz bundle of NDJSON files, and imports such
+// bundles.
+type TenantBundler struct {
+	db      *sql.DB
+	control *TenantControl
+	outbox  *OutboxRelay
+}
+
+func NewTenantBundler(db *sql.DB, control *TenantControl, outbox *OutboxRelay) *TenantBundler {
+	return &TenantBundler{db: db, control: control, outbox: outbox}
+}
+
+// Exists reports whether the tenant can be exported. Deleted tenants can be
+// until they are purged.
+func (b *TenantBundler) Exists(ctx context.Context, tenantID string) (bool, error) {
+	return models.TenantExists(ctx, b.db, tenantID)
+}
+
+// Export writes the tenant's bundle to w. All tables are read from the same
+// snapshot; each is spooled to a temporary file first, since tar entries
+// need their size up front.
+func (b *TenantBundler) Export(ctx context.Context, tenantID string, w io.Writer, opts ExportOptions) error {
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tenant []byte
+	err = tx.QueryRowContext(ctx, `SELECT row_to_json(t)::text FROM tenants t WHERE id = $1`, tenantID).Scan(&tenant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTenantNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest := BundleManifest{
+		Version:    BundleVersion,
+		TenantID:   tenantID,
+		ExportedAt: time.Now().UTC(),
+		Rows:       make(map[string]int, len(bundleTables)),
+	}
+
+	spools := make([]*os.File, len(bundleTables))
+	defer func() {
+		for _, spool := range spools {
+			if spool != nil {
+				spool.Close()
+				os.Remove(spool.Name())
+			}
+		}
+	}()
+
+	for i, table := range bundleTables {
+		spools[i], err = os.CreateTemp("", "tenant-bundle-*.ndjson")
+		if err != nil {
+			return err
+		}
+
+		if opts.IncludeSecrets {
+			table.redact = nil
+		}
+		manifest.Rows[table.name], err = spoolTable(ctx, tx, table, tenantID, spools[i])
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", table.name, err)
+		}
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := writeBundleEntry(tw, bundleManifest, int64(len(manifestJSON)), manifest.ExportedAt, bytes.NewReader(manifestJSON)); err != nil {
+		return err
+	}
+	if err := writeBundleEntry(tw, bundleTenant, int64(len(tenant)), manifest.ExportedAt, bytes.NewReader(tenant)); err != nil {
+		return err
+	}
+
+	for i, table := range bundleTables {
+		size, err := spools[i].Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := spools[i].Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := writeBundleEntry(tw, table.name+".ndjson", size, manifest.ExportedAt, spools[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func spoolTable(ctx context.Context, tx *sql.Tx, table bundleTable, tenantID string, spool *os.File) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		`SELECT row_to_json(t)::text FROM %s t WHERE tenant_id = $1 ORDER BY %s`, table.name, table.orderBy), tenantID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	w := bufio.NewWriter(spool)
+	count := 0
+	for rows.Next() {
+		var line []byte
+		if err := rows.Scan(&line); err != nil {
+			return 0, err
+		}
+		if table.redact != nil {
+			if line, err = redactLine(line, table.redact); err != nil {
+				return 0, err
+			}
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return count, w.Flush()
+}
+
+// redactLine applies redact to a row exported as JSON.
+func redactLine(line []byte, redact func(row map[string]any)) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var row map[string]any
+	if err := decoder.Decode(&row); err != nil {
+		return nil, err
+	}
+	redact(row)
+
+	return json.Marshal(row)
+}
+
+func writeBundleEntry(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tw, r, size)
+	return err
+}
+
+// Import recreates a tenant from a bundle in one transaction: the tenant
+// row, its messages partition and every exported row. Messages that were
+// waiting or being processed are published to the new queue again; workers
+// are told about the tenant once it is committed.
+func (b *TenantBundler) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*models.Tenant, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var manifest BundleManifest
+	if err := readBundleEntry(tr, bundleManifest, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version < 1 || manifest.Version > BundleVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedBundle, manifest.Version)
+	}
+
+	tenant := new(models.Tenant)
+	if err := readBundleEntry(tr, bundleTenant, tenant); err != nil {
+		return nil, err
+	}
+
+	if !opts.KeepID {
+		tenant.ID = uuid.NewString()
+	} else if uuid.Validate(tenant.ID) != nil {
+		return nil, fmt.Errorf("%w: tenant id %q is not a UUID", ErrInvalidBundle, tenant.ID)
+	}
+	if opts.Name != "" {
+		tenant.Name = opts.Name
+	}
+	tenant.Status = null.StringFrom(TenantStatusProvisioning)
+	tenant.QueueName = fmt.Sprintf("tenant_%s_queue", tenant.ID)
+	tenant.ConsumerTag = null.String{}
+	tenant.DeletedAt = null.Time{}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	exists, err := models.TenantExists(ctx, tx, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrTenantExists
+	}
+
+	if err := tenant.Insert(ctx, tx, boil.Infer()); err != nil {
+		return nil, err
+	}
+	if err := createMessagePartition(ctx, tx, tenant.ID); err != nil {
+		return nil, err
+	}
+
+	imp := &bundleImport{tx: tx, tenantID: tenant.ID, newIDs: !opts.KeepID}
+	for _, table := range bundleTables {
+		header, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing %s.ndjson: %v", ErrInvalidBundle, table.name, err)
+		}
+		if header.Name != table.name+".ndjson" {
+			return nil, fmt.Errorf("%w: expected %s.ndjson, found %s", ErrInvalidBundle, table.name, header.Name)
+		}
+
+		if err := imp.table(ctx, table, tr); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", table.name, err)
+		}
+	}
+
+	for _, msg := range imp.requeue {
+		if _, err := b.outbox.EnqueueNow(ctx, tx, msg.id, tenant.ID, msg.payload, msg.headers); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := b.control.broker.CreateTenantQueue(tenant.ID); err != nil {
+		log.Printf("Failed to declare queue for tenant %s, leaving it to the workers: %v", tenant.ID, err)
+	}
+	b.control.announce(ctx, control.RKCreate, control.NewMessage(tenant.ID, int32(tenant.CurrentWorkers.Int)))
+
+	return tenant, nil
+}
+
+func readBundleEntry(tr *tar.Reader, name string, v any) error {
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: missing %s: %v", ErrInvalidBundle, name, err)
+	}
+	if header.Name != name {
+		return fmt.Errorf("%w: expected %s, found %s", ErrInvalidBundle, name, header.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidBundle, name, err)
+	}
+	return nil
+}
+
+// requeuedMessage is an imported message to publish again.
+type requeuedMessage struct {
+	id      string
+	payload []byte
+	headers map[string]any
+}
+
+// bundleImport inserts the rows of a bundle under the imported tenant.
+type bundleImport struct {
+	tx       *sql.Tx
+	tenantID string
+	newIDs   bool
+	requeue  []requeuedMessage
+}
+
+func (imp *bundleImport) table(ctx context.Context, table bundleTable, r io.Reader) error {
+	query := fmt.Sprintf(`INSERT INTO %s SELECT * FROM json_populate_record(NULL::%s, $1::json)`,
+		table.name, table.name)
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var row map[string]any
+		if err := decoder.Decode(&row); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		row["tenant_id"] = imp.tenantID
+		if table.ownID && imp.newIDs {
+			row["id"] = uuid.NewString()
+		}
+		if table.name == "messages" {
+			if err := imp.message(row); err != nil {
+				return err
+			}
+		}
+
+		rowJSON, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if _, err := imp.tx.ExecContext(ctx, query, rowJSON); err != nil {
+			return err
+		}
+	}
+}
+
+// message points the message's headers at the imported tenant and queues
+// it for publishing if it had not been processed yet.
+func (imp *bundleImport) message(row map[string]any) error {
+	id, _ := row["id"].(string)
+
+	headers, _ := row["headers"].(map[string]any)
+	if headers == nil {
+		headers = map[string]any{"message_id": id}
+	}
+	headers["tenant_id"] = imp.tenantID
+	row["headers"] = headers
+
+	status, _ := row["status"].(string)
+	if status != "pending" && status != "processing" {
+		return nil
+	}
+
+	row["status"] = "pending"
+	row["visible_at"] = nil
+	row["lease_token"] = nil
+
+	payload, err := json.Marshal(row["payload"])
+	if err != nil {
+		return err
+	}
+	imp.requeue = append(imp.requeue, requeuedMessage{id: id, payload: payload, headers: headers})
+
+	return nil
+}
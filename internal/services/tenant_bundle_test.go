@@ -0,0 +1,81 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func bundle(t *testing.T, entries ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(entries); i += 2 {
+		if err := writeBundleEntry(tw, entries[i], int64(len(entries[i+1])), time.Now(), strings.NewReader(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+
+	return buf.Bytes()
+}
+
+func TestImportRejectsBundlesItCannotRead(t *testing.T) {
+	// Nothing here may reach the database
+	bundler := NewTenantBundler(nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		bundle []byte
+		want   error
+	}{
+		{"not gzip", []byte("tenant"), ErrInvalidBundle},
+		{"no manifest", bundle(t, bundleTenant, `{}`), ErrInvalidBundle},
+		{"broken manifest", bundle(t, bundleManifest, `{"version":`), ErrInvalidBundle},
+		{"newer version", bundle(t, bundleManifest, `{"version":2}`), ErrUnsupportedBundle},
+		{"no tenant", bundle(t, bundleManifest, `{"version":1}`), ErrInvalidBundle},
+		{"tenant id kept but not a UUID", bundle(t, bundleManifest, `{"version":1}`, bundleTenant, `{"id":"acme"}`), ErrInvalidBundle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bundler.Import(context.Background(), bytes.NewReader(tt.bundle), ImportOptions{KeepID: true})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestExportRedactsConfigSecrets(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"config_key":"webhook","config_value":{"url":"https://example.com","secret":"s3cret"},"version":2}`,
+			`{"config_key":"webhook","config_value":{"secret":"","url":"https://example.com"},"version":2}`},
+		{`{"config_key":"smtp","config_value":{"host":"mail","port":587,"password":"hunter2"}}`,
+			`{"config_key":"smtp","config_value":{"host":"mail","password":"","port":587}}`},
+		// Configs without secrets are untouched
+		{`{"config_key":"concurrency","config_value":{"workers":3}}`,
+			`{"config_key":"concurrency","config_value":{"workers":3}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := redactLine([]byte(tt.line), redactConfigSecrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("redactLine(%s) = %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
+)
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// secretConfigFields names the secret field of each tenant config, as in
+// processor.WebhookConfig and processor.SMTPConfig.
+var secretConfigFields = map[string]string{
+	processor.WebhookConfigKey: "secret",
+	processor.SMTPConfigKey:    "password",
+}
+
+// encryptedSecretPrefix marks secrets encrypted by TenantConfigStore.
+// Secrets stored before they were encrypted lack it and are read as is.
+const encryptedSecretPrefix = "enc:v1:"
+
+// TenantConfigStore reads and writes versioned tenant_configs entries. Saving
+// a key deactivates its current entry and adds the next version, so earlier
+// values stay available for audit. Secret fields are stored encrypted with a
+// key derived from the app secret, so every process reading them needs the
+// same one.
+type TenantConfigStore struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+var _ processor.ConfigLoader = (*TenantConfigStore)(nil)
+
+func NewTenantConfigStore(db *sql.DB, appSecret string) *TenantConfigStore {
+	key := sha256.Sum256([]byte("tenant-config-secrets:" + appSecret))
+	// Neither fails for a 32 byte key
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+
+	return &TenantConfigStore{db: db, aead: aead}
+}
+
+// Load decodes the active value of key into v, with its secret decrypted.
+func (s *TenantConfigStore) Load(ctx context.Context, tenantID, key string, v any) error {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `
+        SELECT config_value FROM tenant_configs
+        WHERE tenant_id = $1 AND config_key = $2 AND is_active = true
+    `, tenantID, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return processor.ErrConfigNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if field, ok := secretConfigFields[key]; ok {
+		value, err = transformSecret(value, field, s.decrypt)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s config of tenant %s: %w", key, tenantID, err)
+		}
+	}
+
+	return json.Unmarshal(value, v)
+}
+
+// transformSecret applies transform to field of the JSON object value, if
+// set.
+func transformSecret(value []byte, field string, transform func(string) (string, error)) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	secret, _ := object[field].(string)
+	if secret == "" {
+		return value, nil
+	}
+	secret, err := transform(secret)
+	if err != nil {
+		return nil, err
+	}
+	object[field] = secret
+
+	return json.Marshal(object)
+}
+
+func (s *TenantConfigStore) encrypt(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *TenantConfigStore) decrypt(secret string) (string, error) {
+	encoded, ok := strings.CutPrefix(secret, encryptedSecretPrefix)
+	if !ok {
+		return secret, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("encrypted secret is truncated")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("encrypted secret does not match the app secret")
+	}
+
+	return string(plaintext), nil
+}
+
+// Scheduling returns the active scheduling config of every tenant that set
+// one.
+func (s *TenantConfigStore) Scheduling(ctx context.Context) (map[string]SchedulingConfig, error) {
+	return loadAll[SchedulingConfig](ctx, s.db, SchedulingConfigKey)
+}
+
+// Prefetch returns the active prefetch override of every tenant that set
+// one.
+func (s *TenantConfigStore) Prefetch(ctx context.Context) (map[string]PrefetchConfig, error) {
+	return loadAll[PrefetchConfig](ctx, s.db, PrefetchConfigKey)
+}
+
+// loadAll decodes the active value of key of every live tenant.
+func loadAll[T any](ctx context.Context, db *sql.DB, key string) (map[string]T, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT c.tenant_id, c.config_value FROM tenant_configs c
+        JOIN tenants t ON t.id = c.tenant_id
+        WHERE c.config_key = $1 AND c.is_active = true AND t.deleted_at IS NULL
+    `, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := make(map[string]T)
+	for rows.Next() {
+		var tenantID string
+		var value []byte
+		if err := rows.Scan(&tenantID, &value); err != nil {
+			return nil, err
+		}
+
+		var config T
+		if err := json.Unmarshal(value, &config); err != nil {
+			return nil, fmt.Errorf("invalid %s config of tenant %s: %w", key, tenantID, err)
+		}
+		configs[tenantID] = config
+	}
+
+	return configs, rows.Err()
+}
+
+// Save stores v as the new active value of key and returns its version.
+func (s *TenantConfigStore) Save(ctx context.Context, tenantID, key string, v any) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	version, err := s.SaveTx(ctx, tx, tenantID, key, v)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, tx.Commit()
+}
+
+// SaveTx is Save within the caller's transaction. It fails with
+// ErrTenantNotFound for unknown or deleted tenants.
+func (s *TenantConfigStore) SaveTx(ctx context.Context, tx *sql.Tx, tenantID, key string, v any) (int, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	if field, ok := secretConfigFields[key]; ok {
+		if value, err = transformSecret(value, field, s.encrypt); err != nil {
+			return 0, fmt.Errorf("failed to encrypt %s config: %w", key, err)
+		}
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1 AND deleted_at IS NULL)
+    `, tenantID).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrTenantNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE tenant_configs SET is_active = false, updated_at = NOW()
+        WHERE tenant_id = $1 AND config_key = $2 AND is_active = true
+    `, tenantID, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to deactivate tenant config %s: %w", key, err)
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO tenant_configs (tenant_id, config_key, config_value, version)
+        SELECT $1, $2, $3, COALESCE(MAX(version), 0) + 1
+        FROM tenant_configs WHERE tenant_id = $1 AND config_key = $2
+        RETURNING version
+    `, tenantID, key, value).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save tenant config %s: %w", key, err)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTenantConfigSecretsAreEncrypted(t *testing.T) {
+	store := NewTenantConfigStore(nil, "app-secret")
+
+	value := []byte(`{"url":"https://example.com/hook","secret":"s3cret","timeout_ms":1500}`)
+	encrypted, err := transformSecret(value, "secret", store.encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encrypted), "s3cret") || !strings.Contains(string(encrypted), encryptedSecretPrefix) {
+		t.Fatalf("expected the secret to be encrypted, got %s", encrypted)
+	}
+
+	decrypted, err := transformSecret(encrypted, "secret", store.decrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		URL       string `json:"url"`
+		Secret    string `json:"secret"`
+		TimeoutMS int    `json:"timeout_ms"`
+	}
+	if err := json.Unmarshal(decrypted, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Secret != "s3cret" || config.URL != "https://example.com/hook" || config.TimeoutMS != 1500 {
+		t.Fatalf("unexpected decrypted config %+v", config)
+	}
+
+	// Another app secret cannot read it
+	other := NewTenantConfigStore(nil, "other-secret")
+	if _, err := transformSecret(encrypted, "secret", other.decrypt); err == nil {
+		t.Fatal("expected decryption with another app secret to fail")
+	}
+
+	// Secrets stored before encryption and redacted ones are read as is
+	for _, raw := range []string{`{"secret":"legacy"}`, `{"secret":""}`, `{"url":"https://example.com"}`} {
+		if _, err := transformSecret([]byte(raw), "secret", store.decrypt); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+	}
+	legacy, _ := transformSecret([]byte(`{"secret":"legacy"}`), "secret", store.decrypt)
+	if string(legacy) != `{"secret":"legacy"}` {
+		t.Fatalf("expected a plaintext secret to be kept, got %s", legacy)
+	}
+}
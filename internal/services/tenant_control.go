@@ -0,0 +1,522 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
+	"github.com/aarondl/null/v8"
+	"github.com/aarondl/sqlboiler/v4/boil"
+	"github.com/aarondl/sqlboiler/v4/queries/qm"
+	"github.com/google/uuid"
+)
+
+// Tenant lifecycle states. The API moves a tenant into provisioning,
+// suspended and deleting; workers report active and stopped once they acted
+// on it.
+const (
+	TenantStatusProvisioning = "provisioning"
+	TenantStatusActive       = "active"
+	TenantStatusSuspended    = "suspended"
+	TenantStatusDeleting     = "deleting"
+	TenantStatusStopped      = "stopped"
+)
+
+// SuspensionConfigKey is the tenant_configs key deciding what happens to
+// messages published while the tenant is suspended.
+const SuspensionConfigKey = "suspension"
+
+// Publish policies of a suspended tenant. Buffered messages wait in the
+// queue until the tenant is resumed.
+const (
+	SuspendedPublishBuffer = "buffer"
+	SuspendedPublishReject = "reject"
+)
+
+// SuspensionConfig is the tenant_configs value of SuspensionConfigKey.
+type SuspensionConfig struct {
+	Publish string `json:"publish"`
+}
+
+var ErrTenantSuspended = errors.New("tenant is suspended")
+
+var ErrTenantNotSuspended = errors.New("tenant is not suspended")
+
+// ConcurrencyConfigKey is the tenant_configs key recording each change of a
+// tenant's worker count.
+const ConcurrencyConfigKey = "concurrency"
+
+var ErrInvalidConcurrency = errors.New("invalid worker count")
+
+// ConcurrencyConfig is the tenant_configs value of ConcurrencyConfigKey.
+type ConcurrencyConfig struct {
+	Workers int `json:"workers"`
+}
+
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+var ErrUnknownCursor = errors.New("cursor does not name a tenant")
+
+// TenantFilter narrows a tenant listing. Zero values are ignored.
+type TenantFilter struct {
+	Statuses []string // deleted tenants are only listed when asked for by status
+	Cursor   string   // id of the last tenant of the previous page
+	Limit    int
+}
+
+// TenantUpdate changes a tenant's name and limits. Nil fields are kept.
+type TenantUpdate struct {
+	Name       *string
+	MaxWorkers *int
+}
+
+// TenantRuntime is what the workers currently do with a tenant, as its
+// nodes last reported it: Workers is the size of its pools, after
+// autoscaler resizes, and Busy how many of them process a message, which
+// the nodes' worker budgets may hold below Workers. QueueDepth is nil when
+// the queue backend cannot report it.
+type TenantRuntime struct {
+	ConsumerRunning bool     `json:"consumer_running"`
+	Workers         int      `json:"workers"`
+	Busy            int      `json:"busy"`
+	QueueDepth      *int     `json:"queue_depth,omitempty"`
+	Nodes           []string `json:"nodes"`
+}
+
+// TenantDetails is a tenant with its runtime state.
+type TenantDetails struct {
+	*models.Tenant
+	Runtime TenantRuntime `json:"runtime"`
+}
+
+// ConcurrencyUpdate is a tenant's worker count after an update. Announced
+// is false when workers only pick it up on their next sync.
+type ConcurrencyUpdate struct {
+	TenantID        string `json:"tenant_id"`
+	Workers         int    `json:"workers"`
+	PreviousWorkers int    `json:"previous_workers"`
+	MaxWorkers      int    `json:"max_workers"`
+	Status          string `json:"status"`
+	Version         int    `json:"version"`
+	Announced       bool   `json:"announced"`
+}
+
+// ControlPublisher delivers control messages to the workers.
+type ControlPublisher interface {
+	Publish(ctx context.Context, routingKey string, msg control.Message) error
+}
+
+// TenantControl is the API side of the tenant lifecycle. It records changes
+// in the database and announces them on the control exchange; workers run
+// the consumers. A failed announcement is not fatal, workers reconcile
+// against the tenants table periodically.
+type TenantControl struct {
+	db        *sql.DB
+	broker    Broker
+	configs   *TenantConfigStore
+	publisher ControlPublisher
+}
+
+// NewTenantControl creates a TenantControl. publisher may be nil when there
+// is no control exchange, e.g. with the postgres queue driver.
+func NewTenantControl(db *sql.DB, broker Broker, configs *TenantConfigStore, publisher ControlPublisher) *TenantControl {
+	return &TenantControl{db: db, broker: broker, configs: configs, publisher: publisher}
+}
+
+func (c *TenantControl) CreateTenant(ctx context.Context, name string, maxWorkers int) (*models.Tenant, error) {
+	if maxWorkers <= 0 {
+		maxWorkers = 3 //default
+	}
+	id := uuid.NewString()
+	tenant := &models.Tenant{
+		ID:             id,
+		Name:           name,
+		Status:         null.StringFrom(TenantStatusProvisioning),
+		MaxWorkers:     null.IntFrom(maxWorkers),
+		CurrentWorkers: null.IntFrom(maxWorkers),
+		QueueName:      fmt.Sprintf("tenant_%s_queue", id),
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tenant.Insert(ctx, tx, boil.Infer()); err != nil {
+		return nil, err
+	}
+
+	if err := createMessagePartition(ctx, tx, tenant.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Declaring the queue up front lets messages published while the tenant
+	// is provisioning wait in it for a worker
+	if err := c.broker.CreateTenantQueue(tenant.ID); err != nil {
+		log.Printf("Failed to declare queue for tenant %s, leaving it to the workers: %v", tenant.ID, err)
+	}
+
+	c.announce(ctx, control.RKCreate, control.NewMessage(tenant.ID, int32(maxWorkers)))
+
+	return tenant, nil
+}
+
+// UpdateConcurrency sets the tenant's worker count, between 1 and its
+// max_workers, and records the change in tenant_configs.
+func (c *TenantControl) UpdateConcurrency(ctx context.Context, tenantID string, workers int) (*ConcurrencyUpdate, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	update := &ConcurrencyUpdate{TenantID: tenantID, Workers: workers}
+	err = tx.QueryRowContext(ctx, `
+        SELECT COALESCE(current_workers, 0), COALESCE(max_workers, 0), COALESCE(status, '')
+        FROM tenants
+        WHERE id = $1 AND deleted_at IS NULL
+        FOR UPDATE
+    `, tenantID).Scan(&update.PreviousWorkers, &update.MaxWorkers, &update.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant from database: %w", err)
+	}
+
+	if workers <= 0 || workers > update.MaxWorkers {
+		return nil, fmt.Errorf("%w: workers must be between 1 and max_workers (%d)",
+			ErrInvalidConcurrency, update.MaxWorkers)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE tenants SET current_workers = $2, updated_at = NOW()
+        WHERE id = $1
+    `, tenantID, workers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tenant in database: %w", err)
+	}
+
+	update.Version, err = c.configs.SaveTx(ctx, tx, tenantID, ConcurrencyConfigKey, ConcurrencyConfig{Workers: workers})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	update.Announced = c.announce(ctx, control.RKUpdate, control.NewMessage(tenantID, int32(workers)))
+
+	return update, nil
+}
+
+// Suspend stops the tenant's consumers on every worker; its queue is kept.
+// Suspending a suspended tenant does nothing.
+func (c *TenantControl) Suspend(ctx context.Context, tenantID string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `
+        SELECT COALESCE(status, '') FROM tenants
+        WHERE id = $1 AND deleted_at IS NULL
+        FOR UPDATE
+    `, tenantID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTenantNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tenant from database: %w", err)
+	}
+	if status == TenantStatusSuspended {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE tenants SET status = $2, updated_at = NOW() WHERE id = $1
+    `, tenantID, TenantStatusSuspended)
+	if err != nil {
+		return fmt.Errorf("failed to suspend tenant in database: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	c.announce(ctx, control.RKSuspend, control.NewMessage(tenantID, 0))
+
+	return nil
+}
+
+// Resume hands a suspended tenant back to the workers, which report it
+// active once they consume its queue again.
+func (c *TenantControl) Resume(ctx context.Context, tenantID string) error {
+	result, err := c.db.ExecContext(ctx, `
+        UPDATE tenants SET status = $3, updated_at = NOW()
+        WHERE id = $1 AND deleted_at IS NULL AND status = $2
+    `, tenantID, TenantStatusSuspended, TenantStatusProvisioning)
+	if err != nil {
+		return fmt.Errorf("failed to resume tenant in database: %w", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		var exists bool
+		err := c.db.QueryRowContext(ctx, `
+            SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1 AND deleted_at IS NULL)
+        `, tenantID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrTenantNotFound
+		}
+		return ErrTenantNotSuspended
+	}
+
+	c.announce(ctx, control.RKResume, control.NewMessage(tenantID, 0))
+
+	return nil
+}
+
+// DeleteTenant soft deletes the tenant; workers stop its consumers, delete
+// its queue and then mark it stopped.
+func (c *TenantControl) DeleteTenant(ctx context.Context, tenantID string) error {
+	result, err := c.db.ExecContext(ctx, `
+        UPDATE tenants SET status = $2, deleted_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND deleted_at IS NULL
+    `, tenantID, TenantStatusDeleting)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant from database: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrTenantNotFound
+	}
+
+	c.announce(ctx, control.RKDelete, control.NewMessage(tenantID, 0))
+
+	return nil
+}
+
+// ListTenants returns tenants, oldest first, and the cursor of the next
+// page, which is empty on the last page. It fails with ErrUnknownCursor
+// when the cursor is not a tenant's id.
+func (c *TenantControl) ListTenants(ctx context.Context, filter TenantFilter) ([]*models.Tenant, string, error) {
+	var queryMods []qm.QueryMod
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]interface{}, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = status
+		}
+		queryMods = append(queryMods, qm.WhereIn("status IN ?", statuses...))
+	} else {
+		queryMods = append(queryMods, qm.Where("deleted_at IS NULL"))
+	}
+	if filter.Cursor != "" {
+		exists, err := models.TenantExists(ctx, c.db, filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if !exists {
+			return nil, "", ErrUnknownCursor
+		}
+		queryMods = append(queryMods, qm.Where(
+			"(created_at, id) > (SELECT created_at, id FROM tenants WHERE id = ?)", filter.Cursor))
+	}
+
+	queryMods = append(queryMods,
+		qm.OrderBy("created_at, id"),
+		qm.Limit(filter.Limit+1), // Get one extra to check if there's a next page
+	)
+
+	tenants, err := models.Tenants(queryMods...).All(ctx, c.db)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(tenants) > filter.Limit {
+		tenants = tenants[:filter.Limit]
+		nextCursor = tenants[len(tenants)-1].ID
+	}
+
+	return tenants, nextCursor, nil
+}
+
+// GetTenant returns a live tenant with its runtime state. The consumer is
+// running when the tenant is active and a worker holding an unexpired lease
+// on it reports a pool.
+func (c *TenantControl) GetTenant(ctx context.Context, tenantID string) (*TenantDetails, error) {
+	tenant, err := models.Tenants(qm.Where("id = ? AND deleted_at IS NULL", tenantID)).One(ctx, c.db)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	details := &TenantDetails{
+		Tenant:  tenant,
+		Runtime: TenantRuntime{Nodes: []string{}},
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+        SELECT node_id, workers IS NOT NULL, COALESCE(workers, 0), COALESCE(busy, 0)
+        FROM tenant_assignments
+        WHERE tenant_id = $1 AND lease_expires_at > NOW()
+        ORDER BY node_id
+    `, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consuming := false
+	for rows.Next() {
+		var nodeID string
+		var pool bool
+		var usage PoolUsage
+		if err := rows.Scan(&nodeID, &pool, &usage.Workers, &usage.Busy); err != nil {
+			return nil, err
+		}
+		details.Runtime.Nodes = append(details.Runtime.Nodes, nodeID)
+		details.Runtime.Workers += usage.Workers
+		details.Runtime.Busy += usage.Busy
+		consuming = consuming || pool
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	details.Runtime.ConsumerRunning = tenant.Status.String == TenantStatusActive && consuming
+
+	if inspector, ok := c.broker.(QueueInspector); ok {
+		depth, err := inspector.MessageCount(tenantID)
+		if err != nil {
+			log.Printf("Failed to read queue depth of tenant %s: %v", tenantID, err)
+		} else {
+			details.Runtime.QueueDepth = &depth
+		}
+	}
+
+	return details, nil
+}
+
+// UpdateTenant renames the tenant and changes its max_workers. Lowering
+// max_workers below the tenant's worker count shrinks it, recorded like any
+// other concurrency change.
+func (c *TenantControl) UpdateTenant(ctx context.Context, tenantID string, update TenantUpdate) (*models.Tenant, error) {
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" || len(name) > 255 {
+			return nil, fmt.Errorf("%w: name must be between 1 and 255 characters", ErrInvalidTenant)
+		}
+		update.Name = &name
+	}
+	if update.MaxWorkers != nil && *update.MaxWorkers <= 0 {
+		return nil, fmt.Errorf("%w: max_workers must be at least 1", ErrInvalidTenant)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tenant, err := models.Tenants(
+		qm.Where("id = ? AND deleted_at IS NULL", tenantID),
+		qm.For("UPDATE"),
+	).One(ctx, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant from database: %w", err)
+	}
+
+	if update.Name != nil {
+		tenant.Name = *update.Name
+	}
+
+	shrunk := false
+	if update.MaxWorkers != nil {
+		tenant.MaxWorkers = null.IntFrom(*update.MaxWorkers)
+		if tenant.CurrentWorkers.Int > *update.MaxWorkers {
+			tenant.CurrentWorkers = null.IntFrom(*update.MaxWorkers)
+			shrunk = true
+		}
+	}
+
+	columns := boil.Whitelist(
+		models.TenantColumns.Name,
+		models.TenantColumns.MaxWorkers,
+		models.TenantColumns.CurrentWorkers,
+		models.TenantColumns.UpdatedAt,
+	)
+	if _, err := tenant.Update(ctx, tx, columns); err != nil {
+		return nil, fmt.Errorf("failed to update tenant in database: %w", err)
+	}
+
+	if shrunk {
+		_, err := c.configs.SaveTx(ctx, tx, tenantID, ConcurrencyConfigKey, ConcurrencyConfig{Workers: tenant.CurrentWorkers.Int})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if shrunk {
+		c.announce(ctx, control.RKUpdate, control.NewMessage(tenantID, int32(tenant.CurrentWorkers.Int)))
+	}
+
+	return tenant, nil
+}
+
+// announce reports whether workers were told about the change right away.
+func (c *TenantControl) announce(ctx context.Context, routingKey string, msg control.Message) bool {
+	if c.publisher == nil {
+		return false
+	}
+
+	if err := c.publisher.Publish(ctx, routingKey, msg); err != nil {
+		log.Printf("Failed to announce %s for tenant %s, workers pick it up on their next sync: %v",
+			routingKey, msg.TenantID, err)
+		return false
+	}
+
+	return true
+}
+
+// messagePartition returns the name of the tenant's messages partition.
+func messagePartition(tenantID string) string {
+	return fmt.Sprintf("messages_tenant_%s", strings.ReplaceAll(tenantID, "-", ""))
+}
+
+func createMessagePartition(ctx context.Context, tx *sql.Tx, tenantID string) error {
+	partitionName := messagePartition(tenantID)
+
+	query := fmt.Sprintf(`
+        CREATE TABLE %s PARTITION OF messages
+        FOR VALUES IN ('%s')
+    `, partitionName, tenantID)
+
+	_, err := tx.ExecContext(ctx, query)
+	return err
+}
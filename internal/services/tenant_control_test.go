@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/control"
+)
+
+// recordingPublisher records the control messages it publishes, or fails
+// them with err.
+type recordingPublisher struct {
+	err       error
+	published []string
+	versions  []int
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, routingKey string, msg control.Message) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, routingKey)
+	p.versions = append(p.versions, msg.Version)
+	return nil
+}
+
+func TestTenantControlAnnounce(t *testing.T) {
+	ctx := context.Background()
+	msg := control.NewMessage("t1", 2)
+
+	if (&TenantControl{}).announce(ctx, control.RKUpdate, msg) {
+		t.Fatal("expected no announcement without a control exchange")
+	}
+
+	failing := &recordingPublisher{err: errors.New("broker unavailable")}
+	if NewTenantControl(nil, nil, nil, failing).announce(ctx, control.RKUpdate, msg) {
+		t.Fatal("expected a failed publish not to count as announced")
+	}
+
+	publisher := &recordingPublisher{}
+	if !NewTenantControl(nil, nil, nil, publisher).announce(ctx, control.RKUpdate, msg) {
+		t.Fatal("expected the change to be announced")
+	}
+	if len(publisher.published) != 1 || publisher.published[0] != control.RKUpdate {
+		t.Fatalf("expected one %s message, got %v", control.RKUpdate, publisher.published)
+	}
+	if publisher.versions[0] != control.Version {
+		t.Fatalf("expected messages of version %d, got %d", control.Version, publisher.versions[0])
+	}
+}
@@ -6,323 +6,503 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/models"
-	"github.com/aarondl/null/v8"
-	"github.com/aarondl/sqlboiler/v4/boil"
-	"github.com/aarondl/sqlboiler/v4/queries/qm"
-	"github.com/google/uuid"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
 )
 
+// drainProgressInterval is how often a draining consumer logs how many
+// messages are still in flight.
+const drainProgressInterval = time.Second
+
+// PrefetchConfigKey is the tenant_configs key of a tenant's prefetch
+// override.
+const PrefetchConfigKey = "prefetch"
+
+// PrefetchConfig overrides how many unacked deliveries each of the tenant's
+// consumers holds; zero sizes it from the worker count.
+type PrefetchConfig struct {
+	Prefetch int `json:"prefetch"`
+}
+
 type TenantConsumer struct {
-	TenantID    string
-	Channel     *amqp.Channel
-	StopChannel chan bool
-	WorkerCount *int64
-	WorkerPool  chan struct{}
+	TenantID     string
+	Subscription Subscription
+	StopChannel  chan bool
+	WorkerPool   *WorkerPool
 }
 
 type TenantManager struct {
-	db        *sql.DB
-	rabbitmq  *RabbitMQService
-	consumers map[string]*TenantConsumer
-	mutex     sync.RWMutex
+	db       *sql.DB
+	broker   Broker
+	registry *processor.Registry
+	budget   *WorkerBudget
+	// drainTimeout bounds how long a stopping consumer waits for its
+	// in-flight messages
+	drainTimeout time.Duration
+	consumers    map[string]*TenantConsumer
+	// scheduling and prefetch outlive consumers so restarted ones keep
+	// their share and prefetch override
+	scheduling map[string]SchedulingConfig
+	prefetch   map[string]int
+	mutex      sync.RWMutex
 }
 
-func NewTenantManager(db *sql.DB, rabbitmq *RabbitMQService) *TenantManager {
+// NewTenantManager creates a TenantManager whose tenants share budget.
+// Stopping consumers waits up to drainTimeout for in-flight messages.
+func NewTenantManager(db *sql.DB, broker Broker, registry *processor.Registry, budget *WorkerBudget, drainTimeout time.Duration) *TenantManager {
 	return &TenantManager{
-		db:        db,
-		rabbitmq:  rabbitmq,
-		consumers: make(map[string]*TenantConsumer),
+		db:           db,
+		broker:       broker,
+		registry:     registry,
+		budget:       budget,
+		drainTimeout: drainTimeout,
+		consumers:    make(map[string]*TenantConsumer),
+		scheduling:   make(map[string]SchedulingConfig),
+		prefetch:     make(map[string]int),
 	}
 }
 
+// StartTenant declares the tenant's queue, starts consuming it and reports
+// a provisioning tenant as active.
 func (tm *TenantManager) StartTenant(ctx context.Context, tenantID string, worker int) error {
-	// 4. Create RabbitMQ queue
-	err := tm.rabbitmq.CreateTenantQueue(tenantID)
+	// 1. Create RabbitMQ queue
+	err := tm.broker.CreateTenantQueue(tenantID)
 	if err != nil {
 		return err
 	}
 
-	// 5. Start consumer
+	// Queues declared before priority support are rebuilt while nobody in
+	// this process consumes them yet; if that fails they stay FIFO
+	if migrator, ok := tm.broker.(QueueMigrator); ok {
+		if err := migrator.MigrateTenantQueue(tenantID); err != nil {
+			log.Printf("Failed to migrate queue for tenant %s to a priority queue: %v", tenantID, err)
+		}
+	}
+
+	// 2. Start consumer
 	err = tm.StartConsumer(tenantID, worker)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// 3. Report back
+	return tm.reportStatus(ctx, tenantID, TenantStatusProvisioning, TenantStatusActive)
 }
 
-func (tm *TenantManager) CreateTenant(ctx context.Context, name string, maxWorkers int) (*models.Tenant, error) {
-	// 1. Create tenant in database
-	if maxWorkers <= 0 {
-		maxWorkers = 3 //default
+// ReportActive reports a tenant whose consumer already runs in this
+// process active, e.g. when it was resumed before this process heard of its
+// suspension.
+func (tm *TenantManager) ReportActive(ctx context.Context, tenantID string) error {
+	return tm.reportStatus(ctx, tenantID, TenantStatusProvisioning, TenantStatusActive)
+}
+
+// StopTenant stops consuming the tenant's queue in this process, e.g. after
+// another node took the tenant over. In-flight messages are drained first;
+// the queue is kept.
+func (tm *TenantManager) StopTenant(tenantID string) {
+	tm.mutex.Lock()
+	consumer, exists := tm.consumers[tenantID]
+	if exists {
+		// Remove from consumers map
+		delete(tm.consumers, tenantID)
+		delete(tm.scheduling, tenantID)
+		delete(tm.prefetch, tenantID)
 	}
-	id := uuid.NewString()
-	tenant := &models.Tenant{
-		ID:             id,
-		Name:           name,
-		Status:         null.StringFrom("active"),
-		MaxWorkers:     null.IntFrom(maxWorkers),
-		CurrentWorkers: null.IntFrom(maxWorkers),
-		QueueName:      fmt.Sprintf("tenant_%s_queue", id),
+	tm.mutex.Unlock()
+
+	if exists {
+		tm.drain(consumer)
+		log.Printf("Consumer for tenant %s stopped successfully", tenantID)
 	}
+}
 
-	// 2. Insert to database with partition creation
-	tx, err := tm.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
+// drain stops the consumer from taking deliveries, waits up to the drain
+// timeout for its in-flight messages to be settled and then closes its
+// subscription. Deliveries the broker pushed but no worker took, at most the
+// prefetch, are requeued on close.
+func (tm *TenantManager) drain(consumer *TenantConsumer) {
+	close(consumer.StopChannel)
+	consumer.WorkerPool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tm.drainTimeout)
+	defer cancel()
+
+	for {
+		busy, _ := consumer.WorkerPool.Stats()
+		if busy == 0 {
+			break
+		}
+		log.Printf("Draining tenant %s: %d messages in flight", consumer.TenantID, busy)
+
+		ctxProgress, cancelProgress := context.WithTimeout(ctx, drainProgressInterval)
+		err := consumer.WorkerPool.Drain(ctxProgress)
+		cancelProgress()
+		if err == nil {
+			log.Printf("Drained tenant %s", consumer.TenantID)
+			break
+		}
+		if ctx.Err() != nil {
+			busy, _ := consumer.WorkerPool.Stats()
+			log.Printf("Gave up draining tenant %s after %s with %d messages in flight; they will be redelivered",
+				consumer.TenantID, tm.drainTimeout, busy)
+			break
+		}
 	}
 
-	if err := tenant.Insert(ctx, tm.db, boil.Infer()); err != nil {
-		return nil, err
+	// Close broker subscription
+	if consumer.Subscription != nil {
+		consumer.Subscription.Close()
 	}
+}
 
-	// 3. Create message partition
-	err = tm.createMessagePartition(tenant.ID)
+// DeleteTenant stops the tenant's consumer, deletes its queue and reports a
+// deleting tenant as stopped.
+func (tm *TenantManager) DeleteTenant(ctx context.Context, tenantID string) error {
+	// Stop consumer first
+	tm.StopTenant(tenantID)
+
+	// Delete RabbitMQ queue; other workers may have done so already
+	err := tm.broker.DeleteTenantQueue(tenantID)
 	if err != nil {
-		// Rollback tenant creation
-		return nil, err
+		return fmt.Errorf("failed to delete queue for tenant %s: %w", tenantID, err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	if err := tm.reportStatus(ctx, tenantID, TenantStatusDeleting, TenantStatusStopped); err != nil {
+		return err
 	}
 
-	// 4. Create RabbitMQ queue
-	err = tm.rabbitmq.CreateTenantQueue(tenant.ID)
+	log.Printf("Tenant %s deleted successfully", tenantID)
+
+	return nil
+}
+
+// UpdateConcurrency resizes the tenant's worker pool in this process. When
+// shrinking it waits for the workers above the new size to finish their
+// messages; if ctx ends first they finish in the background.
+func (tm *TenantManager) UpdateConcurrency(ctx context.Context, tenantID string, workers int) error {
+	consumer, currentWorkerCount, err := tm.resize(tenantID, workers)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// 5. Start consumer
-	err = tm.StartConsumer(tenant.ID, maxWorkers)
-	if err != nil {
-		return nil, err
+	if workers < currentWorkerCount {
+		if err := consumer.WorkerPool.Settle(ctx); err != nil {
+			busy, _ := consumer.WorkerPool.Stats()
+			log.Printf("Tenant %s still has %d busy workers above its new worker count %d, they finish in the background",
+				tenantID, busy-workers, workers)
+			return nil
+		}
 	}
 
-	return tenant, nil
+	log.Printf("Updated tenant %s worker count from %d to %d",
+		tenantID, currentWorkerCount, workers)
+
+	return nil
 }
 
-func (tm *TenantManager) DeleteTenant(ctx context.Context, tenantID string) error {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+// resize sets the pool size, and the prefetch unless it is overridden, and
+// returns the size it replaced.
+func (tm *TenantManager) resize(tenantID string, workers int) (*TenantConsumer, int, error) {
+	tm.mutex.RLock()
+	consumer, exists := tm.consumers[tenantID]
+	tm.mutex.RUnlock()
+	if !exists {
+		return nil, 0, fmt.Errorf("consumer for tenant %s not found", tenantID)
+	}
 
-	// Stop consumer first
-	if consumer, exists := tm.consumers[tenantID]; exists {
-		// Send shutdown signal
-		close(consumer.StopChannel)
+	_, currentWorkerCount := consumer.WorkerPool.Stats()
+	consumer.WorkerPool.Resize(workers)
 
-		// Close RabbitMQ channel
-		if consumer.Channel != nil {
-			consumer.Channel.Close()
+	tm.mutex.RLock()
+	_, overridden := tm.prefetch[tenantID]
+	tm.mutex.RUnlock()
+	if !overridden && workers != currentWorkerCount {
+		if err := consumer.Subscription.SetPrefetch(workers * PrefetchPerWorker); err != nil {
+			log.Printf("Failed to update prefetch of tenant %s: %v", tenantID, err)
 		}
+	}
 
-		// Remove from consumers map
-		delete(tm.consumers, tenantID)
+	return consumer, currentWorkerCount, nil
+}
 
-		log.Printf("Consumer for tenant %s stopped successfully", tenantID)
-	}
+// Schedule sets the tenant's weight and guaranteed minimum in the worker
+// budget, now and for consumers started later.
+func (tm *TenantManager) Schedule(tenantID string, config SchedulingConfig) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 
-	// Delete RabbitMQ queue
-	err := tm.rabbitmq.DeleteTenantQueue(tenantID)
-	if err != nil {
-		log.Printf("Failed to delete queue for tenant %s: %v", tenantID, err)
-		// Continue with cleanup even if queue deletion fails
+	if tm.scheduling[tenantID] == config {
+		return
 	}
+	tm.scheduling[tenantID] = config
 
-	// Soft delete tenant from database (preserve data for audit)
-	tenant := &models.Tenant{
-		ID:        tenantID,
-		Status:    null.StringFrom("stopped"),
-		DeletedAt: null.TimeFrom(time.Now()),
+	if consumer, exists := tm.consumers[tenantID]; exists {
+		consumer.WorkerPool.Schedule(config)
+		log.Printf("Updated tenant %s scheduling to weight %d with %d guaranteed workers",
+			tenantID, config.Weight, config.MinWorkers)
 	}
+}
 
-	rowsAffected, err := tenant.Update(ctx, tm.db, boil.Whitelist("status", "deleted_at"))
-	if err != nil {
-		return fmt.Errorf("failed to delete tenant from database: %w", err)
+// SetPrefetch overrides the tenant's prefetch, now and for consumers
+// started later; zero goes back to PrefetchPerWorker per worker.
+func (tm *TenantManager) SetPrefetch(tenantID string, prefetch int) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if tm.prefetch[tenantID] == prefetch {
+		return nil
+	}
+	if prefetch > 0 {
+		tm.prefetch[tenantID] = prefetch
+	} else {
+		delete(tm.prefetch, tenantID)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("tenant not found or already deleted")
+	consumer, exists := tm.consumers[tenantID]
+	if !exists {
+		return nil
 	}
 
-	log.Printf("Tenant %s deleted successfully", tenantID)
+	_, workers := consumer.WorkerPool.Stats()
+	effective := tm.prefetchFor(tenantID, workers)
+	if err := consumer.Subscription.SetPrefetch(effective); err != nil {
+		return fmt.Errorf("failed to update prefetch of tenant %s: %w", tenantID, err)
+	}
+	log.Printf("Updated tenant %s prefetch to %d", tenantID, effective)
 
 	return nil
 }
 
-func (tm *TenantManager) UpdateConcurrency(ctx context.Context, tenantID string, config *models.TenantConfigRequest) error {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+// prefetchFor returns the tenant's prefetch override, or PrefetchPerWorker
+// per worker. Callers hold tm.mutex.
+func (tm *TenantManager) prefetchFor(tenantID string, workers int) int {
+	if prefetch, ok := tm.prefetch[tenantID]; ok {
+		return prefetch
+	}
+	return max(workers, 1) * PrefetchPerWorker
+}
 
-	// Update database first
-	configJSON, err := json.Marshal(config)
+// reportStatus moves the tenant from one lifecycle status to the next. It
+// is a no-op when another worker reported first.
+func (tm *TenantManager) reportStatus(ctx context.Context, tenantID, from, to string) error {
+	_, err := tm.db.ExecContext(ctx, `
+        UPDATE tenants SET status = $3, updated_at = NOW()
+        WHERE id = $1 AND status = $2
+    `, tenantID, from, to)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to report tenant %s as %s: %w", tenantID, to, err)
 	}
-	rowsAffected, err := models.TenantConfigs(qm.Where("tenant_id=?", tenantID)).
-		UpdateAll(ctx, tm.db, models.M{"config_value": configJSON})
-	if err != nil {
-		return fmt.Errorf("failed to update tenant in database: %w", err)
+
+	return nil
+}
+
+// StopAllConsumers drains every consumer in parallel, e.g. on shutdown.
+func (tm *TenantManager) StopAllConsumers() {
+	tm.mutex.Lock()
+	consumers := tm.consumers
+	// Clear all consumers
+	tm.consumers = make(map[string]*TenantConsumer)
+	tm.mutex.Unlock()
+
+	log.Printf("Stopping %d tenant consumers...", len(consumers))
+
+	var wg sync.WaitGroup
+	for _, consumer := range consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tm.drain(consumer)
+		}()
 	}
+	wg.Wait()
+
+	log.Println("All tenant consumers stopped")
+}
+
+// Tenants returns the IDs of the tenants consumed by this process.
+func (tm *TenantManager) Tenants() []string {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("tenant not found or deleted")
+	tenantIDs := make([]string, 0, len(tm.consumers))
+	for tenantID := range tm.consumers {
+		tenantIDs = append(tenantIDs, tenantID)
 	}
+	return tenantIDs
+}
+
+// Workers returns the tenant's worker count in this process.
+func (tm *TenantManager) Workers(tenantID string) (int, bool) {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
 
-	// 2. Update consumer worker pool
 	consumer, exists := tm.consumers[tenantID]
 	if !exists {
-		return fmt.Errorf("consumer for tenant %s not found", tenantID)
+		return 0, false
 	}
+	_, workers := consumer.WorkerPool.Stats()
+	return workers, true
+}
 
-	currentWorkerCount := int(atomic.LoadInt64(consumer.WorkerCount))
+// Usage returns the worker pool of every tenant consumed by this process.
+func (tm *TenantManager) Usage() map[string]PoolUsage {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
 
-	if config.Workers > currentWorkerCount {
-		// Add more workers to pool
-		for range config.Workers - currentWorkerCount {
-			select {
-			case consumer.WorkerPool <- struct{}{}:
-				// Worker added successfully
-			default:
-				// Pool is full, this shouldn't happen but handle gracefully
-				break
-			}
-		}
-	} else if config.Workers < currentWorkerCount {
-		// Remove workers from pool
-		for range currentWorkerCount - config.Workers {
-			select {
-			case <-consumer.WorkerPool:
-				// Worker removed successfully
-			default:
-				// No workers available to remove, that's ok
-				break
-			}
-		}
+	usage := make(map[string]PoolUsage, len(tm.consumers))
+	for tenantID, consumer := range tm.consumers {
+		busy, workers := consumer.WorkerPool.Stats()
+		usage[tenantID] = PoolUsage{Workers: workers, Busy: busy}
 	}
+	return usage
+}
 
-	// 3. Update worker count
-	atomic.StoreInt64(consumer.WorkerCount, int64(config.Workers))
-
-	log.Printf("Updated tenant %s worker count from %d to %d",
-		tenantID, currentWorkerCount, config.Workers)
+// Utilization returns how many of the tenant's workers in this process are
+// busy and the size of its worker pool.
+func (tm *TenantManager) Utilization(tenantID string) (busy, workers int, ok bool) {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
 
-	return nil
+	consumer, exists := tm.consumers[tenantID]
+	if !exists {
+		return 0, 0, false
+	}
+	busy, workers = consumer.WorkerPool.Stats()
+	return busy, workers, true
 }
 
-func (tm *TenantManager) StopAllConsumers() {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+// Running reports whether a consumer is active for the tenant.
+func (tm *TenantManager) Running(tenantID string) bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
 
-	log.Printf("Stopping %d tenant consumers...", len(tm.consumers))
+	_, exists := tm.consumers[tenantID]
+	return exists
+}
 
+// RecoverConsumers re-declares every known tenant queue and restarts its
+// consumer with the worker count it had before the connection dropped.
+// Tenants stopped meanwhile are not restarted.
+func (tm *TenantManager) RecoverConsumers() {
+	tm.mutex.RLock()
+	workers := make(map[string]int, len(tm.consumers))
 	for tenantID, consumer := range tm.consumers {
-		// Send shutdown signal
-		select {
-		case consumer.StopChannel <- true:
-			log.Printf("Shutdown signal sent to tenant %s", tenantID)
-		default:
-			// Channel might be closed, try closing it
-			close(consumer.StopChannel)
+		_, workers[tenantID] = consumer.WorkerPool.Stats()
+	}
+	tm.mutex.RUnlock()
+
+	log.Printf("Recovering %d tenant consumers...", len(workers))
+
+	for tenantID, workerCount := range workers {
+		if err := tm.broker.CreateTenantQueue(tenantID); err != nil {
+			log.Printf("Failed to redeclare queue for tenant %s: %v", tenantID, err)
+			continue
 		}
 
-		// Close RabbitMQ channel
-		if consumer.Channel != nil {
-			consumer.Channel.Close()
+		if err := tm.restartConsumer(tenantID, workerCount); err != nil {
+			log.Printf("Failed to restart consumer for tenant %s: %v", tenantID, err)
 		}
 	}
-
-	// Clear all consumers
-	tm.consumers = make(map[string]*TenantConsumer)
-
-	log.Println("All tenant consumers stopped")
 }
 
-func (tm *TenantManager) createMessagePartition(tenantID string) error {
-	partitionName := fmt.Sprintf("messages_tenant_%s",
-		strings.ReplaceAll(tenantID, "-", ""))
+// restartConsumer re-subscribes the tenant's consumer, unless it was
+// stopped since RecoverConsumers listed it.
+func (tm *TenantManager) restartConsumer(tenantID string, workerCount int) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 
-	query := fmt.Sprintf(`
-        CREATE TABLE %s PARTITION OF messages 
-        FOR VALUES IN ('%s')
-    `, partitionName, tenantID)
+	if _, exists := tm.consumers[tenantID]; !exists {
+		log.Printf("Tenant %s was stopped during recovery, not restarting its consumer", tenantID)
+		return nil
+	}
 
-	_, err := tm.db.Exec(query)
-	return err
+	return tm.startConsumer(tenantID, workerCount)
 }
 
+// StartConsumer subscribes to the tenant queue. A consumer already running
+// for the tenant is replaced.
 func (tm *TenantManager) StartConsumer(tenantID string, workerCount int) error {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
-	// Create consumer channel
-	ch, err := tm.rabbitmq.conn.Channel()
-	if err != nil {
-		return err
-	}
-
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
-	consumerTag := fmt.Sprintf("consumer_%s", tenantID)
+	return tm.startConsumer(tenantID, workerCount)
+}
 
-	// Get message channel
-	msgs, err := ch.Consume(
-		queueName,   // queue
-		consumerTag, // consumer
-		false,       // auto-ack
-		false,       // exclusive
-		false,       // no-local
-		false,       // no-wait
-		nil,         // args
-	)
+// startConsumer subscribes to the tenant queue. A consumer it replaces is
+// stopped and its subscription closed, requeueing what it had not handed to
+// a worker yet; its worker pool carries over, so messages still in flight
+// keep counting against the tenant's workers. Callers hold tm.mutex.
+func (tm *TenantManager) startConsumer(tenantID string, workerCount int) error {
+	// Subscribe to tenant queue
+	sub, err := tm.broker.Consume(tenantID, tm.prefetchFor(tenantID, workerCount))
 	if err != nil {
 		return err
 	}
 
-	// Create worker pool
-	workerPool := make(chan struct{}, workerCount)
-	for range workerCount {
-		workerPool <- struct{}{}
+	consumer := &TenantConsumer{
+		TenantID:     tenantID,
+		Subscription: sub,
+		StopChannel:  make(chan bool),
 	}
 
-	consumer := &TenantConsumer{
-		TenantID:    tenantID,
-		Channel:     ch,
-		StopChannel: make(chan bool),
-		WorkerCount: new(int64),
-		WorkerPool:  workerPool,
+	if previous, exists := tm.consumers[tenantID]; exists {
+		close(previous.StopChannel)
+		if previous.Subscription != nil {
+			previous.Subscription.Close()
+		}
+		consumer.WorkerPool = previous.WorkerPool
+		consumer.WorkerPool.Resize(workerCount)
+	} else {
+		consumer.WorkerPool = tm.budget.Pool(tenantID, workerCount)
+		if config, ok := tm.scheduling[tenantID]; ok {
+			consumer.WorkerPool.Schedule(config)
+		}
 	}
 
-	atomic.StoreInt64(consumer.WorkerCount, int64(workerCount))
 	tm.consumers[tenantID] = consumer
 
 	// Start consumer goroutine
-	go tm.runConsumer(consumer, msgs)
+	go tm.runConsumer(consumer, sub.Deliveries())
 
 	return nil
 }
 
-func (tm *TenantManager) runConsumer(consumer *TenantConsumer, msgs <-chan amqp.Delivery) {
+func (tm *TenantManager) runConsumer(consumer *TenantConsumer, msgs <-chan Delivery) {
 	for {
 		select {
 		case <-consumer.StopChannel:
 			log.Printf("consumer %s has stopped", consumer.TenantID)
 			return
-		case msg := <-msgs:
+		case msg, ok := <-msgs:
+			if !ok {
+				// Channel or connection closed; RecoverConsumers restarts us
+				log.Printf("consumer %s delivery channel closed", consumer.TenantID)
+				return
+			}
+
 			// Get worker from pool (blocking if all busy)
-			<-consumer.WorkerPool
+			if !consumer.WorkerPool.Acquire() {
+				log.Printf("consumer %s has stopped", consumer.TenantID)
+				return
+			}
+
+			// Replaced while waiting for the worker; the closed
+			// subscription requeues the message
+			select {
+			case <-consumer.StopChannel:
+				consumer.WorkerPool.Release()
+				log.Printf("consumer %s has stopped", consumer.TenantID)
+				return
+			default:
+			}
 
 			// Process message in separate goroutine
-			go func(delivery amqp.Delivery) {
+			go func(delivery Delivery) {
 				defer func() {
 					// Return worker to pool
-					consumer.WorkerPool <- struct{}{}
+					consumer.WorkerPool.Release()
 				}()
 
 				tm.processMessage(consumer.TenantID, delivery)
@@ -331,7 +511,7 @@ func (tm *TenantManager) runConsumer(consumer *TenantConsumer, msgs <-chan amqp.
 	}
 }
 
-func (tm *TenantManager) processMessage(tenantID string, delivery amqp.Delivery) {
+func (tm *TenantManager) processMessage(tenantID string, delivery Delivery) {
 	startTime := time.Now()
 	workerID := fmt.Sprintf("worker_%d", time.Now().UnixNano())
 
@@ -346,8 +526,7 @@ func (tm *TenantManager) processMessage(tenantID string, delivery amqp.Delivery)
 	// Log processing start
 	tm.logProcessingEvent(messageID, tenantID, workerID, "started", "", 0)
 
-	// Simulate message processing (replace with actual business logic)
-	err := tm.handleBusinessLogic(tenantID, messageID, delivery.Body)
+	err := tm.handleBusinessLogic(tenantID, messageID, delivery)
 
 	processingDuration := int(time.Since(startTime).Milliseconds())
 
@@ -356,15 +535,21 @@ func (tm *TenantManager) processMessage(tenantID string, delivery amqp.Delivery)
 		tm.logProcessingEvent(messageID, tenantID, workerID, "failed", err.Error(), processingDuration)
 
 		// Check retry logic
-		retryCount := tm.getRetryCount(delivery)
-		if retryCount < 3 { // Max retries
-			// Requeue with delay
-			tm.requeueMessage(tenantID, delivery, retryCount+1)
-			delivery.Ack(false)
-		} else {
+		retryCount := delivery.RetryCount
+		switch {
+		case processor.IsPermanent(err):
+			// Retrying cannot help, e.g. malformed payload or unknown type
+			tm.sendToDeadLetter(tenantID, messageID, delivery.Body, "Permanent failure", err.Error(), retryCount)
+			delivery.Ack()
+		case retryCount < 3: // Max retries
+			// The broker redelivers it after the retry delay
+			if err := delivery.Retry(); err != nil {
+				log.Printf("Failed to schedule retry for message %s of tenant %s: %v", messageID, tenantID, err)
+			}
+		default:
 			// Send to dead letter
-			tm.sendToDeadLetter(tenantID, messageID, delivery.Body, err.Error(), retryCount)
-			delivery.Ack(false)
+			tm.sendToDeadLetter(tenantID, messageID, delivery.Body, "Max retries exceeded", err.Error(), retryCount)
+			delivery.Ack()
 		}
 		return
 	}
@@ -372,17 +557,16 @@ func (tm *TenantManager) processMessage(tenantID string, delivery amqp.Delivery)
 	// Success - update message status and log
 	tm.updateMessageStatus(messageID, tenantID, "completed")
 	tm.logProcessingEvent(messageID, tenantID, workerID, "completed", "", processingDuration)
-	delivery.Ack(false)
+	delivery.Ack()
 }
 
-func (tm *TenantManager) handleBusinessLogic(tenantID, messageID string, payload []byte) error {
-	// This is where you implement your actual message processing logic
-	// For example:
-
+// handleBusinessLogic marks the message as processing and dispatches it to
+// the handler registered for its type.
+func (tm *TenantManager) handleBusinessLogic(tenantID, messageID string, delivery Delivery) error {
 	// 1. Parse the payload
 	var messageData map[string]any
-	if err := json.Unmarshal(payload, &messageData); err != nil {
-		return fmt.Errorf("failed to parse message payload: %w", err)
+	if err := json.Unmarshal(delivery.Body, &messageData); err != nil {
+		return processor.Permanent(fmt.Errorf("failed to parse message payload: %w", err))
 	}
 
 	// 2. Store message in database
@@ -392,52 +576,24 @@ func (tm *TenantManager) handleBusinessLogic(tenantID, messageID string, payload
         ON CONFLICT (id, tenant_id) 
         DO UPDATE SET status = 'processing', processed_at = NOW()
     `
-	_, err := tm.db.Exec(query, messageID, tenantID, payload, time.Now())
+	_, err := tm.db.Exec(query, messageID, tenantID, delivery.Body, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to store message: %w", err)
 	}
 
-	// 3. Simulate some processing work
-	time.Sleep(100 * time.Millisecond)
-
-	// 4. Example business logic based on message type
-	if msgType, ok := messageData["type"].(string); ok {
-		switch msgType {
-		case "email":
-			return tm.processEmailMessage(tenantID, messageData)
-		case "webhook":
-			return tm.processWebhookMessage(tenantID, messageData)
-		case "notification":
-			return tm.processNotificationMessage(tenantID, messageData)
-		default:
-			return tm.processGenericMessage(tenantID, messageData)
-		}
+	// 3. Hand it to the registered handler
+	msg := &processor.Message{
+		ID:         messageID,
+		TenantID:   tenantID,
+		Payload:    delivery.Body,
+		Headers:    delivery.Headers,
+		Priority:   delivery.Priority,
+		RetryCount: delivery.RetryCount,
 	}
+	msg.Type, _ = messageData["type"].(string)
+	msg.Data, _ = messageData["data"].(map[string]any)
 
-	return nil
-}
-
-func (tm *TenantManager) processEmailMessage(tenantID string, data map[string]interface{}) error {
-	// Implement email processing logic
-	// Example: send email, update status, etc.
-	return nil
-}
-
-func (tm *TenantManager) processWebhookMessage(tenantID string, data map[string]interface{}) error {
-	// Implement webhook processing logic
-	// Example: make HTTP request, handle response, etc.
-	return nil
-}
-
-func (tm *TenantManager) processNotificationMessage(tenantID string, data map[string]interface{}) error {
-	// Implement notification processing logic
-	// Example: send push notification, SMS, etc.
-	return nil
-}
-
-func (tm *TenantManager) processGenericMessage(tenantID string, data map[string]interface{}) error {
-	// Default processing logic
-	return nil
+	return tm.registry.Dispatch(context.Background(), msg)
 }
 
 func (tm *TenantManager) logProcessingEvent(messageID, tenantID string, workerID, status, errorMsg string, duration int) {
@@ -460,49 +616,12 @@ func (tm *TenantManager) updateMessageStatus(messageID, tenantID string, status
 	tm.db.Exec(query, status, messageID, tenantID)
 }
 
-func (tm *TenantManager) getRetryCount(delivery amqp.Delivery) int {
-	if retryBytes, ok := delivery.Headers["retry_count"]; ok {
-		if retryCount, ok := retryBytes.(int); ok {
-			return retryCount
-		}
-	}
-	return 0
-}
-
-func (tm *TenantManager) requeueMessage(tenantID string, delivery amqp.Delivery, retryCount int) {
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
-
-	// Add retry count to headers
-	headers := delivery.Headers
-	if headers == nil {
-		headers = make(amqp.Table)
-	}
-	headers["retry_count"] = retryCount
-	headers["retry_timestamp"] = time.Now().Unix()
-
-	// Publish with delay (exponential backoff)
-	delay := time.Duration(retryCount*retryCount) * time.Second
-	go func() {
-		time.Sleep(delay)
-		tm.rabbitmq.ch.Publish(
-			"",        // exchange
-			queueName, // routing key
-			false,     // mandatory
-			false,     // immediate
-			amqp.Publishing{
-				ContentType: delivery.ContentType,
-				Body:        delivery.Body,
-				Headers:     headers,
-			},
-		)
-	}()
-}
-
-func (tm *TenantManager) sendToDeadLetter(tenantID, messageID string, payload []byte, errorMsg string, retryCount int) {
+func (tm *TenantManager) sendToDeadLetter(tenantID, messageID string, payload []byte, reason, errorMsg string, retryCount int) {
 	query := `
         INSERT INTO dead_letter_messages 
         (original_message_id, tenant_id, payload, failure_reason, retry_count, last_error)
         VALUES ($1, $2, $3, $4, $5, $6)
     `
-	tm.db.Exec(query, messageID, tenantID, payload, "Max retries exceeded", retryCount, errorMsg)
+	tm.db.Exec(query, messageID, tenantID, payload, reason, retryCount, errorMsg)
+	tm.updateMessageStatus(messageID, tenantID, "failed")
 }
@@ -0,0 +1,496 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Abdurrochman25/multi-tenant-messaging-system/internal/processor"
+)
+
+// execRecorder is a database/sql connector recording the statements
+// executed through it. It supports nothing but Exec, which is all the
+// TenantManager needs.
+type execRecorder struct {
+	mutex sync.Mutex
+	execs []recordedExec
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+func (r *execRecorder) Connect(ctx context.Context) (driver.Conn, error) {
+	return &recorderConn{recorder: r}, nil
+}
+
+func (r *execRecorder) Driver() driver.Driver {
+	return nil
+}
+
+// find returns the arguments of the statements containing fragment.
+func (r *execRecorder) find(fragment string) [][]driver.Value {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var found [][]driver.Value
+	for _, exec := range r.execs {
+		if strings.Contains(exec.query, fragment) {
+			found = append(found, exec.args)
+		}
+	}
+	return found
+}
+
+type recorderConn struct {
+	recorder *execRecorder
+}
+
+func (c *recorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	c.recorder.mutex.Lock()
+	defer c.recorder.mutex.Unlock()
+	c.recorder.execs = append(c.recorder.execs, recordedExec{query: query, args: values})
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *recorderConn) Close() error {
+	return nil
+}
+
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+// eventually fails the test unless cond holds within a second.
+func eventually(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newRecordingTenantManager returns a TenantManager consuming from an
+// in-memory broker and dispatching email messages to handler.
+func newRecordingTenantManager(handler processor.HandlerFunc) (*TenantManager, *MemoryBroker, *execRecorder) {
+	recorder := &execRecorder{}
+	broker := NewMemoryBroker(time.Millisecond)
+	registry := processor.NewRegistry(processor.UnknownReject)
+	registry.Register("email", handler)
+
+	tm := NewTenantManager(sql.OpenDB(recorder), broker, registry, NewWorkerBudget(0), time.Second)
+	return tm, broker, recorder
+}
+
+// prefetchBroker is a MemoryBroker recording the prefetch of its
+// subscriptions.
+type prefetchBroker struct {
+	*MemoryBroker
+
+	mutex    sync.Mutex
+	prefetch int
+}
+
+func (b *prefetchBroker) Consume(tenantID string, prefetch int) (Subscription, error) {
+	sub, err := b.MemoryBroker.Consume(tenantID, prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	b.record(prefetch)
+	return &prefetchSubscription{Subscription: sub, broker: b}, nil
+}
+
+func (b *prefetchBroker) record(prefetch int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.prefetch = prefetch
+}
+
+func (b *prefetchBroker) current() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.prefetch
+}
+
+type prefetchSubscription struct {
+	Subscription
+	broker *prefetchBroker
+}
+
+func (s *prefetchSubscription) SetPrefetch(prefetch int) error {
+	s.broker.record(prefetch)
+	return nil
+}
+
+func TestTenantManagerPrefetchFollowsWorkers(t *testing.T) {
+	broker := &prefetchBroker{MemoryBroker: NewMemoryBroker(time.Millisecond)}
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTenantManager(nil, broker, nil, NewWorkerBudget(0), time.Second)
+	if err := tm.StartConsumer("t1", 3); err != nil {
+		t.Fatal(err)
+	}
+	defer tm.StopTenant("t1")
+
+	if prefetch := broker.current(); prefetch != 3*PrefetchPerWorker {
+		t.Fatalf("expected prefetch %d, got %d", 3*PrefetchPerWorker, prefetch)
+	}
+
+	if err := tm.UpdateConcurrency(context.Background(), "t1", 5); err != nil {
+		t.Fatal(err)
+	}
+	if prefetch := broker.current(); prefetch != 5*PrefetchPerWorker {
+		t.Fatalf("expected prefetch to follow the worker count, got %d", prefetch)
+	}
+
+	// An override sticks until it is removed
+	if err := tm.SetPrefetch("t1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.UpdateConcurrency(context.Background(), "t1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if prefetch := broker.current(); prefetch != 1 {
+		t.Fatalf("expected the override to be kept, got %d", prefetch)
+	}
+
+	if err := tm.SetPrefetch("t1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if prefetch := broker.current(); prefetch != 2*PrefetchPerWorker {
+		t.Fatalf("expected prefetch back at %d, got %d", 2*PrefetchPerWorker, prefetch)
+	}
+}
+
+func TestTenantManagerStopDrainsInFlightMessages(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTenantManager(nil, broker, nil, NewWorkerBudget(0), time.Second)
+	if err := tm.StartConsumer("t1", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A worker busy with a message
+	pool := tm.consumers["t1"].WorkerPool
+	if !pool.Acquire() {
+		t.Fatal("expected a free worker")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		tm.StopTenant("t1")
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("expected stop to wait for the in-flight message")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if tm.Running("t1") {
+		t.Fatal("expected a draining tenant to no longer be running")
+	}
+
+	pool.Release()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected stop to finish once the message was settled")
+	}
+}
+
+func TestTenantManagerDrainGivesUpAfterTimeout(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTenantManager(nil, broker, nil, NewWorkerBudget(0), 50*time.Millisecond)
+	if err := tm.StartConsumer("t1", 1); err != nil {
+		t.Fatal(err)
+	}
+	tm.consumers["t1"].WorkerPool.Acquire()
+
+	start := time.Now()
+	tm.StopAllConsumers()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected stop to give up after the drain timeout, took %s", elapsed)
+	}
+}
+
+func TestTenantManagerUpdateConcurrencySettles(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTenantManager(nil, broker, nil, NewWorkerBudget(0), time.Second)
+	if err := tm.StartConsumer("t1", 2); err != nil {
+		t.Fatal(err)
+	}
+	defer tm.StopTenant("t1")
+
+	pool := tm.consumers["t1"].WorkerPool
+	pool.Acquire()
+	pool.Acquire()
+
+	resized := make(chan error)
+	go func() { resized <- tm.UpdateConcurrency(context.Background(), "t1", 1) }()
+
+	select {
+	case err := <-resized:
+		t.Fatalf("expected the shrink to wait for the busy worker above the new size, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.Release()
+	select {
+	case err := <-resized:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the shrink to finish once the pool settled")
+	}
+	pool.Release()
+
+	// A bounded wait gives up and leaves busy workers to finish in the
+	// background
+	if err := tm.UpdateConcurrency(context.Background(), "t1", 2); err != nil {
+		t.Fatal(err)
+	}
+	pool.Acquire()
+	pool.Acquire()
+	defer pool.Release()
+	defer pool.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tm.UpdateConcurrency(ctx, "t1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if busy, size := pool.Stats(); busy != 2 || size != 0 {
+		t.Fatalf("expected 2 busy workers in a pool of 0, got %d of %d", busy, size)
+	}
+}
+
+func TestTenantManagerRecoverReplacesConsumers(t *testing.T) {
+	var handled atomic.Int32
+	tm, broker, _ := newRecordingTenantManager(func(ctx context.Context, msg *processor.Message) error {
+		handled.Add(1)
+		return nil
+	})
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.StartConsumer("t1", 2); err != nil {
+		t.Fatal(err)
+	}
+	defer tm.StopAllConsumers()
+
+	previous := tm.consumers["t1"]
+	tm.RecoverConsumers()
+
+	current := tm.consumers["t1"]
+	if current == previous || current.WorkerPool != previous.WorkerPool {
+		t.Fatal("expected a new consumer sharing the previous worker pool")
+	}
+	if report := tm.budget.Report(); len(report.Tenants) != 1 {
+		t.Fatalf("expected the tenant to hold a single share, got %+v", report)
+	}
+	select {
+	case <-previous.StopChannel:
+	default:
+		t.Fatal("expected the replaced consumer to be stopped")
+	}
+	if _, open := <-previous.Subscription.Deliveries(); open {
+		t.Fatal("expected the replaced subscription to be closed")
+	}
+
+	// Only the new consumer takes messages
+	ctx := context.Background()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		headers := map[string]any{"message_id": id}
+		if err := broker.PublishMessageWithHeaders(ctx, "t1", []byte(`{"type":"email","data":{}}`), headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, func() bool { return handled.Load() == 3 }, "the messages to be handled")
+	if depth := broker.QueueDepth("t1"); depth != 0 {
+		t.Fatalf("expected the queue to be drained, got %d left", depth)
+	}
+}
+
+func TestTenantManagerRecoverSkipsStoppedTenants(t *testing.T) {
+	broker := NewMemoryBroker(time.Millisecond)
+	tm := NewTenantManager(nil, broker, nil, NewWorkerBudget(0), time.Second)
+	if err := broker.CreateTenantQueue("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.StartConsumer("t1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopped after RecoverConsumers listed it
+	tm.StopTenant("t1")
+	if err := tm.restartConsumer("t1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if tm.Running("t1") {
+		t.Fatal("expected a stopped tenant not to be restarted")
+	}
+}
+
+func TestTenantManagerLifecycle(t *testing.T) {
+	var handled atomic.Int32
+	tm, broker, recorder := newRecordingTenantManager(func(ctx context.Context, msg *processor.Message) error {
+		handled.Add(1)
+		return nil
+	})
+	ctx := context.Background()
+
+	if err := tm.StartTenant(ctx, "t1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Running("t1") {
+		t.Fatal("expected the tenant to be consumed once started")
+	}
+	reports := recorder.find("UPDATE tenants SET status")
+	if len(reports) != 1 || reports[0][1] != TenantStatusProvisioning || reports[0][2] != TenantStatusActive {
+		t.Fatalf("expected the tenant to be reported active, got %v", reports)
+	}
+
+	headers := map[string]any{"message_id": "m1"}
+	if err := broker.PublishMessageWithHeaders(ctx, "t1", []byte(`{"type":"email","data":{}}`), headers); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		for _, args := range recorder.find("UPDATE messages") {
+			if args[0] == "completed" && args[1] == "m1" {
+				return true
+			}
+		}
+		return false
+	}, "the message to be completed")
+	if handled.Load() != 1 {
+		t.Fatalf("expected the message to be handled once, got %d", handled.Load())
+	}
+
+	if err := tm.DeleteTenant(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if tm.Running("t1") {
+		t.Fatal("expected a deleted tenant not to be consumed")
+	}
+	if err := broker.PublishMessageWithHeaders(ctx, "t1", []byte(`{}`), nil); !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("expected the queue to be deleted, got %v", err)
+	}
+	reports = recorder.find("UPDATE tenants SET status")
+	if len(reports) != 2 || reports[1][1] != TenantStatusDeleting || reports[1][2] != TenantStatusStopped {
+		t.Fatalf("expected the tenant to be reported stopped, got %v", reports)
+	}
+}
+
+func TestTenantManagerDeadLetters(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		err     error
+		// attempts is the number of times the handler runs
+		attempts int32
+		reason   string
+		retries  int64
+	}{
+		{
+			name:     "retries a failing message before dead-lettering it",
+			payload:  `{"type":"email","data":{}}`,
+			err:      errors.New("smtp unavailable"),
+			attempts: 4,
+			reason:   "Max retries exceeded",
+			retries:  3,
+		},
+		{
+			name:     "dead-letters a permanent failure at once",
+			payload:  `{"type":"email","data":{}}`,
+			err:      processor.Permanent(errors.New("invalid recipient")),
+			attempts: 1,
+			reason:   "Permanent failure",
+		},
+		{
+			name:    "dead-letters an unknown type at once",
+			payload: `{"type":"fax","data":{}}`,
+			reason:  "Permanent failure",
+		},
+		{
+			name:    "dead-letters a malformed payload at once",
+			payload: `not json`,
+			reason:  "Permanent failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			tm, broker, recorder := newRecordingTenantManager(func(ctx context.Context, msg *processor.Message) error {
+				attempts.Add(1)
+				return tt.err
+			})
+			ctx := context.Background()
+			if err := broker.CreateTenantQueue("t1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.StartConsumer("t1", 1); err != nil {
+				t.Fatal(err)
+			}
+			defer tm.StopAllConsumers()
+
+			headers := map[string]any{"message_id": "m1"}
+			if err := broker.PublishMessageWithHeaders(ctx, "t1", []byte(tt.payload), headers); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, func() bool {
+				return len(recorder.find("INSERT INTO dead_letter_messages")) > 0
+			}, "the message to be dead-lettered")
+
+			dead := recorder.find("INSERT INTO dead_letter_messages")
+			if len(dead) != 1 {
+				t.Fatalf("expected the message to be dead-lettered once, got %d times", len(dead))
+			}
+			if dead[0][0] != "m1" || dead[0][3] != tt.reason || dead[0][4] != tt.retries {
+				t.Fatalf("expected m1 dead-lettered for %q after %d retries, got %v", tt.reason, tt.retries, dead[0])
+			}
+			if attempts.Load() != tt.attempts {
+				t.Fatalf("expected the handler to run %d times, got %d", tt.attempts, attempts.Load())
+			}
+			if depth := broker.QueueDepth("t1"); depth != 0 {
+				t.Fatalf("expected the dead-lettered message to leave the queue, got %d left", depth)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// purgeLockKey is the advisory lock held by the purger running a round, so
+// only one API instance purges at a time.
+const purgeLockKey = 7_265_001
+
+// Purge steps in the order they run. Logs and outbox entries reference
+// messages and have to go before the partition can be detached. Every step
+// can run again after a crash, so a purge resumes at the step it recorded
+// last.
+const (
+	PurgeStepDeleteOutbox      = "delete_outbox"
+	PurgeStepDeleteLogs        = "delete_logs"
+	PurgeStepDetachPartition   = "detach_partition"
+	PurgeStepArchivePartition  = "archive_partition"
+	PurgeStepDropPartition     = "drop_partition"
+	PurgeStepDeleteDeadLetters = "delete_dead_letters"
+	PurgeStepDeleteConfigs     = "delete_configs"
+	PurgeStepDeleteAssignments = "delete_assignments"
+	PurgeStepDeleteUsers       = "delete_users"
+	PurgeStepDeleteTenant      = "delete_tenant"
+	PurgeStepDone              = "done"
+
+	// PurgeStepScheduled is reported for deleted tenants still within their
+	// grace period.
+	PurgeStepScheduled = "scheduled"
+)
+
+var purgeSteps = []string{
+	PurgeStepDeleteOutbox,
+	PurgeStepDeleteLogs,
+	PurgeStepDetachPartition,
+	PurgeStepArchivePartition,
+	PurgeStepDropPartition,
+	PurgeStepDeleteDeadLetters,
+	PurgeStepDeleteConfigs,
+	PurgeStepDeleteAssignments,
+	PurgeStepDeleteUsers,
+	PurgeStepDeleteTenant,
+}
+
+var ErrPurgeNotFound = errors.New("purge not found")
+
+// PurgeConfig tunes the TenantPurger. An empty ArchiveDir drops messages
+// without archiving them.
+type PurgeConfig struct {
+	GracePeriod time.Duration
+	Interval    time.Duration
+	ArchiveDir  string
+}
+
+// TenantPurge is the progress of purging a deleted tenant. Step is the next
+// step to run.
+type TenantPurge struct {
+	TenantID    string     `json:"tenant_id"`
+	TenantName  string     `json:"tenant_name"`
+	Step        string     `json:"step"`
+	StepsDone   int        `json:"steps_done"`
+	Steps       int        `json:"steps"`
+	ArchivePath string     `json:"archive_path,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeletedAt   time.Time  `json:"deleted_at"`
+	PurgeAfter  time.Time  `json:"purge_after"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// TenantPurger removes everything left of a tenant once it has been deleted
+// and stopped for the grace period: its messages partition, processing
+// logs, dead letters, configs, users and finally the tenant row itself.
+// Progress is kept in tenant_purges.
+type TenantPurger struct {
+	db     *sql.DB
+	config PurgeConfig
+}
+
+func NewTenantPurger(db *sql.DB, config PurgeConfig) *TenantPurger {
+	return &TenantPurger{db: db, config: config}
+}
+
+func (p *TenantPurger) Start(ctx context.Context) {
+	go p.run(ctx)
+}
+
+func (p *TenantPurger) run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("tenant purger has stopped")
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.RunOnce(ctx); err != nil {
+			log.Printf("tenant purge failed, err: %s", err.Error())
+		}
+	}
+}
+
+// RunOnce starts purges of tenants past their grace period and continues
+// unfinished ones. It does nothing while another instance holds the purge
+// lock.
+func (p *TenantPurger) RunOnce(ctx context.Context) error {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, purgeLockKey).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, purgeLockKey)
+
+	// A tenant imported again under its old ID after a purge is purged anew
+	_, err = p.db.ExecContext(ctx, `
+        INSERT INTO tenant_purges (tenant_id, tenant_name, step, deleted_at)
+        SELECT id, name, $2, deleted_at FROM tenants
+        WHERE deleted_at < NOW() - $1 * INTERVAL '1 millisecond' AND status = $3
+        ON CONFLICT (tenant_id) DO UPDATE
+        SET tenant_name = EXCLUDED.tenant_name, step = EXCLUDED.step, deleted_at = EXCLUDED.deleted_at,
+            archive_path = NULL, last_error = NULL, started_at = NOW(), updated_at = NOW(), completed_at = NULL
+        WHERE tenant_purges.completed_at IS NOT NULL
+    `, p.config.GracePeriod.Milliseconds(), purgeSteps[0], TenantStatusStopped)
+	if err != nil {
+		return fmt.Errorf("failed to schedule purges: %w", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT tenant_id, step, started_at FROM tenant_purges
+        WHERE completed_at IS NULL
+        ORDER BY started_at
+    `)
+	if err != nil {
+		return err
+	}
+
+	var purges []pendingPurge
+	for rows.Next() {
+		var purge pendingPurge
+		if err := rows.Scan(&purge.tenantID, &purge.step, &purge.startedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		purges = append(purges, purge)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, purge := range purges {
+		if err := p.purge(ctx, purge); err != nil {
+			log.Printf("purge tenant, tenant: %s, err: %s", purge.tenantID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// pendingPurge is an unfinished purge and the step it continues at.
+type pendingPurge struct {
+	tenantID  string
+	step      string
+	startedAt time.Time
+}
+
+// purge runs the remaining steps of a tenant's purge, recording each one
+// once it is done. A failed step is recorded and retried on the next round.
+func (p *TenantPurger) purge(ctx context.Context, purge pendingPurge) error {
+	tenantID := purge.tenantID
+	index := slices.Index(purgeSteps, purge.step)
+	if index < 0 {
+		return fmt.Errorf("unknown purge step %q", purge.step)
+	}
+
+	for i := index; i < len(purgeSteps); i++ {
+		if err := p.runStep(ctx, purge, purgeSteps[i]); err != nil {
+			err = fmt.Errorf("%s: %w", purgeSteps[i], err)
+			if _, recordErr := p.db.ExecContext(ctx, `
+                UPDATE tenant_purges SET last_error = $2, updated_at = NOW() WHERE tenant_id = $1
+            `, tenantID, err.Error()); recordErr != nil {
+				log.Printf("Failed to record purge error of tenant %s: %v", tenantID, recordErr)
+			}
+			return err
+		}
+
+		next := PurgeStepDone
+		if i+1 < len(purgeSteps) {
+			next = purgeSteps[i+1]
+		}
+
+		_, err := p.db.ExecContext(ctx, `
+            UPDATE tenant_purges
+            SET step = $2, last_error = NULL, updated_at = NOW(),
+                completed_at = CASE WHEN $3 THEN NOW() END
+            WHERE tenant_id = $1
+        `, tenantID, next, next == PurgeStepDone)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Purged tenant %s", tenantID)
+
+	return nil
+}
+
+func (p *TenantPurger) runStep(ctx context.Context, purge pendingPurge, step string) error {
+	tenantID := purge.tenantID
+	partition := messagePartition(tenantID)
+
+	switch step {
+	case PurgeStepDeleteOutbox:
+		return p.exec(ctx, `DELETE FROM outbox_messages WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDeleteLogs:
+		return p.exec(ctx, `DELETE FROM message_processing_logs WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDetachPartition:
+		return p.detach(ctx, partition)
+	case PurgeStepArchivePartition:
+		if p.config.ArchiveDir == "" {
+			return nil
+		}
+		return p.archive(ctx, purge, partition)
+	case PurgeStepDropPartition:
+		return p.exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, partition))
+	case PurgeStepDeleteDeadLetters:
+		return p.exec(ctx, `DELETE FROM dead_letter_messages WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDeleteConfigs:
+		return p.exec(ctx, `DELETE FROM tenant_configs WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDeleteAssignments:
+		return p.exec(ctx, `DELETE FROM tenant_assignments WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDeleteUsers:
+		return p.exec(ctx, `DELETE FROM tenant_users WHERE tenant_id = $1`, tenantID)
+	case PurgeStepDeleteTenant:
+		return p.exec(ctx, `DELETE FROM tenants WHERE id = $1 AND deleted_at IS NOT NULL`, tenantID)
+	}
+
+	return fmt.Errorf("unknown purge step %q", step)
+}
+
+func (p *TenantPurger) exec(ctx context.Context, query string, args ...any) error {
+	_, err := p.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// detach detaches the partition from messages concurrently, so that other
+// tenants' messages can still be written meanwhile; a plain detach would
+// lock the whole table. It cannot run in a transaction. A detach
+// interrupted by a crash is left pending and is finalized instead.
+func (p *TenantPurger) detach(ctx context.Context, partition string) error {
+	var attached, pending bool
+	err := p.db.QueryRowContext(ctx, `
+        SELECT COUNT(*) > 0, COALESCE(BOOL_OR(i.inhdetachpending), FALSE)
+        FROM pg_inherits i
+        JOIN pg_class child ON child.oid = i.inhrelid
+        JOIN pg_class parent ON parent.oid = i.inhparent
+        WHERE child.relname = $1 AND parent.relname = 'messages'
+    `, partition).Scan(&attached, &pending)
+	if err != nil || !attached {
+		return err
+	}
+
+	if pending {
+		return p.exec(ctx, fmt.Sprintf(`ALTER TABLE messages DETACH PARTITION %s FINALIZE`, partition))
+	}
+	return p.exec(ctx, fmt.Sprintf(`ALTER TABLE messages DETACH PARTITION %s CONCURRENTLY`, partition))
+}
+
+// archive writes the detached partition as gzipped NDJSON, one message per
+// line. The file is named after the partition and the start of the purge,
+// so purges of a tenant imported again under the same ID do not share one.
+// It only appears under its final name once complete, so an existing
+// archive is kept when the step runs again.
+func (p *TenantPurger) archive(ctx context.Context, purge pendingPurge, partition string) error {
+	tenantID := purge.tenantID
+	path := filepath.Join(p.config.ArchiveDir,
+		fmt.Sprintf("%s_%s.ndjson.gz", partition, purge.startedAt.UTC().Format("20060102T150405Z")))
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		var exists bool
+		err := p.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, partition).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// Dropped before the archive directory was configured
+			return nil
+		}
+
+		if err := p.writeArchive(ctx, partition, path); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return p.exec(ctx, `UPDATE tenant_purges SET archive_path = $2 WHERE tenant_id = $1`, tenantID, path)
+}
+
+func (p *TenantPurger) writeArchive(ctx context.Context, partition, path string) error {
+	if err := os.MkdirAll(p.config.ArchiveDir, 0o750); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(p.config.ArchiveDir, partition+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT row_to_json(m)::text FROM %s m ORDER BY created_at, id`, partition))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	gz := gzip.NewWriter(file)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(gz, line+"\n"); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// List returns every purge, running ones first.
+func (p *TenantPurger) List(ctx context.Context) ([]TenantPurge, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT tenant_id, tenant_name, step, COALESCE(archive_path, ''), COALESCE(last_error, ''),
+               deleted_at, started_at, updated_at, completed_at
+        FROM tenant_purges
+        ORDER BY completed_at DESC NULLS FIRST, started_at DESC
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purges := []TenantPurge{}
+	for rows.Next() {
+		purge, err := p.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		purges = append(purges, *purge)
+	}
+
+	return purges, rows.Err()
+}
+
+// Get returns the purge of a tenant. A deleted tenant whose purge has not
+// started yet is reported as scheduled.
+func (p *TenantPurger) Get(ctx context.Context, tenantID string) (*TenantPurge, error) {
+	purge, err := p.scan(p.db.QueryRowContext(ctx, `
+        SELECT tenant_id, tenant_name, step, COALESCE(archive_path, ''), COALESCE(last_error, ''),
+               deleted_at, started_at, updated_at, completed_at
+        FROM tenant_purges
+        WHERE tenant_id = $1
+    `, tenantID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return purge, err
+	}
+
+	purge = &TenantPurge{TenantID: tenantID, Step: PurgeStepScheduled, Steps: len(purgeSteps)}
+	err = p.db.QueryRowContext(ctx, `
+        SELECT name, deleted_at FROM tenants WHERE id = $1 AND deleted_at IS NOT NULL
+    `, tenantID).Scan(&purge.TenantName, &purge.DeletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPurgeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	purge.PurgeAfter = purge.DeletedAt.Add(p.config.GracePeriod)
+
+	return purge, nil
+}
+
+func (p *TenantPurger) scan(row interface{ Scan(dest ...any) error }) (*TenantPurge, error) {
+	purge := &TenantPurge{Steps: len(purgeSteps)}
+	var startedAt, updatedAt, completedAt sql.NullTime
+	err := row.Scan(&purge.TenantID, &purge.TenantName, &purge.Step, &purge.ArchivePath, &purge.LastError,
+		&purge.DeletedAt, &startedAt, &updatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	purge.StepsDone = slices.Index(purgeSteps, purge.Step)
+	if purge.Step == PurgeStepDone {
+		purge.StepsDone = len(purgeSteps)
+	}
+	purge.PurgeAfter = purge.DeletedAt.Add(p.config.GracePeriod)
+	if startedAt.Valid {
+		purge.StartedAt = &startedAt.Time
+	}
+	if updatedAt.Valid {
+		purge.UpdatedAt = &updatedAt.Time
+	}
+	if completedAt.Valid {
+		purge.CompletedAt = &completedAt.Time
+	}
+
+	return purge, nil
+}
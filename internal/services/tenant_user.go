@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of a tenant user. Admins manage the tenant's users; members use
+// the tenant's API.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// passwordHashCost is the bcrypt cost of stored password hashes.
+const passwordHashCost = 12
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserLocked         = errors.New("user is locked")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidUser        = errors.New("invalid user")
+	ErrTokenRevoked       = errors.New("token revoked")
+)
+
+// dummyPasswordHash is compared against when the user does not exist, so
+// unknown usernames take as long to reject as wrong passwords.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), passwordHashCost)
+	return hash
+})
+
+// TenantUserConfig tunes account lockout.
+type TenantUserConfig struct {
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+}
+
+// TenantUser is a user account of a tenant. The password hash never leaves
+// the service.
+type TenantUser struct {
+	ID           string     `json:"id"`
+	TenantID     string     `json:"tenant_id"`
+	Username     string     `json:"username"`
+	Roles        []string   `json:"roles"`
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	// TokenVersion is carried by the user's tokens; bumping it revokes
+	// every token issued before
+	TokenVersion int `json:"-"`
+}
+
+// TenantUserService manages tenant users and verifies their passwords.
+// Each wrong password counts against the account; MaxFailedLogins in a row
+// lock it for LockoutDuration.
+type TenantUserService struct {
+	db     *sql.DB
+	config TenantUserConfig
+}
+
+func NewTenantUserService(db *sql.DB, config TenantUserConfig) *TenantUserService {
+	config.MaxFailedLogins = max(config.MaxFailedLogins, 1)
+
+	return &TenantUserService{db: db, config: config}
+}
+
+const tenantUserColumns = `
+    id, tenant_id, username, roles, failed_logins, locked_until, disabled_at, last_login_at, created_at, token_version
+`
+
+// Create adds a user to a live tenant. Users without roles are members.
+func (s *TenantUserService) Create(ctx context.Context, tenantID, username, password string, roles []string) (*TenantUser, error) {
+	if len(roles) == 0 {
+		roles = []string{RoleMember}
+	}
+	username = strings.TrimSpace(username)
+	if username == "" || len(username) > 255 {
+		return nil, fmt.Errorf("%w: username must be between 1 and 255 characters", ErrInvalidUser)
+	}
+	if err := validateRoles(roles); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.scan(s.db.QueryRowContext(ctx, `
+        INSERT INTO tenant_users (tenant_id, username, password_hash, roles)
+        SELECT id, $2, $3, $4 FROM tenants WHERE id = $1 AND deleted_at IS NULL
+        RETURNING `+tenantUserColumns,
+		tenantID, username, hash, pq.Array(roles)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrUserExists
+	}
+
+	return user, err
+}
+
+// List returns the users of a tenant by username.
+func (s *TenantUserService) List(ctx context.Context, tenantID string) ([]TenantUser, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+tenantUserColumns+` FROM tenant_users
+        WHERE tenant_id = $1
+        ORDER BY username
+    `, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []TenantUser{}
+	for rows.Next() {
+		user, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+// SetDisabled disables or re-enables a user. Disabled users cannot log in,
+// and the tokens they were issued are revoked.
+func (s *TenantUserService) SetDisabled(ctx context.Context, tenantID, userID string, disabled bool) (*TenantUser, error) {
+	return s.update(ctx, tenantID, userID, `
+        disabled_at = CASE WHEN $3 THEN COALESCE(disabled_at, NOW()) END,
+        token_version = token_version + CASE WHEN $3 AND disabled_at IS NULL THEN 1 ELSE 0 END
+    `, disabled)
+}
+
+// ResetPassword sets a new password, lifts a lockout and revokes the tokens
+// issued with the old password.
+func (s *TenantUserService) ResetPassword(ctx context.Context, tenantID, userID, password string) (*TenantUser, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.update(ctx, tenantID, userID, `
+        password_hash = $3, failed_logins = 0, locked_until = NULL, token_version = token_version + 1
+    `, hash)
+}
+
+// CheckToken fails with ErrTokenRevoked unless the user a token of version
+// tokenVersion was issued to is still enabled and has not had the token
+// revoked since.
+func (s *TenantUserService) CheckToken(ctx context.Context, tenantID, userID string, tokenVersion int) error {
+	var valid bool
+	err := s.db.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM tenant_users
+            WHERE tenant_id = $1 AND id = $2 AND disabled_at IS NULL AND token_version = $3
+        )
+    `, tenantID, userID, tokenVersion).Scan(&valid)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+func (s *TenantUserService) update(ctx context.Context, tenantID, userID, set string, arg any) (*TenantUser, error) {
+	user, err := s.scan(s.db.QueryRowContext(ctx, `
+        UPDATE tenant_users SET `+set+`, updated_at = NOW()
+        WHERE tenant_id = $1 AND id = $2
+        RETURNING `+tenantUserColumns,
+		tenantID, userID, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	return user, err
+}
+
+// Authenticate verifies a user's password. Unknown users, disabled users
+// and wrong passwords all fail with ErrInvalidCredentials; a locked account
+// fails with ErrUserLocked without its password being checked.
+func (s *TenantUserService) Authenticate(ctx context.Context, tenantID, username, password string) (*TenantUser, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var hash string
+	user, err := s.scan(tx.QueryRowContext(ctx, `
+        SELECT `+tenantUserColumns+`, password_hash FROM tenant_users u
+        WHERE tenant_id = $1 AND username = $2
+        AND EXISTS (SELECT 1 FROM tenants t WHERE t.id = u.tenant_id AND t.deleted_at IS NULL)
+        FOR UPDATE
+    `, tenantID, username), &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		return nil, ErrUserLocked
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		failed := user.FailedLogins + 1
+
+		var lockedUntil *time.Time
+		if failed >= s.config.MaxFailedLogins {
+			until := now.Add(s.config.LockoutDuration)
+			lockedUntil, failed = &until, 0
+		}
+
+		_, err := tx.ExecContext(ctx, `
+            UPDATE tenant_users SET failed_logins = $2, locked_until = $3, updated_at = NOW()
+            WHERE id = $1
+        `, user.ID, failed, lockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.DisabledAt != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE tenant_users SET failed_logins = 0, locked_until = NULL, last_login_at = $2
+        WHERE id = $1
+    `, user.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user.FailedLogins, user.LockedUntil, user.LastLoginAt = 0, nil, &now
+
+	return user, nil
+}
+
+// scan reads a row of tenantUserColumns followed by extra.
+func (s *TenantUserService) scan(row interface{ Scan(dest ...any) error }, extra ...any) (*TenantUser, error) {
+	user := &TenantUser{}
+	var lockedUntil, disabledAt, lastLoginAt sql.NullTime
+	dest := append([]any{&user.ID, &user.TenantID, &user.Username, pq.Array(&user.Roles), &user.FailedLogins,
+		&lockedUntil, &disabledAt, &lastLoginAt, &user.CreatedAt, &user.TokenVersion}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if lastLoginAt.Valid {
+		user.LastLoginAt = &lastLoginAt.Time
+	}
+	if user.Roles == nil {
+		user.Roles = []string{}
+	}
+
+	return user, nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	// bcrypt ignores everything past 72 bytes
+	if len(password) < 8 || len(password) > 72 {
+		return nil, fmt.Errorf("%w: password must be between 8 and 72 bytes", ErrInvalidUser)
+	}
+
+	return bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+}
+
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if !slices.Contains([]string{RoleAdmin, RoleMember}, role) {
+			return fmt.Errorf("%w: unknown role %q", ErrInvalidUser, role)
+		}
+	}
+	return nil
+}
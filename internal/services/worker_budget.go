@@ -0,0 +1,245 @@
+package services
+
+import (
+	"slices"
+	"sync"
+)
+
+// SchedulingConfigKey is the tenant_configs key of a tenant's share of the
+// node-wide worker budget.
+const SchedulingConfigKey = "scheduling"
+
+// SchedulingConfig weighs a tenant against the others on the same node.
+// MinWorkers are held for the tenant even while it is idle.
+type SchedulingConfig struct {
+	Weight     int `json:"weight"`
+	MinWorkers int `json:"min_workers"`
+}
+
+// Allocation is a tenant's current use of the worker budget.
+type Allocation struct {
+	TenantID   string `json:"tenant_id"`
+	Weight     int    `json:"weight"`
+	MinWorkers int    `json:"min_workers"`
+	Guaranteed int    `json:"guaranteed"`
+	Workers    int    `json:"workers"`
+	Share      int    `json:"share"`
+	Busy       int    `json:"busy"`
+	Borrowed   int    `json:"borrowed"`
+	Waiting    int    `json:"waiting"`
+}
+
+// BudgetReport is the state of a node's worker budget.
+type BudgetReport struct {
+	Capacity int          `json:"capacity"`
+	Busy     int          `json:"busy"`
+	Tenants  []Allocation `json:"tenants"`
+}
+
+// tenantShare is a tenant's entry in the budget.
+type tenantShare struct {
+	tenantID   string
+	weight     int
+	minWorkers int
+	size       int
+	busy       int
+	waiting    int
+	closed     bool
+}
+
+// WorkerBudget caps how many messages are processed at once on a node,
+// across all tenants. Each tenant is entitled to a share of the capacity in
+// proportion to its weight, and never less than its minimum. A tenant may
+// borrow capacity other tenants leave idle, but only while nobody waits
+// within their own share, so borrowed workers go back to their owner as
+// they finish. Minimums are reserved: borrowing never eats into them. When
+// the minimums add up to more than the capacity, each is scaled down in
+// proportion so that all of them can be held. Every tenant is also capped
+// by its own pool size.
+type WorkerBudget struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	busy     int
+	tenants  []*tenantShare
+}
+
+// NewWorkerBudget creates a budget of capacity workers; zero or less means
+// unlimited, leaving each tenant capped by its pool size alone.
+func NewWorkerBudget(capacity int) *WorkerBudget {
+	b := &WorkerBudget{capacity: capacity}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// Pool registers a tenant pool of the given size with weight 1 and no
+// minimum. A pool the tenant still holds is closed, as if by Close, so that
+// its weight and minimum are not counted twice.
+func (b *WorkerBudget) Pool(tenantID string, size int) *WorkerPool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if tenantID != "" {
+		for _, previous := range slices.Clone(b.tenants) {
+			if previous.tenantID == tenantID && !previous.closed {
+				previous.closed = true
+				b.unregisterIfDone(previous)
+			}
+		}
+	}
+
+	share := &tenantShare{tenantID: tenantID, weight: 1, size: size}
+	b.tenants = append(b.tenants, share)
+	b.cond.Broadcast()
+
+	return &WorkerPool{budget: b, share: share}
+}
+
+// Report returns the budget's capacity and every tenant's allocation.
+func (b *WorkerBudget) Report() BudgetReport {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	report := BudgetReport{Capacity: b.capacity, Busy: b.busy, Tenants: []Allocation{}}
+	for _, t := range b.tenants {
+		share := b.shareOf(t)
+		report.Tenants = append(report.Tenants, Allocation{
+			TenantID:   t.tenantID,
+			Weight:     t.weight,
+			MinWorkers: t.minWorkers,
+			Guaranteed: b.guaranteeOf(t),
+			Workers:    t.size,
+			Share:      share,
+			Busy:       t.busy,
+			Borrowed:   max(t.busy-share, 0),
+			Waiting:    t.waiting,
+		})
+	}
+
+	return report
+}
+
+func (b *WorkerBudget) acquire(t *tenantShare) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t.waiting++
+	for !t.closed && !b.admits(t) {
+		b.cond.Wait()
+	}
+	t.waiting--
+
+	if t.closed {
+		return false
+	}
+
+	t.busy++
+	b.busy++
+	return true
+}
+
+func (b *WorkerBudget) release(t *tenantShare) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t.busy--
+	b.busy--
+	b.unregisterIfDone(t)
+	b.cond.Broadcast()
+}
+
+// admits reports whether t may start another worker now.
+func (b *WorkerBudget) admits(t *tenantShare) bool {
+	if t.busy >= t.size {
+		return false
+	}
+	if b.capacity <= 0 {
+		return true
+	}
+	if b.busy >= b.capacity {
+		return false
+	}
+
+	// Guaranteed minimum, reserved while the tenant was idle
+	if t.busy < b.guaranteeOf(t) {
+		return true
+	}
+
+	if b.busy+b.reservedFor(t) >= b.capacity {
+		return false
+	}
+	if t.busy < b.shareOf(t) {
+		return true
+	}
+
+	// Borrowing idle capacity yields to tenants waiting within their share
+	for _, other := range b.tenants {
+		if other != t && other.waiting > 0 && !other.closed &&
+			other.busy < other.size && other.busy < b.shareOf(other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reservedFor returns the unused minimums of every tenant but t.
+func (b *WorkerBudget) reservedFor(t *tenantShare) int {
+	reserved := 0
+	for _, other := range b.tenants {
+		if other != t && !other.closed {
+			reserved += max(b.guaranteeOf(other)-other.busy, 0)
+		}
+	}
+	return reserved
+}
+
+// guaranteeOf returns the workers held for t: its minimum, at most its pool
+// size, scaled down when the minimums of all tenants exceed the capacity.
+func (b *WorkerBudget) guaranteeOf(t *tenantShare) int {
+	guarantee := min(t.minWorkers, t.size)
+	if b.capacity <= 0 {
+		return guarantee
+	}
+
+	total := 0
+	for _, other := range b.tenants {
+		if !other.closed {
+			total += min(other.minWorkers, other.size)
+		}
+	}
+	if total <= b.capacity {
+		return guarantee
+	}
+
+	return guarantee * b.capacity / total
+}
+
+// shareOf returns the workers t is entitled to: its weighted part of the
+// capacity, at least its guarantee and at most its pool size.
+func (b *WorkerBudget) shareOf(t *tenantShare) int {
+	if b.capacity <= 0 {
+		return t.size
+	}
+
+	weights := 0
+	for _, other := range b.tenants {
+		if !other.closed {
+			weights += other.weight
+		}
+	}
+	if weights == 0 {
+		return b.guaranteeOf(t)
+	}
+
+	return min(max(b.capacity*t.weight/weights, b.guaranteeOf(t)), t.size)
+}
+
+// unregisterIfDone drops a closed tenant once its last worker finished.
+func (b *WorkerBudget) unregisterIfDone(t *tenantShare) {
+	if t.closed && t.busy == 0 {
+		b.tenants = slices.DeleteFunc(b.tenants, func(other *tenantShare) bool {
+			return other == t
+		})
+	}
+}
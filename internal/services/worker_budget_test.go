@@ -0,0 +1,150 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func acquireAsync(pool *WorkerPool) <-chan bool {
+	acquired := make(chan bool, 1)
+	go func() { acquired <- pool.Acquire() }()
+	return acquired
+}
+
+func expectAcquired(t *testing.T, acquired <-chan bool, want bool, what string) {
+	t.Helper()
+
+	select {
+	case <-acquired:
+		if !want {
+			t.Fatalf("expected %s to wait", what)
+		}
+	case <-time.After(50 * time.Millisecond):
+		if want {
+			t.Fatalf("expected %s to get a worker", what)
+		}
+	}
+}
+
+func TestWorkerBudgetReclaimsBorrowedWorkers(t *testing.T) {
+	budget := NewWorkerBudget(4)
+	noisy := budget.Pool("noisy", 10)
+	quiet := budget.Pool("quiet", 10)
+
+	// The idle tenant's share is lent out
+	for range 4 {
+		if !noisy.Acquire() {
+			t.Fatal("expected noisy tenant to borrow idle capacity")
+		}
+	}
+
+	quietWaits := acquireAsync(quiet)
+	noisyWaits := acquireAsync(noisy)
+	expectAcquired(t, quietWaits, false, "quiet tenant on a full budget")
+
+	// A freed worker goes back to the tenant below its share
+	noisy.Release()
+	expectAcquired(t, quietWaits, true, "quiet tenant within its share")
+	expectAcquired(t, noisyWaits, false, "noisy tenant above its share")
+
+	report := budget.Report()
+	if report.Busy != 4 || report.Tenants[0].Share != 2 || report.Tenants[0].Borrowed != 1 || report.Tenants[0].Waiting != 1 {
+		t.Fatalf("unexpected allocation %+v", report)
+	}
+
+	// With nobody else waiting it may borrow again
+	noisy.Release()
+	expectAcquired(t, noisyWaits, true, "noisy tenant borrowing again")
+}
+
+func TestWorkerBudgetGuaranteesMinimum(t *testing.T) {
+	budget := NewWorkerBudget(2)
+	noisy := budget.Pool("noisy", 10)
+	quiet := budget.Pool("quiet", 10)
+	quiet.Schedule(SchedulingConfig{Weight: 1, MinWorkers: 1})
+	noisy.Schedule(SchedulingConfig{Weight: 3})
+
+	if !noisy.Acquire() {
+		t.Fatal("expected noisy tenant to get a worker")
+	}
+	// The remaining worker is held for the quiet tenant's minimum
+	expectAcquired(t, acquireAsync(noisy), false, "noisy tenant on a reserved worker")
+	expectAcquired(t, acquireAsync(quiet), true, "quiet tenant within its minimum")
+}
+
+func TestWorkerBudgetScalesDownOvercommittedMinimums(t *testing.T) {
+	budget := NewWorkerBudget(4)
+	first := budget.Pool("first", 10)
+	second := budget.Pool("second", 10)
+	first.Schedule(SchedulingConfig{Weight: 3, MinWorkers: 4})
+	second.Schedule(SchedulingConfig{Weight: 1, MinWorkers: 4})
+
+	for _, allocation := range budget.Report().Tenants {
+		if allocation.MinWorkers != 4 || allocation.Guaranteed != 2 {
+			t.Fatalf("expected minimums of 4 scaled down to 2, got %+v", allocation)
+		}
+	}
+
+	for range 2 {
+		if !first.Acquire() {
+			t.Fatal("expected first tenant to get a worker")
+		}
+	}
+	// The rest is held for the second tenant's scaled down minimum
+	expectAcquired(t, acquireAsync(first), false, "first tenant on a reserved worker")
+	expectAcquired(t, acquireAsync(second), true, "second tenant within its minimum")
+
+	// Once the second tenant leaves the first keeps its whole minimum
+	second.Close()
+	if allocation := budget.Report().Tenants[0]; allocation.Guaranteed != 4 {
+		t.Fatalf("expected the full minimum to be held again, got %+v", allocation)
+	}
+}
+
+func TestWorkerBudgetClosedPoolLeaves(t *testing.T) {
+	budget := NewWorkerBudget(2)
+	first := budget.Pool("t1", 2)
+	if !first.Acquire() {
+		t.Fatal("expected a free worker")
+	}
+
+	// A restarted consumer registers again while the old one drains
+	first.Close()
+	second := budget.Pool("t1", 2)
+	if !second.Acquire() {
+		t.Fatal("expected the new pool to get a worker")
+	}
+	if report := budget.Report(); len(report.Tenants) != 2 || report.Busy != 2 {
+		t.Fatalf("unexpected allocation %+v", report)
+	}
+
+	first.Release()
+	if report := budget.Report(); len(report.Tenants) != 1 || report.Busy != 1 {
+		t.Fatalf("expected closed pool to leave the budget, got %+v", report)
+	}
+}
+
+func TestWorkerBudgetPoolReplacesOpenShare(t *testing.T) {
+	budget := NewWorkerBudget(4)
+	first := budget.Pool("t1", 4)
+	first.Schedule(SchedulingConfig{Weight: 1, MinWorkers: 2})
+	other := budget.Pool("t2", 4)
+	other.Schedule(SchedulingConfig{Weight: 1, MinWorkers: 2})
+
+	// Registering the tenant again, e.g. for a restarted consumer
+	second := budget.Pool("t1", 4)
+	second.Schedule(SchedulingConfig{Weight: 1, MinWorkers: 2})
+
+	report := budget.Report()
+	if len(report.Tenants) != 2 {
+		t.Fatalf("expected a single share per tenant, got %+v", report)
+	}
+	for _, allocation := range report.Tenants {
+		if allocation.Guaranteed != 2 || allocation.Share != 2 {
+			t.Fatalf("expected each tenant to keep half the budget, got %+v", allocation)
+		}
+	}
+	if first.Acquire() {
+		t.Fatal("expected the replaced pool to be closed")
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import "context"
+
+// WorkerPool is a tenant's claim on a WorkerBudget. Its size caps how many
+// messages of the tenant are processed at once; the budget decides how many
+// of those may run given what other tenants use. It can be resized while
+// workers are busy: growing admits waiting deliveries right away, shrinking
+// takes effect as busy workers finish.
+type WorkerPool struct {
+	budget *WorkerBudget
+	share  *tenantShare
+}
+
+// NewWorkerPool creates a pool of its own, outside any shared budget.
+func NewWorkerPool(size int) *WorkerPool {
+	return NewWorkerBudget(0).Pool("", size)
+}
+
+// Acquire blocks until a worker is free. It returns false once the pool is
+// closed.
+func (p *WorkerPool) Acquire() bool {
+	return p.budget.acquire(p.share)
+}
+
+// Release returns a worker acquired with Acquire.
+func (p *WorkerPool) Release() {
+	p.budget.release(p.share)
+}
+
+func (p *WorkerPool) Resize(size int) {
+	p.budget.mutex.Lock()
+	defer p.budget.mutex.Unlock()
+
+	p.share.size = size
+	p.budget.cond.Broadcast()
+}
+
+// Settle blocks until no more workers are busy than the pool size, e.g.
+// after shrinking it, or until ctx is done.
+func (p *WorkerPool) Settle(ctx context.Context) error {
+	return p.waitUntil(ctx, func() bool { return p.share.busy <= p.share.size })
+}
+
+// Drain blocks until no worker is busy, or until ctx is done.
+func (p *WorkerPool) Drain(ctx context.Context) error {
+	return p.waitUntil(ctx, func() bool { return p.share.busy == 0 })
+}
+
+// waitUntil waits for done, checked with the budget locked.
+func (p *WorkerPool) waitUntil(ctx context.Context, done func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		p.budget.mutex.Lock()
+		defer p.budget.mutex.Unlock()
+		p.budget.cond.Broadcast()
+	})
+	defer stop()
+
+	p.budget.mutex.Lock()
+	defer p.budget.mutex.Unlock()
+
+	for !done() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.budget.cond.Wait()
+	}
+
+	return nil
+}
+
+// Schedule sets the tenant's weight and guaranteed minimum in the budget.
+func (p *WorkerPool) Schedule(config SchedulingConfig) {
+	p.budget.mutex.Lock()
+	defer p.budget.mutex.Unlock()
+
+	p.share.weight = max(config.Weight, 1)
+	p.share.minWorkers = max(config.MinWorkers, 0)
+	p.budget.cond.Broadcast()
+}
+
+// Close wakes and fails every pending Acquire and gives the tenant's share
+// back to the budget once busy workers Release.
+func (p *WorkerPool) Close() {
+	p.budget.mutex.Lock()
+	defer p.budget.mutex.Unlock()
+
+	p.share.closed = true
+	p.budget.unregisterIfDone(p.share)
+	p.budget.cond.Broadcast()
+}
+
+// Stats returns the number of busy workers and the pool size.
+func (p *WorkerPool) Stats() (busy, size int) {
+	p.budget.mutex.Lock()
+	defer p.budget.mutex.Unlock()
+
+	return p.share.busy, p.share.size
+}
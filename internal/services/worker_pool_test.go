@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolResize(t *testing.T) {
+	pool := NewWorkerPool(1)
+	if !pool.Acquire() {
+		t.Fatal("expected a free worker")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- pool.Acquire() }()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected acquire to block on a full pool")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pool.Resize(2)
+	if !<-acquired {
+		t.Fatal("expected growing the pool to admit the waiting worker")
+	}
+
+	// Shrinking below the busy count waits for workers to finish
+	pool.Resize(1)
+	pool.Release()
+	if busy, size := pool.Stats(); busy != 1 || size != 1 {
+		t.Fatalf("expected 1 of 1 workers busy, got %d of %d", busy, size)
+	}
+
+	go func() { acquired <- pool.Acquire() }()
+	pool.Close()
+	if <-acquired {
+		t.Fatal("expected acquire to fail on a closed pool")
+	}
+}
+
+func TestWorkerPoolSettleWaitsForBusyWorkers(t *testing.T) {
+	pool := NewWorkerPool(2)
+	pool.Acquire()
+	pool.Acquire()
+	pool.Resize(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Settle(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected settle to wait for the busy worker, got %v", err)
+	}
+
+	settled := make(chan error, 1)
+	go func() { settled <- pool.Settle(context.Background()) }()
+	pool.Release()
+
+	select {
+	case err := <-settled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected settle to return once the worker finished")
+	}
+}